@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+)
+
+type apiRole int
+
+const (
+	roleNone apiRole = iota
+	rolePublic
+	roleRead
+	roleAdmin
+)
+
+func parseRole(s string, def apiRole) (apiRole, error) {
+	switch s {
+	case "":
+		return def, nil
+	case "public":
+		return rolePublic, nil
+	case "read":
+		return roleRead, nil
+	case "admin":
+		return roleAdmin, nil
+	default:
+		return roleNone, fmt.Errorf("invalid role %s", s)
+	}
+}
+
+type apiToken struct {
+	token []byte
+	role  apiRole
+}
+
+type apiRoute struct {
+	path   string
+	method string
+	role   apiRole
+}
+
+// apiAuth authenticates api requests and checks their roles.
+type apiAuth struct {
+	enabled   bool
+	tokens    []apiToken
+	certRoles map[string]apiRole // client cert common name -> role
+	routes    []apiRoute
+}
+
+// newAPIServerOpts validates cfg and returns the api auth and the tls config
+// of the api server. The tls config is nil if the api server serves plain http.
+func newAPIServerOpts(cfg APIConfig) (*apiAuth, *tls.Config, error) {
+	a := &apiAuth{certRoles: make(map[string]apiRole)}
+	for i, tc := range cfg.Auth.Tokens {
+		token := tc.Token
+		if len(tc.TokenFile) > 0 {
+			b, err := os.ReadFile(tc.TokenFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read token #%d, %w", i, err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		if len(token) == 0 {
+			return nil, nil, fmt.Errorf("token #%d is empty", i)
+		}
+		role, err := parseRole(tc.Role, roleRead)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid token #%d, %w", i, err)
+		}
+		a.tokens = append(a.tokens, apiToken{token: []byte(token), role: role})
+	}
+	for _, cc := range cfg.Auth.ClientCerts {
+		role, err := parseRole(cc.Role, roleRead)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid client cert %s, %w", cc.CommonName, err)
+		}
+		a.certRoles[cc.CommonName] = role
+	}
+	for _, rc := range cfg.Auth.Routes {
+		role, err := parseRole(rc.Role, roleNone)
+		if err != nil || role == roleNone {
+			return nil, nil, fmt.Errorf("invalid role %q for route %s", rc.Role, rc.Path)
+		}
+		a.routes = append(a.routes, apiRoute{path: rc.Path, method: strings.ToUpper(rc.Method), role: role})
+	}
+	a.enabled = len(a.tokens) > 0 || len(cfg.Auth.ClientCAs) > 0
+
+	var tlsConfig *tls.Config
+	switch {
+	case len(cfg.Cert) > 0 || len(cfg.Key) > 0:
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load api server cert, %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if len(cfg.Auth.ClientCAs) > 0 {
+			pool, err := utils.LoadCertPool(cfg.Auth.ClientCAs)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to load client cas, %w", err)
+			}
+			tlsConfig.ClientCAs = pool
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			if len(a.tokens) > 0 { // Clients may use tokens instead.
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+		}
+	case len(cfg.Auth.ClientCAs) > 0:
+		return nil, nil, errors.New("client certificate auth requires api cert and key")
+	}
+	return a, tlsConfig, nil
+}
+
+// requiredRole returns the role that is required by req.
+func (a *apiAuth) requiredRole(req *http.Request) apiRole {
+	p := req.URL.Path
+	var matched *apiRoute
+	for i := range a.routes {
+		r := &a.routes[i]
+		if len(r.method) > 0 && r.method != req.Method {
+			continue
+		}
+		if p != r.path && !strings.HasPrefix(p, strings.TrimSuffix(r.path, "/")+"/") {
+			continue
+		}
+		if matched == nil || len(r.path) > len(matched.path) ||
+			(len(r.path) == len(matched.path) && len(r.method) > 0) {
+			matched = r
+		}
+	}
+	if matched != nil {
+		return matched.role
+	}
+
+	if p == "/debug/pprof" || strings.HasPrefix(p, "/debug/pprof/") {
+		return roleAdmin
+	}
+	// The web dashboard is static files. It sends the token by itself.
+	isGet := req.Method == http.MethodGet || req.Method == http.MethodHead
+	if isGet && (p == "/ui" || strings.HasPrefix(p, "/ui/")) {
+		return rolePublic
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return roleRead
+	default:
+		return roleAdmin
+	}
+}
+
+// role returns the role of the client that sent req.
+// It returns roleNone if the client is not authenticated.
+func (a *apiAuth) role(req *http.Request) apiRole {
+	role := roleNone
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), t.token) == 1 {
+				role = max(role, t.role)
+			}
+		}
+	}
+	// Certificates in VerifiedChains were verified by client cas.
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		certRole, ok := a.certRoles[req.TLS.VerifiedChains[0][0].Subject.CommonName]
+		if !ok {
+			certRole = roleRead
+		}
+		role = max(role, certRole)
+	}
+	return role
+}
+
+// wrap returns a handler that checks requests before passing them to next.
+func (a *apiAuth) wrap(next http.Handler) http.Handler {
+	if !a.enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		required := a.requiredRole(req)
+		if required > rolePublic {
+			switch role := a.role(req); {
+			case role == roleNone:
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			case role < required:
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_apiAuth(t *testing.T) {
+	a, tlsConfig, err := newAPIServerOpts(APIConfig{
+		Auth: APIAuthConfig{
+			Tokens: []APITokenConfig{
+				{Token: "r"},
+				{Token: "a", Role: "admin"},
+			},
+			Routes: []APIRouteConfig{
+				{Path: "/health", Role: "public"},
+				{Path: "/plugins/cache/", Method: "post", Role: "read"},
+				{Path: "/plugins/cache/load_dump", Role: "admin"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		t.Fatal("unexpected tls config")
+	}
+	h := a.wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"GET", "/health", "", http.StatusOK},
+		{"GET", "/metrics", "", http.StatusUnauthorized},
+		{"GET", "/metrics", "wrong", http.StatusUnauthorized},
+		{"GET", "/metrics", "r", http.StatusOK},
+		{"GET", "/debug/pprof/", "r", http.StatusForbidden},
+		{"GET", "/debug/pprof/", "a", http.StatusOK},
+		{"GET", "/ui/app.js", "", http.StatusOK},
+		{"POST", "/ui/", "", http.StatusUnauthorized},
+		{"POST", "/reload", "r", http.StatusForbidden},
+		{"POST", "/reload", "a", http.StatusOK},
+		{"POST", "/plugins/cache/flush", "r", http.StatusOK},
+		{"POST", "/plugins/cache/load_dump", "r", http.StatusForbidden},
+		{"POST", "/plugins/cache_x/flush", "r", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if len(tt.token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s %s with token %q: got %d, want %d", tt.method, tt.path, tt.token, w.Code, tt.want)
+		}
+	}
+
+	// Verified client certs.
+	a.certRoles["ops"] = roleAdmin
+	newCertReq := func(cn string) *http.Request {
+		req := httptest.NewRequest("POST", "/reload", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+		return req
+	}
+	if r := a.role(newCertReq("ops")); r != roleAdmin {
+		t.Fatalf("want admin role, got %d", r)
+	}
+	if r := a.role(newCertReq("someone")); r != roleRead {
+		t.Fatalf("want read role, got %d", r)
+	}
+
+	if _, _, err := newAPIServerOpts(APIConfig{Auth: APIAuthConfig{ClientCAs: []string{"ca.pem"}}}); err == nil {
+		t.Fatal("client cas without cert should be an error")
+	}
+}
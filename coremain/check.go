@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/safe_close"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// CheckConfigFile loads the config file and checks it. See CheckConfig.
+func CheckConfigFile(filePath string) error {
+	cfg, fileUsed, err := loadConfig(filePath)
+	if err != nil {
+		return fmt.Errorf("fail to load config, %w", err)
+	}
+	return checkConfig(cfg, fileUsed)
+}
+
+// CheckConfig loads all plugins from cfg in dry-run mode (see BP.DryRun)
+// and closes them. It continues after errors and returns all errors found.
+func CheckConfig(cfg *Config) error {
+	return checkConfig(cfg, "")
+}
+
+func checkConfig(cfg *Config, cfgFile string) error {
+	var errs []error
+	if err := cfg.Log.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid log config, %w", err))
+	}
+	if _, _, err := newAPIServerOpts(cfg.API); err != nil {
+		errs = append(errs, fmt.Errorf("invalid api config, %w", err))
+	}
+	if err := cfg.Tracing.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid tracing config, %w", err))
+	}
+
+	m := &Mosdns{
+		logger:     mlog.L().WithOptions(zap.IncreaseLevel(zap.WarnLevel)),
+		cfgFile:    cfgFile,
+		dryRun:     true,
+		httpMux:    chi.NewRouter(),
+		metricsReg: newMetricsReg(),
+		sc:         safe_close.NewSafeClose(),
+	}
+	g, err := m.loadGraph(cfg, nil)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		m.closeGraph(g, nil)
+	}
+	errs = append(errs, m.checkErrs...)
+	return errors.Join(errs...)
+}
+
+// checkErr records err and returns nil if m is in dry-run mode.
+// Otherwise, it returns err.
+func (m *Mosdns) checkErr(file string, err error) error {
+	if !m.dryRun {
+		return err
+	}
+	if len(file) > 0 {
+		err = fmt.Errorf("%s: %w", file, err)
+	}
+	m.checkErrs = append(m.checkErrs, err)
+	return nil
+}
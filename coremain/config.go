@@ -21,13 +21,21 @@ package coremain
 
 import (
 	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
 )
 
 type Config struct {
 	Log     mlog.LogConfig `yaml:"log"`
-	Include []string       `yaml:"include"`
+	Include []string       `yaml:"include"` // File paths or glob patterns.
 	Plugins []PluginConfig `yaml:"plugins"`
 	API     APIConfig      `yaml:"api"`
+
+	Shutdown ShutdownConfig `yaml:"shutdown"`
+	Tracing  trace.Config   `yaml:"tracing"`
+
+	// Vars can be used in plugin args as "${NAME}". "${env:NAME}" refers
+	// to an environment variable. See expandString.
+	Vars map[string]string `yaml:"vars"`
 }
 
 // PluginConfig represents a plugin config
@@ -45,6 +53,61 @@ type PluginConfig struct {
 	Args any `yaml:"args"`
 }
 
+type ShutdownConfig struct {
+	// DrainTimeout is the maximum seconds to wait for running queries on
+	// shutdown or reload. Servers stop accepting new queries while draining.
+	// Default is 5.
+	DrainTimeout int `yaml:"drain_timeout"`
+}
+
 type APIConfig struct {
 	HTTP string `yaml:"http"`
+
+	// If Cert and Key are set, the api server serves https.
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+
+	// DisablePprof removes "/debug/pprof" from the api server.
+	DisablePprof bool `yaml:"disable_pprof"`
+
+	// DisableUI removes the web dashboard at "/ui" from the api server.
+	DisableUI bool `yaml:"disable_ui"`
+
+	Auth APIAuthConfig `yaml:"auth"`
+}
+
+// APIAuthConfig configures authentication of the api server.
+// If no token and no client ca is configured, all requests are allowed.
+// Roles are "public" (no authentication), "read" and "admin".
+type APIAuthConfig struct {
+	// Bearer tokens.
+	Tokens []APITokenConfig `yaml:"tokens"`
+
+	// Client certificate auth, requires Cert and Key. Clients with a certificate
+	// signed by one of ClientCAs are authenticated. Their roles are looked up by
+	// the certificate's common name in ClientCerts. Default role is "read".
+	ClientCAs   []string              `yaml:"client_cas"`
+	ClientCerts []APIClientCertConfig `yaml:"client_certs"`
+
+	// Routes overrides the role that is required by requests. By default,
+	// GET, HEAD and OPTIONS requests require "read" and others require "admin".
+	// "/debug/pprof" always requires "admin" by default.
+	Routes []APIRouteConfig `yaml:"routes"`
+}
+
+type APITokenConfig struct {
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"` // Read token from this file instead.
+	Role      string `yaml:"role"`       // Default is "read".
+}
+
+type APIClientCertConfig struct {
+	CommonName string `yaml:"common_name"`
+	Role       string `yaml:"role"`
+}
+
+type APIRouteConfig struct {
+	Path   string `yaml:"path"`   // Path prefix. The longest matched prefix is used.
+	Method string `yaml:"method"` // Optional.
+	Role   string `yaml:"role"`
 }
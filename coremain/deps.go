@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// TagReferrer can be implemented by plugin args that refer to other
+// plugins by tag without a "$" prefix (e.g. a server's entry).
+// Words with a "$" prefix in args are detected automatically.
+type TagReferrer interface {
+	ReferredTags() []string
+}
+
+// declaredPlugin is a plugin config and where it was declared.
+type declaredPlugin struct {
+	PluginConfig
+	file string // Can be empty if the config was not loaded from a file.
+	idx  int    // Index in its config file.
+}
+
+func (p declaredPlugin) String() string {
+	s := fmt.Sprintf("plugin #%d %s", p.idx, p.Tag)
+	if len(p.file) > 0 {
+		s += " from " + p.file
+	}
+	return s
+}
+
+// pluginRefs returns the tags that pc refers to. It may contain
+// words that are not tags. Callers should ignore unknown tags.
+func pluginRefs(pc PluginConfig) []string {
+	var refs []string
+	scanTagRefs(reflect.ValueOf(pc.Args), &refs)
+
+	if typeInfo, ok := GetPluginType(pc.Type); ok {
+		args, _ := decodePluginArgs(typeInfo, pc.Args) // Invalid args will be reported by newPlugin.
+		if r, ok := args.(TagReferrer); ok {
+			refs = append(refs, r.ReferredTags()...)
+		}
+	}
+	return refs
+}
+
+// PluginRefs returns the tags that a plugin of typ with args refers to.
+// Plugins that create inline plugins use it to report their refs.
+func PluginRefs(typ string, args any) []string {
+	return pluginRefs(PluginConfig{Type: typ, Args: args})
+}
+
+// scanTagRefs appends all "$" prefixed words in strings of v to refs.
+// A "!" before "$" is allowed.
+func scanTagRefs(v reflect.Value, refs *[]string) {
+	switch v.Kind() {
+	case reflect.String:
+		for _, w := range strings.Fields(v.String()) {
+			w = strings.TrimPrefix(w, "!")
+			if tag, ok := strings.CutPrefix(w, "$"); ok && len(tag) > 0 {
+				*refs = append(*refs, tag)
+			}
+		}
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			scanTagRefs(v.Elem(), refs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			scanTagRefs(v.Index(i), refs)
+		}
+	case reflect.Map:
+		// Sort keys so that the load order is deterministic.
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			scanTagRefs(v.MapIndex(k), refs)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				scanTagRefs(v.Field(i), refs)
+			}
+		}
+	}
+}
+
+// sortPlugins sorts ps so that plugins are loaded after the plugins they
+// refer to. Otherwise, the declaration order is kept. Refs to unknown tags
+// are ignored. If ps has reference cycles, the returned slice still contains
+// all plugins and the error reports each cycle with its full path.
+func sortPlugins(ps []declaredPlugin) ([]declaredPlugin, error) {
+	idx := make(map[string]int, len(ps))
+	for i, p := range ps {
+		if len(p.Tag) == 0 {
+			continue
+		}
+		if _, dup := idx[p.Tag]; !dup { // Duplicated tags will be reported by newPlugin.
+			idx[p.Tag] = i
+		}
+	}
+
+	deps := make([][]int, len(ps))
+	for i, p := range ps {
+		for _, ref := range pluginRefs(p.PluginConfig) {
+			if j, ok := idx[ref]; ok {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(ps))
+	sorted := make([]declaredPlugin, 0, len(ps))
+	var stack []int
+	var cycles []string
+	var visit func(i int)
+	visit = func(i int) {
+		switch state[i] {
+		case done:
+			return
+		case visiting:
+			var path []string
+			for k := len(stack) - 1; k >= 0; k-- {
+				if stack[k] == i {
+					for _, j := range stack[k:] {
+						path = append(path, ps[j].Tag)
+					}
+					break
+				}
+			}
+			path = append(path, ps[i].Tag)
+			cycles = append(cycles, strings.Join(path, " -> "))
+			return
+		}
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, j := range deps[i] {
+			visit(j)
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = done
+		sorted = append(sorted, ps[i])
+	}
+	for i := range ps {
+		visit(i)
+	}
+
+	if len(cycles) > 0 {
+		return sorted, fmt.Errorf("plugin reference cycle: %s", strings.Join(cycles, "; "))
+	}
+	return sorted, nil
+}
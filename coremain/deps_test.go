@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_sortPlugins(t *testing.T) {
+	newPs := func(cfgs ...PluginConfig) []declaredPlugin {
+		var ps []declaredPlugin
+		for i, c := range cfgs {
+			ps = append(ps, declaredPlugin{PluginConfig: c, idx: i})
+		}
+		return ps
+	}
+	tags := func(ps []declaredPlugin) []string {
+		var s []string
+		for _, p := range ps {
+			s = append(s, p.Tag)
+		}
+		return s
+	}
+
+	ps := newPs(
+		PluginConfig{Tag: "main", Args: []any{map[string]any{"matches": []string{"!$m", "qname $set"}, "exec": "$sub"}}},
+		PluginConfig{Tag: "sub", Args: "$set $unknown"},
+		PluginConfig{Tag: "m"},
+		PluginConfig{Tag: "set"},
+		PluginConfig{Tag: "other"},
+	)
+	sorted, err := sortPlugins(ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tags(sorted), []string{"set", "sub", "m", "main", "other"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sortPlugins() = %v, want %v", got, want)
+	}
+
+	ps = newPs(
+		PluginConfig{Tag: "a", Args: "$b"},
+		PluginConfig{Tag: "b", Args: map[string]any{"x": "$c"}},
+		PluginConfig{Tag: "c", Args: "$a"},
+		PluginConfig{Tag: "d"},
+	)
+	sorted, err = sortPlugins(ps)
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Fatalf("want cycle error, got %v", err)
+	}
+	if len(sorted) != len(ps) {
+		t.Fatalf("sortPlugins() should return all plugins, got %v", tags(sorted))
+	}
+
+	// A plugin that refers to itself.
+	if _, err := sortPlugins(newPs(PluginConfig{Tag: "a", Args: "$a"})); err == nil || !strings.Contains(err.Error(), "a -> a") {
+		t.Fatalf("want self reference error, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pluginGraph is a set of plugins that were loaded from the same config.
+// Mosdns serves one graph at a time. A reload builds a new graph and
+// replaces the old one.
+type pluginGraph struct {
+	plugins    map[string]any
+	order      []string             // Tags of plugins from config, in load order.
+	apiMux     *chi.Mux             // plugin apis, mounted at "/plugins"
+	metricsReg *prometheus.Registry // plugin metrics
+
+	// Plugin dependencies, recorded while plugins are loading.
+	// See pluginGraph.recordRef.
+	types      map[string]string              // tag -> plugin type, empty for preset plugins
+	refs       map[string]map[string]struct{} // tag -> tags it refers to
+	servers    map[string]struct{}
+	loadingTag string
+
+	// tracer is shared with the previous graph if tracing is the same.
+	// It is nil if tracing is disabled.
+	tracer  *trace.Tracer
+	tracing trace.Config
+
+	// prev is the graph that is being replaced by this graph.
+	// It is only available while this graph is loading.
+	prev        *pluginGraph
+	commitHooks []func()
+
+	m         sync.RWMutex
+	closing   atomic.Bool
+	inflightN atomic.Int64
+	idle      chan struct{} // Notified when the last query exits while closing.
+}
+
+func newPluginGraph(plugins map[string]any, prev *pluginGraph) *pluginGraph {
+	if plugins == nil {
+		plugins = make(map[string]any)
+	}
+	return &pluginGraph{
+		plugins:    plugins,
+		types:      make(map[string]string),
+		refs:       make(map[string]map[string]struct{}),
+		servers:    make(map[string]struct{}),
+		apiMux:     chi.NewRouter(),
+		metricsReg: prometheus.NewRegistry(),
+		prev:       prev,
+		idle:       make(chan struct{}, 1),
+	}
+}
+
+// recordRef records that the plugin being loaded refers to plugin "to".
+func (g *pluginGraph) recordRef(to string) {
+	from := g.loadingTag
+	if len(from) == 0 || from == to {
+		return
+	}
+	s := g.refs[from]
+	if s == nil {
+		s = make(map[string]struct{})
+		g.refs[from] = s
+	}
+	s[to] = struct{}{}
+}
+
+// commit runs commit hooks. It should be called once the graph becomes live.
+func (g *pluginGraph) commit() {
+	g.prev = nil
+	for _, f := range g.commitHooks {
+		f()
+	}
+	g.commitHooks = nil
+}
+
+// enterQuery registers a running query. It returns false if g is closing.
+func (g *pluginGraph) enterQuery() bool {
+	g.m.RLock()
+	defer g.m.RUnlock()
+	if g.closing.Load() {
+		return false
+	}
+	g.inflightN.Add(1)
+	return true
+}
+
+func (g *pluginGraph) exitQuery() {
+	if g.inflightN.Add(-1) == 0 && g.closing.Load() {
+		select {
+		case g.idle <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// drain rejects new queries and waits until all running queries are done,
+// or the timeout is reached. It returns the number of queries that are
+// still running.
+func (g *pluginGraph) drain(timeout time.Duration) int64 {
+	g.m.Lock()
+	g.closing.Store(true)
+	g.m.Unlock()
+
+	// No query can enter from here.
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		n := g.inflightN.Load()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-g.idle:
+		case <-t.C:
+			return n
+		}
+	}
+}
+
+// closeOrder returns tags of plugins in the order they should be closed.
+// Servers are closed first, so they stop serving before the plugins they
+// use. Then other plugins are closed in reverse load order, so a plugin is
+// closed before the plugins it depends on. Preset plugins are closed last.
+func (g *pluginGraph) closeOrder() []string {
+	tags := make([]string, 0, len(g.plugins))
+	added := make(map[string]bool, len(g.plugins))
+	add := func(tag string) {
+		if _, ok := g.plugins[tag]; ok && !added[tag] {
+			added[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	for i := len(g.order) - 1; i >= 0; i-- {
+		if _, ok := g.servers[g.order[i]]; ok {
+			add(g.order[i])
+		}
+	}
+	for i := len(g.order) - 1; i >= 0; i-- {
+		add(g.order[i])
+	}
+	var rest []string
+	for tag := range g.plugins {
+		if !added[tag] {
+			rest = append(rest, tag)
+		}
+	}
+	sort.Strings(rest)
+	return append(tags, rest...)
+}
+
+// samePlugin reports whether a and b are the same plugin instance.
+// Only pointers can be reused between graphs.
+func samePlugin(a, b any) bool {
+	t := reflect.TypeOf(a)
+	if t == nil || t.Kind() != reflect.Pointer || t != reflect.TypeOf(b) {
+		return false
+	}
+	return a == b
+}
+
+type graphNode struct {
+	Tag         string   `json:"tag"`
+	Type        string   `json:"type"`
+	Preset      bool     `json:"preset,omitempty"`
+	Server      bool     `json:"server,omitempty"`
+	Refs        []string `json:"refs,omitempty"`
+	Unused      bool     `json:"unused,omitempty"`      // No other plugin refers to it.
+	Unreachable bool     `json:"unreachable,omitempty"` // Cannot be reached from any server.
+}
+
+// nodes returns all plugins in g, sorted by tag.
+func (g *pluginGraph) nodes() []graphNode {
+	referred := make(map[string]bool)
+	for _, tos := range g.refs {
+		for to := range tos {
+			referred[to] = true
+		}
+	}
+
+	// Walk from servers.
+	reachable := make(map[string]bool)
+	var walk func(tag string)
+	walk = func(tag string) {
+		if reachable[tag] {
+			return
+		}
+		reachable[tag] = true
+		for to := range g.refs[tag] {
+			walk(to)
+		}
+	}
+	for tag := range g.servers {
+		walk(tag)
+	}
+
+	ns := make([]graphNode, 0, len(g.plugins))
+	for tag := range g.plugins {
+		typ, ok := g.types[tag]
+		n := graphNode{
+			Tag:    tag,
+			Type:   typ,
+			Preset: !ok,
+		}
+		_, n.Server = g.servers[tag]
+		for to := range g.refs[tag] {
+			n.Refs = append(n.Refs, to)
+		}
+		sort.Strings(n.Refs)
+		if !n.Preset && !n.Server {
+			n.Unused = !referred[tag]
+			n.Unreachable = !reachable[tag]
+		}
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i].Tag < ns[j].Tag })
+	return ns
+}
+
+// writeDot writes nodes in Graphviz DOT format.
+// Servers are boxes. Unused or unreachable plugins are dashed.
+func writeDot(w io.Writer, ns []graphNode) error {
+	b := new(bytes.Buffer)
+	b.WriteString("digraph mosdns {\n")
+	for _, n := range ns {
+		var attrs []string
+		attrs = append(attrs, "label="+strconv.Quote(n.Tag+"\n"+n.Type))
+		if n.Server {
+			attrs = append(attrs, "shape=box")
+		}
+		if n.Unused || n.Unreachable {
+			attrs = append(attrs, "style=dashed", "color=gray")
+		}
+		fmt.Fprintf(b, "  %s [%s];\n", strconv.Quote(n.Tag), strings.Join(attrs, ", "))
+	}
+	for _, n := range ns {
+		for _, to := range n.Refs {
+			fmt.Fprintf(b, "  %s -> %s;\n", strconv.Quote(n.Tag), strconv.Quote(to))
+		}
+	}
+	b.WriteString("}\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// handleGraphApi serves the dependency graph of live plugins.
+// Default format is json. Use "?format=dot" for Graphviz DOT format.
+func (m *Mosdns) handleGraphApi(w http.ResponseWriter, req *http.Request) {
+	g := m.graph.Load()
+	if g == nil {
+		http.Error(w, "plugins are not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	ns := g.nodes()
+	switch f := req.URL.Query().Get("format"); f {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ns)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		_ = writeDot(w, ns)
+	default:
+		http.Error(w, fmt.Sprintf("invalid format %s", f), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_pluginGraph_nodes(t *testing.T) {
+	g := newPluginGraph(nil, nil)
+	add := func(tag, typ string, refs ...string) {
+		g.plugins[tag] = struct{}{}
+		g.types[tag] = typ
+		g.loadingTag = tag
+		for _, ref := range refs {
+			g.recordRef(ref)
+		}
+		g.loadingTag = ""
+	}
+	add("set", "domain_set")
+	add("main", "sequence", "set")
+	add("server", "udp_server", "main")
+	g.servers["server"] = struct{}{}
+	add("orphan_seq", "sequence", "orphan_set")
+	add("orphan_set", "domain_set")
+	g.plugins["_preset"] = struct{}{}
+
+	want := []graphNode{
+		{Tag: "_preset", Preset: true},
+		{Tag: "main", Type: "sequence", Refs: []string{"set"}},
+		{Tag: "orphan_seq", Type: "sequence", Refs: []string{"orphan_set"}, Unused: true, Unreachable: true},
+		{Tag: "orphan_set", Type: "domain_set", Unreachable: true},
+		{Tag: "server", Type: "udp_server", Server: true, Refs: []string{"main"}},
+		{Tag: "set", Type: "domain_set"},
+	}
+	if got := g.nodes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("nodes() = %+v, want %+v", got, want)
+	}
+
+	b := new(bytes.Buffer)
+	if err := writeDot(b, want); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"server" -> "main";`) {
+		t.Fatalf("unexpected dot output: %s", b)
+	}
+}
+
+func Test_pluginGraph_closeOrder(t *testing.T) {
+	g := newPluginGraph(map[string]any{"_preset": struct{}{}}, nil)
+	for _, tag := range []string{"set", "main", "server", "other"} {
+		g.plugins[tag] = struct{}{}
+		g.order = append(g.order, tag)
+	}
+	g.servers["server"] = struct{}{}
+	want := []string{"server", "other", "main", "set", "_preset"}
+	if got := g.closeOrder(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("closeOrder() = %v, want %v", got, want)
+	}
+}
+
+func Test_pluginGraph_drain(t *testing.T) {
+	g := newPluginGraph(nil, nil)
+	if !g.enterQuery() {
+		t.Fatal("enterQuery() should succeed")
+	}
+	if n := g.drain(time.Millisecond * 10); n != 1 {
+		t.Fatalf("drain() = %d, want 1 running query", n)
+	}
+	if g.enterQuery() {
+		t.Fatal("enterQuery() should fail after drain")
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		g.exitQuery()
+	}()
+	if n := g.drain(time.Second); n != 0 {
+		t.Fatalf("drain() = %d, want 0", n)
+	}
+}
+
+type closeRecorder struct {
+	tag    string
+	closed *[]string
+}
+
+func (c *closeRecorder) Close() error {
+	*c.closed = append(*c.closed, c.tag)
+	return nil
+}
+
+func Test_Mosdns_closeGraph(t *testing.T) {
+	var closed []string
+	g := newPluginGraph(nil, nil)
+	for _, tag := range []string{"main", "server", "other"} {
+		g.plugins[tag] = &closeRecorder{tag: tag, closed: &closed}
+		g.order = append(g.order, tag)
+	}
+	g.servers["server"] = struct{}{}
+	keep := newPluginGraph(map[string]any{"other": g.plugins["other"]}, nil)
+
+	m := NewTestMosdnsWithPlugins(nil)
+	m.closeGraph(g, keep)
+	if !reflect.DeepEqual(closed, []string{"server", "main"}) {
+		t.Fatalf("closeGraph() closed %v", closed)
+	}
+}
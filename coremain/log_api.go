@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const defaultLogLevelTTL = time.Minute * 10
+
+type logLevelReport struct {
+	Global  mlog.LevelInfo            `json:"global"`
+	Plugins map[string]mlog.LevelInfo `json:"plugins"`
+}
+
+// handleGetLogLevelApi reports the global log level and plugin log levels.
+func (m *Mosdns) handleGetLogLevelApi(w http.ResponseWriter, req *http.Request) {
+	if m.logLevels == nil {
+		http.Error(w, "log levels are not configurable", http.StatusNotImplemented)
+		return
+	}
+	var r logLevelReport
+	r.Global, r.Plugins = m.logLevels.Snapshot()
+	writeJSON(w, http.StatusOK, r)
+}
+
+// handleSetLogLevelApi sets the log level. Query params:
+// "level" is required. "tag" is a plugin tag, if empty, sets the global level.
+// "ttl" is a duration (e.g. "30s", or seconds) after which the level is
+// reverted. Default is 10m.
+func (m *Mosdns) handleSetLogLevelApi(w http.ResponseWriter, req *http.Request) {
+	if m.logLevels == nil {
+		http.Error(w, "log levels are not configurable", http.StatusNotImplemented)
+		return
+	}
+	q := req.URL.Query()
+	tag := q.Get("tag")
+	if !m.checkLogTag(w, tag) {
+		return
+	}
+	lvl, err := zapcore.ParseLevel(q.Get("level"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl := defaultLogLevelTTL
+	if s := q.Get("ttl"); len(s) > 0 {
+		ttl, err = parseTTL(s)
+		if err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl %s", s), http.StatusBadRequest)
+			return
+		}
+	}
+	m.logLevels.SetLevel(tag, lvl, ttl)
+	m.logger.Info("log level changed", zap.String("tag", tag), zap.Stringer("level", lvl), zap.Duration("ttl", ttl))
+	m.handleGetLogLevelApi(w, req)
+}
+
+// handleResetLogLevelApi reverts the level of "tag" (or the global level)
+// to its configured level.
+func (m *Mosdns) handleResetLogLevelApi(w http.ResponseWriter, req *http.Request) {
+	if m.logLevels == nil {
+		http.Error(w, "log levels are not configurable", http.StatusNotImplemented)
+		return
+	}
+	tag := req.URL.Query().Get("tag")
+	if !m.checkLogTag(w, tag) {
+		return
+	}
+	m.logLevels.ResetLevel(tag)
+	m.logger.Info("log level reset", zap.String("tag", tag))
+	m.handleGetLogLevelApi(w, req)
+}
+
+// checkLogTag writes an error and returns false if tag is not a live plugin.
+func (m *Mosdns) checkLogTag(w http.ResponseWriter, tag string) bool {
+	if len(tag) == 0 {
+		return true
+	}
+	if g := m.graph.Load(); g == nil || g.plugins[tag] == nil {
+		http.Error(w, fmt.Sprintf("plugin %s not found", tag), http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func parseTTL(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
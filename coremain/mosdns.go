@@ -25,21 +25,43 @@ import (
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/mlog"
 	"github.com/IrineSistiana/mosdns/v5/pkg/safe_close"
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"go.uber.org/zap"
-	"io"
 	"net/http"
 	"net/http/pprof"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const defaultDrainTimeout = time.Second * 5
+
 type Mosdns struct {
-	logger *zap.Logger // non-nil logger.
+	logger    *zap.Logger  // non-nil logger.
+	logLevels *mlog.Levels // nil if logger was not created from a LogConfig.
+
+	// cfgFile is the file that the config was loaded from. It is used
+	// by ReloadFromFile. Empty if the config was not loaded from a file.
+	cfgFile string
+
+	// dryRun is set by CheckConfig. Errors from plugins are recorded
+	// to checkErrs instead of stopping the loading.
+	dryRun    bool
+	checkErrs []error
 
 	// Plugins
-	plugins map[string]any
+	reloadM sync.Mutex
+	graph   atomic.Pointer[pluginGraph] // live plugins
+	loading atomic.Pointer[pluginGraph] // plugins that are being loaded, nil if no loading in progress
+
+	drainTimeout atomic.Int64 // time.Duration, see ShutdownConfig.DrainTimeout
 
 	httpMux    *chi.Mux
 	metricsReg *prometheus.Registry
@@ -48,34 +70,49 @@ type Mosdns struct {
 
 // NewMosdns initializes a mosdns instance and its plugins.
 func NewMosdns(cfg *Config) (*Mosdns, error) {
+	return newMosdns(cfg, "")
+}
+
+func newMosdns(cfg *Config, cfgFile string) (*Mosdns, error) {
 	// Init logger.
-	lg, err := mlog.NewLogger(cfg.Log)
+	lg, levels, err := mlog.NewLoggerWithLevels(cfg.Log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init logger: %w", err)
 	}
 
 	m := &Mosdns{
 		logger:     lg,
-		plugins:    make(map[string]any),
+		logLevels:  levels,
+		cfgFile:    cfgFile,
 		httpMux:    chi.NewRouter(),
 		metricsReg: newMetricsReg(),
 		sc:         safe_close.NewSafeClose(),
 	}
+	m.setDrainTimeout(cfg.Shutdown)
 	// This must be called after m.httpMux and m.metricsReg been set.
-	m.initHttpMux()
+	m.initHttpMux(cfg.API)
 
 	// Start http api server
 	if httpAddr := cfg.API.HTTP; len(httpAddr) > 0 {
+		auth, tlsConfig, err := newAPIServerOpts(cfg.API)
+		if err != nil {
+			return nil, fmt.Errorf("invalid api config, %w", err)
+		}
 		httpServer := &http.Server{
-			Addr:    httpAddr,
-			Handler: m.httpMux,
+			Addr:      httpAddr,
+			Handler:   auth.wrap(m.httpMux),
+			TLSConfig: tlsConfig,
 		}
 		m.sc.Attach(func(done func(), closeSignal <-chan struct{}) {
 			defer done()
 			errChan := make(chan error, 1)
 			go func() {
-				m.logger.Info("starting api http server", zap.String("addr", httpAddr))
-				errChan <- httpServer.ListenAndServe()
+				m.logger.Info("starting api http server", zap.String("addr", httpAddr), zap.Bool("tls", tlsConfig != nil))
+				if tlsConfig != nil {
+					errChan <- httpServer.ListenAndServeTLS("", "")
+				} else {
+					errChan <- httpServer.ListenAndServe()
+				}
 			}()
 			select {
 			case err := <-errChan:
@@ -95,28 +132,24 @@ func NewMosdns(cfg *Config) (*Mosdns, error) {
 			defer done()
 			<-closeSignal
 			m.logger.Info("starting shutdown sequences")
-			for tag, p := range m.plugins {
-				if closer, _ := p.(io.Closer); closer != nil {
-					m.logger.Info("closing plugin", zap.String("tag", tag))
-					_ = closer.Close()
-				}
+			m.reloadM.Lock()
+			defer m.reloadM.Unlock()
+			if g := m.graph.Load(); g != nil {
+				m.drainGraph(g)
+				m.closeGraph(g, nil)
 			}
 			m.logger.Info("all plugins were closed")
 		}()
 	})
 
-	// Preset plugins
-	if err := m.loadPresetPlugins(); err != nil {
-		m.sc.SendCloseSignal(err)
-		_ = m.sc.WaitClosed()
-		return nil, err
-	}
-	// Plugins from config.
-	if err := m.loadPluginsFromCfg(cfg, 0); err != nil {
+	g, err := m.loadGraph(cfg, nil)
+	if err != nil {
 		m.sc.SendCloseSignal(err)
 		_ = m.sc.WaitClosed()
 		return nil, err
 	}
+	m.graph.Store(g)
+	g.commit()
 	m.logger.Info("all plugins are loaded")
 
 	return m, nil
@@ -124,13 +157,14 @@ func NewMosdns(cfg *Config) (*Mosdns, error) {
 
 // NewTestMosdnsWithPlugins returns a mosdns instance for testing.
 func NewTestMosdnsWithPlugins(p map[string]any) *Mosdns {
-	return &Mosdns{
+	m := &Mosdns{
 		logger:     mlog.Nop(),
 		httpMux:    chi.NewRouter(),
-		plugins:    p,
 		metricsReg: newMetricsReg(),
 		sc:         safe_close.NewSafeClose(),
 	}
+	m.graph.Store(newPluginGraph(p, nil))
+	return m
 }
 
 func (m *Mosdns) GetSafeClose() *safe_close.SafeClose {
@@ -147,14 +181,44 @@ func (m *Mosdns) Logger() *zap.Logger {
 	return m.logger
 }
 
+// Tracer returns the tracer for queries. It is nil if tracing is disabled.
+// A nil tracer is valid and traces nothing.
+// While plugins are being (re)loaded, it returns the tracer of the plugins
+// that are being loaded.
+func (m *Mosdns) Tracer() *trace.Tracer {
+	if g := m.activeGraph(); g != nil {
+		return g.tracer
+	}
+	return nil
+}
+
+// activeGraph returns the graph that is being loaded, or the live graph
+// if there is no loading in progress.
+func (m *Mosdns) activeGraph() *pluginGraph {
+	if g := m.loading.Load(); g != nil {
+		return g
+	}
+	return m.graph.Load()
+}
+
 // GetPlugin returns a plugin.
+// While plugins are being (re)loaded, it searches the plugins
+// that are being loaded.
+// References that are resolved while loading are recorded into the
+// plugin dependency graph. See "/graph" api.
 func (m *Mosdns) GetPlugin(tag string) any {
-	return m.plugins[tag]
+	g := m.activeGraph()
+	p := g.plugins[tag]
+	if p != nil && g == m.loading.Load() {
+		g.recordRef(tag)
+	}
+	return p
 }
 
 // GetMetricsReg returns a prometheus.Registerer with a prefix of "mosdns_"
+// Metrics registered to it will be unregistered when plugins are reloaded.
 func (m *Mosdns) GetMetricsReg() prometheus.Registerer {
-	return prometheus.WrapRegistererWithPrefix("mosdns_", m.metricsReg)
+	return prometheus.WrapRegistererWithPrefix("mosdns_", m.activeGraph().metricsReg)
 }
 
 func (m *Mosdns) GetAPIRouter() *chi.Mux {
@@ -162,7 +226,7 @@ func (m *Mosdns) GetAPIRouter() *chi.Mux {
 }
 
 func (m *Mosdns) RegPluginAPI(tag string, mux *chi.Mux) {
-	m.httpMux.Mount("/plugins/"+tag, mux)
+	m.activeGraph().apiMux.Mount("/"+tag, mux)
 }
 
 func newMetricsReg() *prometheus.Registry {
@@ -173,19 +237,57 @@ func newMetricsReg() *prometheus.Registry {
 }
 
 // initHttpMux initializes api entries. It MUST be called after m.metricsReg being initialized.
-func (m *Mosdns) initHttpMux() {
+func (m *Mosdns) initHttpMux(cfg APIConfig) {
 	// Register metrics.
-	m.httpMux.Method(http.MethodGet, "/metrics", promhttp.HandlerFor(m.metricsReg, promhttp.HandlerOpts{}))
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		gs := prometheus.Gatherers{m.metricsReg}
+		if g := m.graph.Load(); g != nil {
+			gs = append(gs, g.metricsReg)
+		}
+		return gs.Gather()
+	})
+	m.httpMux.Method(http.MethodGet, "/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
 
-	// Register pprof.
-	m.httpMux.Route("/debug/pprof", func(r chi.Router) {
-		r.Get("/*", pprof.Index)
-		r.Get("/cmdline", pprof.Cmdline)
-		r.Get("/profile", pprof.Profile)
-		r.Get("/symbol", pprof.Symbol)
-		r.Get("/trace", pprof.Trace)
+	// Plugin apis are routed to the live plugins.
+	m.httpMux.Mount("/plugins", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.graph.Load().apiMux.ServeHTTP(w, req)
+	}))
+
+	m.httpMux.Get("/graph", m.handleGraphApi)
+	m.httpMux.Get("/schema", handleSchemaApi)
+	m.httpMux.Get("/health", m.handleHealthApi)
+	m.httpMux.Get("/ready", m.handleReadyApi)
+	m.httpMux.Route("/log/level", func(r chi.Router) {
+		r.Get("/", m.handleGetLogLevelApi)
+		r.Post("/", m.handleSetLogLevelApi)
+		r.Delete("/", m.handleResetLogLevelApi)
+	})
+
+	m.httpMux.Post("/reload", func(w http.ResponseWriter, req *http.Request) {
+		if err := m.ReloadFromFile(); err != nil {
+			m.logger.Warn("failed to reload", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
 	})
 
+	if !cfg.DisableUI {
+		m.httpMux.Get("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently).ServeHTTP)
+		m.httpMux.Handle("/ui/*", uiHandler())
+	}
+
+	// Register pprof.
+	if !cfg.DisablePprof {
+		m.httpMux.Route("/debug/pprof", func(r chi.Router) {
+			r.Get("/*", pprof.Index)
+			r.Get("/cmdline", pprof.Cmdline)
+			r.Get("/profile", pprof.Profile)
+			r.Get("/symbol", pprof.Symbol)
+			r.Get("/trace", pprof.Trace)
+		})
+	}
+
 	// A helper page for invalid request.
 	invalidApiReqHelper := func(w http.ResponseWriter, req *http.Request) {
 		b := new(bytes.Buffer)
@@ -198,47 +300,128 @@ func (m *Mosdns) initHttpMux() {
 			b.WriteByte('\n')
 			return nil
 		})
+		if g := m.graph.Load(); g != nil {
+			_ = chi.Walk(g.apiMux, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+				b.WriteString(method)
+				b.WriteString(" /plugins")
+				b.WriteString(route)
+				b.WriteByte('\n')
+				return nil
+			})
+		}
 		_, _ = w.Write(b.Bytes())
 	}
 	m.httpMux.NotFound(invalidApiReqHelper)
 	m.httpMux.MethodNotAllowed(invalidApiReqHelper)
 }
 
-func (m *Mosdns) loadPresetPlugins() error {
+func (m *Mosdns) loadPresetPlugins(g *pluginGraph) error {
 	for tag, f := range LoadNewPersetPluginFuncs() {
-		p, err := f(NewBP(tag, m))
+		g.loadingTag = tag
+		p, err := f(newBP(tag, m, g))
+		g.loadingTag = ""
 		if err != nil {
 			return fmt.Errorf("failed to init preset plugin %s, %w", tag, err)
 		}
-		m.plugins[tag] = p
+		g.plugins[tag] = p
 	}
 	return nil
 }
 
-// loadPluginsFromCfg loads plugins from this config. It follows include first.
-func (m *Mosdns) loadPluginsFromCfg(cfg *Config, includeDepth int) error {
+// loadPluginsFromCfg loads plugins from this config and its includes.
+// Plugins are loaded after the plugins they refer to, so they can be
+// declared in any order. See sortPlugins.
+// file is the file that cfg was loaded from. It is only used in error messages.
+func (m *Mosdns) loadPluginsFromCfg(g *pluginGraph, cfg *Config, file string) error {
+	var ps []declaredPlugin
+	vars := make(map[string]string)
+	if err := m.collectPlugins(cfg, file, 0, &ps, vars); err != nil {
+		return err
+	}
+
+	expanded := ps[:0]
+	for _, p := range ps {
+		args, err := expandVars(p.Args, vars)
+		if err != nil {
+			if err := m.checkErr("", fmt.Errorf("failed to expand args of %s, %w", p, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		p.Args = args
+		expanded = append(expanded, p)
+	}
+
+	ps, err := sortPlugins(expanded)
+	if err != nil {
+		if err := m.checkErr("", err); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range ps {
+		if err := m.newPlugin(g, p.PluginConfig); err != nil {
+			if err := m.checkErr("", fmt.Errorf("failed to init %s, %w", p, err)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectPlugins appends plugins of cfg to ps and adds its variables to vars.
+// Plugins from includes are appended first. Variables from includes override
+// variables from cfg, and later includes override earlier ones.
+func (m *Mosdns) collectPlugins(cfg *Config, file string, includeDepth int, ps *[]declaredPlugin, vars map[string]string) error {
 	const maxIncludeDepth = 8
 	if includeDepth > maxIncludeDepth {
 		return errors.New("maximum include depth reached")
 	}
 	includeDepth++
 
-	// Follow include first.
-	for _, s := range cfg.Include {
-		subCfg, path, err := loadConfig(s)
+	for k, v := range cfg.Vars {
+		vars[strings.ToLower(k)] = v // Config keys are case-insensitive.
+	}
+
+	for _, pattern := range cfg.Include {
+		files, err := includeFiles(pattern)
 		if err != nil {
-			return fmt.Errorf("failed to read config from %s, %w", s, err)
+			if err := m.checkErr(file, err); err != nil {
+				return err
+			}
+			continue
 		}
-		m.logger.Info("load config", zap.String("file", path))
-		if err := m.loadPluginsFromCfg(subCfg, includeDepth); err != nil {
-			return fmt.Errorf("failed to load config from %s, %w", s, err)
+		for _, s := range files {
+			subCfg, path, err := loadConfig(s)
+			if err != nil {
+				if err := m.checkErr(file, fmt.Errorf("failed to read config from %s, %w", s, err)); err != nil {
+					return err
+				}
+				continue
+			}
+			m.logger.Info("load config", zap.String("file", path))
+			if err := m.collectPlugins(subCfg, path, includeDepth, ps, vars); err != nil {
+				return fmt.Errorf("failed to load config from %s, %w", s, err)
+			}
 		}
 	}
 
 	for i, pc := range cfg.Plugins {
-		if err := m.newPlugin(pc); err != nil {
-			return fmt.Errorf("failed to init plugin #%d %s, %w", i, pc.Tag, err)
-		}
+		*ps = append(*ps, declaredPlugin{PluginConfig: pc, file: file, idx: i})
 	}
 	return nil
 }
+
+// includeFiles returns the files of an include entry. If s is a glob pattern,
+// it returns all matched files in lexical order. A pattern that matches
+// nothing is not an error.
+func includeFiles(s string) ([]string, error) {
+	if !strings.ContainsAny(s, "*?[") {
+		return []string{s}, nil
+	}
+	files, err := filepath.Glob(s) // Glob returns files in lexical order.
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern %s, %w", s, err)
+	}
+	return files, nil
+}
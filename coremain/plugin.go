@@ -21,6 +21,7 @@ package coremain
 
 import (
 	"fmt"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
@@ -86,13 +87,13 @@ func GetPluginType(typ string) (PluginTypeInfo, bool) {
 	return info, ok
 }
 
-// newPlugin initializes a Plugin from c and adds it to mosdns.
-func (m *Mosdns) newPlugin(c PluginConfig) error {
+// newPlugin initializes a Plugin from c and adds it to g.
+func (m *Mosdns) newPlugin(g *pluginGraph, c PluginConfig) error {
 	if len(c.Tag) == 0 {
-		c.Tag = fmt.Sprintf("anonymouse_%s_%d", c.Type, len(m.plugins))
+		c.Tag = fmt.Sprintf("anonymouse_%s_%d", c.Type, len(g.plugins))
 	}
 
-	if _, dup := m.plugins[c.Tag]; dup {
+	if _, dup := g.plugins[c.Tag]; dup {
 		return fmt.Errorf("duplicated plugin tag %s", c.Tag)
 	}
 
@@ -100,25 +101,69 @@ func (m *Mosdns) newPlugin(c PluginConfig) error {
 	if !ok {
 		return fmt.Errorf("plugin type %s not defined", c.Type)
 	}
-
-	args := typeInfo.NewArgs()
-	if reflect.TypeOf(c.Args) == reflect.TypeOf(args) { // Same type, no need to parse.
-		args = c.Args
-	} else {
-		if err := utils.WeakDecode(c.Args, args); err != nil {
-			return fmt.Errorf("unable to decode plugin args: %w", err)
-		}
+	args, err := decodePluginArgs(typeInfo, c.Args)
+	if err != nil {
+		return err
 	}
 
 	m.logger.Info("loading plugin", zap.String("tag", c.Tag), zap.String("type", c.Type))
-	p, err := typeInfo.NewPlugin(NewBP(c.Tag, m), args)
+	g.loadingTag = c.Tag
+	p, err := typeInfo.NewPlugin(newBP(c.Tag, m, g), args)
+	g.loadingTag = ""
 	if err != nil {
 		return fmt.Errorf("failed to init plugin: %w", err)
 	}
-	m.plugins[c.Tag] = p
+	g.plugins[c.Tag] = p
+	g.types[c.Tag] = c.Type
+	g.order = append(g.order, c.Tag)
 	return nil
 }
 
+// ArgsDecoder can be implemented by plugin args that accept more than one
+// form, e.g. a list or a map. DecodeArgs is used instead of utils.WeakDecode.
+// ArgsForms returns a value of each accepted form. It is used by the schema.
+type ArgsDecoder interface {
+	DecodeArgs(in any) error
+	ArgsForms() []any
+}
+
+func decodePluginArgs(typeInfo PluginTypeInfo, in any) (any, error) {
+	args := typeInfo.NewArgs()
+	if reflect.TypeOf(in) == reflect.TypeOf(args) { // Same type, no need to parse.
+		return in, nil
+	}
+	var err error
+	if d, ok := args.(ArgsDecoder); ok {
+		err = d.DecodeArgs(in)
+	} else {
+		err = utils.WeakDecode(in, args)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode plugin args: %w", err)
+	}
+	return args, nil
+}
+
+// NewAnonymousPlugin creates a plugin that is not declared in the plugin
+// list, e.g. a plugin that is defined inline in another plugin's args.
+// args is decoded in the same way as PluginConfig.Args. The plugin is not
+// added to mosdns. Caller is responsible for closing it.
+func (m *Mosdns) NewAnonymousPlugin(tag, typ string, args any) (any, error) {
+	typeInfo, ok := GetPluginType(typ)
+	if !ok {
+		return nil, fmt.Errorf("plugin type %s not defined", typ)
+	}
+	a, err := decodePluginArgs(typeInfo, args)
+	if err != nil {
+		return nil, err
+	}
+	p, err := typeInfo.NewPlugin(newBP(tag, m, m.activeGraph()), a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init plugin: %w", err)
+	}
+	return p, nil
+}
+
 // GetAllPluginTypes returns all plugin types which are configurable.
 func GetAllPluginTypes() []string {
 	pluginTypeRegister.RLock()
@@ -165,15 +210,21 @@ func LoadNewPersetPluginFuncs() map[string]NewPersetPluginFunc {
 type BP struct {
 	tag string
 	m   *Mosdns
+	g   *pluginGraph
 	l   *zap.Logger
 }
 
 // NewBP creates a new BP. m MUST NOT nil.
 func NewBP(tag string, m *Mosdns) *BP {
+	return newBP(tag, m, m.activeGraph())
+}
+
+func newBP(tag string, m *Mosdns, g *pluginGraph) *BP {
 	return &BP{
 		tag: tag,
-		l:   m.Logger().Named(tag),
+		l:   mlog.WithTag(m.Logger(), tag),
 		m:   m,
+		g:   g,
 	}
 }
 
@@ -197,5 +248,52 @@ func (p *BP) Tag() string {
 // RegAPI mounts mux to mosdns api. Note: Plugins MUST NOT call RegAPI twice.
 // Since mounting same path to root chi.Mux causes runtime panic.
 func (p *BP) RegAPI(mux *chi.Mux) {
-	p.m.RegPluginAPI(p.tag, mux)
+	p.g.apiMux.Mount("/"+p.tag, mux)
+}
+
+// DryRun reports whether plugins are loaded for config checking only.
+// In this mode, plugins should validate their args but should not start
+// services or change external states. e.g. bind sockets, write files.
+func (p *BP) DryRun() bool {
+	return p.m.dryRun
+}
+
+// MarkServer marks this plugin as a server, which receives queries from
+// outside. Servers are the roots of the plugin dependency graph.
+func (p *BP) MarkServer() {
+	p.g.servers[p.tag] = struct{}{}
+}
+
+// PrevPlugin returns the plugin that had the same tag before the
+// reload that is loading this plugin. It returns nil if this is not
+// a reload or there was no such plugin.
+// Plugins can return the previous instance from their NewPluginFunc to
+// keep it (and its resources, e.g. listeners) across the reload. In this
+// case, the previous instance will not be closed.
+func (p *BP) PrevPlugin() any {
+	if p.g.prev == nil {
+		return nil
+	}
+	return p.g.prev.plugins[p.tag]
+}
+
+// OnCommit registers f that will be called once all plugins are loaded
+// and become live. If loading failed, f will not be called.
+// It is useful for plugins that were reused by PrevPlugin to switch to the
+// newly loaded plugins.
+func (p *BP) OnCommit(f func()) {
+	p.g.commitHooks = append(p.g.commitHooks, f)
+}
+
+// EnterQuery registers a query that will run on plugins that were loaded
+// with this BP. It returns false if those plugins are being closed.
+// Otherwise, caller MUST call ExitQuery once the query is done. Plugins
+// will not be closed until all registered queries are exited.
+func (p *BP) EnterQuery() bool {
+	return p.g.enterQuery()
+}
+
+// ExitQuery marks a query that was registered by EnterQuery as done.
+func (p *BP) ExitQuery() {
+	p.g.exitQuery()
 }
@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
+	"go.uber.org/zap"
+)
+
+// loadGraph loads plugins from cfg into a new graph. prev is the live
+// graph that the new graph will replace, it can be nil.
+// If any plugin failed to load, plugins that were loaded will be closed,
+// except those reused from prev.
+func (m *Mosdns) loadGraph(cfg *Config, prev *pluginGraph) (*pluginGraph, error) {
+	g := newPluginGraph(nil, prev)
+	if err := m.loadTracer(g, cfg.Tracing, prev); err != nil {
+		return nil, err
+	}
+	m.loading.Store(g)
+	defer m.loading.Store(nil)
+
+	// Preset plugins
+	if err := m.loadPresetPlugins(g); err != nil {
+		m.closeGraph(g, prev)
+		return nil, err
+	}
+	// Plugins from config.
+	if err := m.loadPluginsFromCfg(g, cfg, m.cfgFile); err != nil {
+		m.closeGraph(g, prev)
+		return nil, err
+	}
+	return g, nil
+}
+
+// loadTracer sets the tracer of g from cfg. The tracer of prev is reused
+// if cfg is unchanged. prev can be nil. No tracer is built in dry-run mode.
+func (m *Mosdns) loadTracer(g *pluginGraph, cfg trace.Config, prev *pluginGraph) error {
+	g.tracing = cfg
+	if m.dryRun {
+		return nil
+	}
+	if prev != nil && reflect.DeepEqual(prev.tracing, cfg) {
+		g.tracer = prev.tracer
+		return nil
+	}
+	tracer, err := trace.NewTracer(cfg, m.logger.Named("trace"))
+	if err != nil {
+		return fmt.Errorf("failed to init tracer, %w", err)
+	}
+	g.tracer = tracer
+	return nil
+}
+
+// closeGraph closes all plugins in g, except those that are also in keep.
+// keep can be nil. See pluginGraph.closeOrder for the order.
+// The tracer of g is closed last, unless keep uses it.
+func (m *Mosdns) closeGraph(g *pluginGraph, keep *pluginGraph) {
+	for _, tag := range g.closeOrder() {
+		p := g.plugins[tag]
+		if keep != nil && samePlugin(p, keep.plugins[tag]) {
+			continue
+		}
+		if closer, _ := p.(io.Closer); closer != nil {
+			m.logger.Info("closing plugin", zap.String("tag", tag))
+			_ = closer.Close()
+		}
+	}
+	if keep == nil || keep.tracer != g.tracer {
+		if err := g.tracer.Close(); err != nil {
+			m.logger.Warn("failed to close tracer", zap.Error(err))
+		}
+	}
+}
+
+// Reload loads plugins from cfg and replaces the running plugins with them.
+// If any plugin fails to load, the running plugins are kept and the
+// error is returned.
+// Server plugins keep their listeners if their listen args are unchanged.
+// Running queries finish on the old plugins before the old plugins are
+// closed, up to ShutdownConfig.DrainTimeout. Old servers keep their
+// sockets open while draining, so responses of running queries can still
+// be sent, but they refuse new queries.
+// The tracer is rebuilt if tracing settings in cfg changed. The old tracer
+// is closed with the old plugins.
+// Note: log and api settings in cfg are ignored.
+func (m *Mosdns) Reload(cfg *Config) error {
+	m.reloadM.Lock()
+	defer m.reloadM.Unlock()
+
+	select {
+	case <-m.sc.ReceiveCloseSignal():
+		return errors.New("mosdns is closed")
+	default:
+	}
+
+	m.logger.Info("reloading plugins")
+	m.setDrainTimeout(cfg.Shutdown)
+	old := m.graph.Load()
+	g, err := m.loadGraph(cfg, old)
+	if err != nil {
+		return err
+	}
+	m.graph.Store(g)
+	g.commit()
+	m.logger.Info("new plugins are loaded, closing old plugins")
+
+	m.drainGraph(old)
+	m.closeGraph(old, g)
+	m.logger.Info("plugins reloaded")
+	return nil
+}
+
+// drainGraph stops g from accepting new queries and waits for its running
+// queries. See ShutdownConfig.DrainTimeout.
+func (m *Mosdns) drainGraph(g *pluginGraph) {
+	timeout := time.Duration(m.drainTimeout.Load())
+	m.logger.Info("waiting for running queries", zap.Duration("timeout", timeout))
+	if n := g.drain(timeout); n > 0 {
+		m.logger.Warn("drain timeout reached, some queries are still running", zap.Int64("queries", n))
+	}
+}
+
+func (m *Mosdns) setDrainTimeout(cfg ShutdownConfig) {
+	timeout := time.Duration(cfg.DrainTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	m.drainTimeout.Store(int64(timeout))
+}
+
+// ReloadFromFile reloads the config file that mosdns was started with.
+// See Reload.
+func (m *Mosdns) ReloadFromFile() error {
+	if len(m.cfgFile) == 0 {
+		return errors.New("mosdns was not started from a config file")
+	}
+	cfg, _, err := loadConfig(m.cfgFile)
+	if err != nil {
+		return fmt.Errorf("fail to load config, %w", err)
+	}
+	return m.Reload(cfg)
+}
@@ -70,6 +70,16 @@ func init() {
 				m.logger.Warn("signal received", zap.Stringer("signal", sig))
 				m.sc.SendCloseSignal(nil)
 			}()
+			go func() {
+				c := make(chan os.Signal, 1)
+				signal.Notify(c, syscall.SIGHUP)
+				for sig := range c {
+					m.logger.Info("signal received, reloading config", zap.Stringer("signal", sig))
+					if err := m.ReloadFromFile(); err != nil {
+						m.logger.Error("failed to reload config, old config is kept", zap.Error(err))
+					}
+				}
+			}()
 			return m.GetSafeClose().WaitClosed()
 		},
 		DisableFlagsInUseLine: true,
@@ -126,7 +136,7 @@ func NewServer(sf *serverFlags) (*Mosdns, error) {
 	}
 	mlog.L().Info("main config loaded", zap.String("file", fileUsed))
 
-	return NewMosdns(cfg)
+	return newMosdns(cfg, fileUsed)
 }
 
 // loadConfig load a config from a file. If filePath is empty, it will
@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+var schemaExtReg struct {
+	sync.RWMutex
+	m map[string]func() any
+}
+
+// RegSchemaExtension adds a section to the output of GetSchema. f is called
+// every time the schema is generated. Its output must be json encodable.
+// E.g. the sequence plugin uses it to list its quick setup types.
+func RegSchemaExtension(name string, f func() any) {
+	schemaExtReg.Lock()
+	defer schemaExtReg.Unlock()
+	if schemaExtReg.m == nil {
+		schemaExtReg.m = make(map[string]func() any)
+	}
+	if _, dup := schemaExtReg.m[name]; dup || name == "plugins" {
+		panic(fmt.Sprintf("duplicate schema extension [%s]", name))
+	}
+	schemaExtReg.m[name] = f
+}
+
+// GetSchema returns the JSON Schema of args of all registered plugin
+// types under key "plugins", and all sections from RegSchemaExtension.
+// If types is not empty, only those plugin types are included.
+func GetSchema(types ...string) (map[string]any, error) {
+	if len(types) == 0 {
+		types = GetAllPluginTypes()
+	}
+	plugins := make(map[string]any, len(types))
+	for _, typ := range types {
+		s, err := PluginArgsSchema(typ)
+		if err != nil {
+			return nil, err
+		}
+		plugins[typ] = s
+	}
+
+	out := map[string]any{"plugins": plugins}
+	schemaExtReg.RLock()
+	defer schemaExtReg.RUnlock()
+	for name, f := range schemaExtReg.m {
+		out[name] = f()
+	}
+	return out, nil
+}
+
+// PluginArgsSchema returns the JSON Schema of args of plugin type typ.
+func PluginArgsSchema(typ string) (map[string]any, error) {
+	info, ok := GetPluginType(typ)
+	if !ok {
+		return nil, fmt.Errorf("plugin type %s not defined", typ)
+	}
+	g := &schemaGen{defs: make(map[string]any), names: make(map[reflect.Type]string)}
+	var s map[string]any
+	if args := info.NewArgs(); args != nil {
+		if d, ok := args.(ArgsDecoder); ok {
+			var forms []any
+			for _, f := range d.ArgsForms() {
+				forms = append(forms, g.typeSchema(reflect.TypeOf(f), false))
+			}
+			s = map[string]any{"anyOf": forms}
+		} else {
+			t := reflect.TypeOf(args)
+			for t.Kind() == reflect.Pointer {
+				t = t.Elem()
+			}
+			s = g.typeSchema(t, true)
+		}
+	} else {
+		s = map[string]any{}
+	}
+	s["$schema"] = jsonSchemaDraft
+	s["title"] = typ
+	if len(g.defs) > 0 {
+		s["$defs"] = g.defs
+	}
+	return s, nil
+}
+
+type schemaGen struct {
+	defs  map[string]any
+	names map[reflect.Type]string // struct type -> key in defs
+}
+
+// typeSchema returns the schema of t. Named struct types are stored
+// in g.defs and referred by "$ref", unless inline is true.
+func (g *schemaGen) typeSchema(t reflect.Type, inline bool) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typeSchema(t.Elem(), inline)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elem(), false)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.typeSchema(t.Elem(), false)}
+	case reflect.Struct:
+		if inline || len(t.Name()) == 0 {
+			return g.structSchema(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = path.Base(t.PkgPath()) + "." + t.Name()
+			for i := 2; g.defs[name] != nil; i++ {
+				name = fmt.Sprintf("%s.%s_%d", path.Base(t.PkgPath()), t.Name(), i)
+			}
+			g.names[t] = name
+			g.defs[name] = map[string]any{} // Placeholder for recursive types.
+			g.defs[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	default: // Interfaces etc.
+		return map[string]any{}
+	}
+}
+
+// structSchema returns the object schema of struct t. Field names
+// follow the "yaml" tags, same as utils.WeakDecode.
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		props[name] = g.typeSchema(f.Type, false)
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+// handleSchemaApi serves GetSchema. Use "?type=" to select plugin types.
+func handleSchemaApi(w http.ResponseWriter, req *http.Request) {
+	types := req.URL.Query()["type"]
+	s, err := GetSchema(types...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type schemaTestArgs struct {
+	Name    string            `yaml:"name"`
+	Port    uint16            `yaml:"port"`
+	Subs    []schemaTestSub   `yaml:"subs"`
+	Labels  map[string]string `yaml:"labels"`
+	Skipped string            `yaml:"-"`
+	hidden  int
+}
+
+type schemaTestSub struct {
+	Addr string `yaml:"addr"`
+	Next *schemaTestSub
+}
+
+func Test_PluginArgsSchema(t *testing.T) {
+	RegNewPluginFunc("_schema_test", nil, func() any { return new(schemaTestArgs) })
+	t.Cleanup(func() { DelPluginType("_schema_test") })
+	s, err := PluginArgsSchema("_schema_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"$defs":{"coremain.schemaTestSub":{"additionalProperties":false,"properties":{"Next":{"$ref":"#/$defs/coremain.schemaTestSub"},"addr":{"type":"string"}},"type":"object"}},` +
+		`"$schema":"https://json-schema.org/draft/2020-12/schema","additionalProperties":false,` +
+		`"properties":{"labels":{"additionalProperties":{"type":"string"},"type":"object"},"name":{"type":"string"},"port":{"minimum":0,"type":"integer"},` +
+		`"subs":{"items":{"$ref":"#/$defs/coremain.schemaTestSub"},"type":"array"}},"title":"_schema_test","type":"object"}`
+	if string(b) != want {
+		t.Fatalf("PluginArgsSchema() = %s, want %s", b, want)
+	}
+
+	if _, err := PluginArgsSchema("_no_such_type"); err == nil {
+		t.Fatal("want error for unknown type")
+	}
+}
+
+type schemaTestFormsArgs struct {
+	Items []string `yaml:"items"`
+}
+
+func (a *schemaTestFormsArgs) DecodeArgs(in any) error { return nil }
+func (a *schemaTestFormsArgs) ArgsForms() []any        { return []any{[]string(nil), schemaTestFormsArgs{}} }
+
+func Test_PluginArgsSchema_forms(t *testing.T) {
+	RegNewPluginFunc("_schema_forms_test", nil, func() any { return new(schemaTestFormsArgs) })
+	t.Cleanup(func() { DelPluginType("_schema_forms_test") })
+	s, err := PluginArgsSchema("_schema_forms_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"$defs":{"coremain.schemaTestFormsArgs":{"additionalProperties":false,"properties":{"items":{"items":{"type":"string"},"type":"array"}},"type":"object"}},` +
+		`"$schema":"https://json-schema.org/draft/2020-12/schema",` +
+		`"anyOf":[{"items":{"type":"string"},"type":"array"},{"$ref":"#/$defs/coremain.schemaTestFormsArgs"}],"title":"_schema_forms_test"}`
+	if string(b) != want {
+		t.Fatalf("PluginArgsSchema() = %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// Status is the health status of a plugin.
+type Status string
+
+const (
+	StatusHealthy  Status = "healthy"
+	StatusDegraded Status = "degraded" // Still working, but something is wrong.
+	StatusFailed   Status = "failed"
+)
+
+// worse returns the worse status of s and o.
+func (s Status) worse(o Status) Status {
+	rank := func(s Status) int {
+		switch s {
+		case StatusHealthy:
+			return 0
+		case StatusDegraded:
+			return 1
+		default:
+			return 2
+		}
+	}
+	if rank(o) > rank(s) {
+		return o
+	}
+	return s
+}
+
+// PluginStatus is the status reported by a StatusReporter.
+type PluginStatus struct {
+	Status Status `json:"status"`
+	// Details is optional. It must be json encodable.
+	Details any `json:"details,omitempty"`
+}
+
+// StatusReporter can be implemented by plugins to report their status
+// at "/health" and "/ready" apis. Status must be safe for concurrent use
+// and should not block.
+type StatusReporter interface {
+	Status() PluginStatus
+}
+
+type healthReport struct {
+	Status  Status                  `json:"status"`
+	Ready   bool                    `json:"ready"`
+	Reason  string                  `json:"reason,omitempty"` // Why it is not ready.
+	Plugins map[string]PluginStatus `json:"plugins,omitempty"`
+}
+
+// health collects status from all live plugins.
+func (m *Mosdns) health() healthReport {
+	g := m.graph.Load()
+	if g == nil {
+		return healthReport{Status: StatusFailed, Reason: "plugins are not loaded"}
+	}
+	r := healthReport{Status: StatusHealthy, Plugins: make(map[string]PluginStatus)}
+	tags := make([]string, 0, len(g.plugins))
+	for tag := range g.plugins {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		sr, ok := g.plugins[tag].(StatusReporter)
+		if !ok {
+			continue
+		}
+		s := sr.Status()
+		r.Plugins[tag] = s
+		r.Status = r.Status.worse(s.Status)
+	}
+
+	closing := g.closing.Load()
+	select {
+	case <-m.sc.ReceiveCloseSignal():
+		closing = true
+	default:
+	}
+	switch {
+	case closing:
+		r.Reason = "shutting down"
+	case r.Status == StatusFailed:
+		r.Reason = "some plugins failed"
+	default:
+		r.Ready = true
+	}
+	return r
+}
+
+// handleHealthApi reports the status of all plugins. It returns
+// 503 if any plugin failed. Degraded plugins do not fail the check.
+func (m *Mosdns) handleHealthApi(w http.ResponseWriter, req *http.Request) {
+	r := m.health()
+	code := http.StatusOK
+	if r.Status == StatusFailed {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, r)
+}
+
+// handleReadyApi returns 200 once all plugins were loaded and none of
+// them failed, and 503 otherwise (e.g. during startup or shutdown).
+func (m *Mosdns) handleReadyApi(w http.ResponseWriter, req *http.Request) {
+	r := m.health()
+	code := http.StatusOK
+	if !r.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, r)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type statusPlugin struct {
+	s Status
+}
+
+func (p *statusPlugin) Status() PluginStatus {
+	return PluginStatus{Status: p.s}
+}
+
+func Test_healthApi(t *testing.T) {
+	p := &statusPlugin{s: StatusHealthy}
+	m := NewTestMosdnsWithPlugins(map[string]any{"p": p, "other": struct{}{}})
+
+	get := func(h http.HandlerFunc) (int, healthReport) {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		var r healthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, r
+	}
+
+	code, r := get(m.handleHealthApi)
+	if code != http.StatusOK || r.Status != StatusHealthy || len(r.Plugins) != 1 {
+		t.Fatalf("unexpected health report %d %+v", code, r)
+	}
+
+	p.s = StatusDegraded
+	if code, _ := get(m.handleReadyApi); code != http.StatusOK {
+		t.Fatalf("degraded plugins should not fail readiness, got %d", code)
+	}
+
+	p.s = StatusFailed
+	if code, r := get(m.handleHealthApi); code != http.StatusServiceUnavailable || r.Status != StatusFailed {
+		t.Fatalf("unexpected health report %d %+v", code, r)
+	}
+	if code, r := get(m.handleReadyApi); code != http.StatusServiceUnavailable || r.Ready {
+		t.Fatalf("unexpected ready report %d %+v", code, r)
+	}
+
+	m.graph.Store(nil)
+	if code, r := get(m.handleReadyApi); code != http.StatusServiceUnavailable || r.Ready {
+		t.Fatalf("unexpected ready report before plugins are loaded %d %+v", code, r)
+	}
+}
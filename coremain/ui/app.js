@@ -0,0 +1,218 @@
+// mosdns dashboard. It polls the api server and renders the results.
+// It uses no external assets.
+"use strict";
+
+const pollInterval = 2000;
+const chartPoints = 60;
+
+const $ = (id) => document.getElementById(id);
+const tokenInput = $("token");
+tokenInput.value = localStorage.getItem("mosdns_token") || "";
+tokenInput.addEventListener("change", () => {
+  localStorage.setItem("mosdns_token", tokenInput.value);
+  poll();
+});
+
+// api calls an api of the server. Paths are relative to the api root.
+async function api(path, opts = {}) {
+  const headers = {};
+  if (tokenInput.value) {
+    headers["Authorization"] = "Bearer " + tokenInput.value;
+  }
+  const resp = await fetch("../" + path, { ...opts, headers });
+  // /health returns 503 with a report if plugins failed.
+  if (!resp.ok && !(path === "health" && resp.status === 503)) {
+    throw new Error(`${path}: ${resp.status} ${(await resp.text()).trim()}`);
+  }
+  return resp;
+}
+
+// parseMetrics parses the Prometheus text format into samples.
+function parseMetrics(text) {
+  const samples = [];
+  for (const line of text.split("\n")) {
+    if (!line || line.startsWith("#")) {
+      continue;
+    }
+    const m = line.match(/^([a-zA-Z_:][\w:]*)(\{(.*)\})?\s+(\S+)/);
+    if (!m) {
+      continue;
+    }
+    const labels = {};
+    for (const l of (m[3] || "").matchAll(/(\w+)="((?:[^"\\]|\\.)*)"/g)) {
+      labels[l[1]] = l[2];
+    }
+    samples.push({ name: m[1], labels, value: parseFloat(m[4]) });
+  }
+  return samples;
+}
+
+function sum(samples, name) {
+  return samples.filter((s) => s.name === name).reduce((a, s) => a + s.value, 0);
+}
+
+function byLabel(samples, name, label) {
+  const m = {};
+  for (const s of samples) {
+    if (s.name === name) {
+      m[s.labels[label]] = (m[s.labels[label]] || 0) + s.value;
+    }
+  }
+  return m;
+}
+
+function el(tag, text, cls) {
+  const e = document.createElement(tag);
+  if (text !== undefined) {
+    e.textContent = text;
+  }
+  if (cls) {
+    e.className = cls;
+  }
+  return e;
+}
+
+function row(...cells) {
+  const tr = el("tr");
+  for (const c of cells) {
+    tr.append(c instanceof Node ? c : el("td", String(c)));
+  }
+  return tr;
+}
+
+function fillTable(id, rows, cols) {
+  const tbody = $(id);
+  tbody.replaceChildren(...rows);
+  if (rows.length === 0) {
+    const td = el("td", "none", "empty");
+    td.colSpan = cols;
+    tbody.append(el("tr"));
+    tbody.lastChild.append(td);
+  }
+}
+
+const pct = (a, b) => (b > 0 ? ((a / b) * 100).toFixed(1) + "%" : "-");
+
+let prev = null; // {time, queries, latencySum, latencyCount}
+const qpsHistory = [];
+
+function renderQueries(samples) {
+  const now = Date.now();
+  const cur = {
+    time: now,
+    queries: sum(samples, "mosdns_metrics_collector_query_total"),
+    latencySum: sum(samples, "mosdns_metrics_collector_response_latency_millisecond_sum"),
+    latencyCount: sum(samples, "mosdns_metrics_collector_response_latency_millisecond_count"),
+  };
+  const hasCollector = samples.some((s) => s.name === "mosdns_metrics_collector_query_total");
+  $("no-collector").hidden = hasCollector;
+  $("total").textContent = hasCollector ? cur.queries.toLocaleString() : "-";
+
+  // Counters are reset if plugins were reloaded.
+  if (prev && cur.queries >= prev.queries) {
+    const qps = (cur.queries - prev.queries) / ((now - prev.time) / 1000);
+    $("qps").textContent = qps.toFixed(1);
+    const n = cur.latencyCount - prev.latencyCount;
+    $("latency").textContent = n > 0 ? ((cur.latencySum - prev.latencySum) / n).toFixed(1) + " ms" : "-";
+    qpsHistory.push(qps);
+    if (qpsHistory.length > chartPoints) {
+      qpsHistory.shift();
+    }
+    renderChart();
+  }
+  prev = cur;
+}
+
+function renderChart() {
+  const max = Math.max(1, ...qpsHistory);
+  const points = qpsHistory
+    .map((v, i) => `${(i / (chartPoints - 1)) * 600},${120 - (v / max) * 110}`)
+    .join(" ");
+  const line = document.createElementNS("http://www.w3.org/2000/svg", "polyline");
+  line.setAttribute("points", points);
+  const title = document.createElementNS("http://www.w3.org/2000/svg", "title");
+  title.textContent = `max ${max.toFixed(1)} qps`;
+  $("chart").replaceChildren(title, line);
+}
+
+function renderCaches(samples) {
+  const queries = byLabel(samples, "mosdns_cache_query_total", "tag");
+  const hits = byLabel(samples, "mosdns_cache_hit_total", "tag");
+  const lazyHits = byLabel(samples, "mosdns_cache_lazy_hit_total", "tag");
+  const sizes = byLabel(samples, "mosdns_cache_size_current", "tag");
+  const rows = Object.keys(queries).sort().map((tag) => {
+    const flush = el("button", "Flush");
+    flush.disabled = !tag;
+    flush.addEventListener("click", () =>
+      action(`plugins/${encodeURIComponent(tag)}/flush`, "POST", `Flush cache ${tag}?`));
+    const td = el("td");
+    td.append(flush);
+    return row(tag || "(quick setup)", sizes[tag] || 0, queries[tag], pct(hits[tag], queries[tag]),
+      pct(lazyHits[tag], queries[tag]), td);
+  });
+  fillTable("caches", rows, 6);
+}
+
+function renderHealth(health, graph) {
+  const badge = $("status");
+  badge.textContent = health.ready ? health.status : `${health.status}, not ready`;
+  badge.title = health.reason || "";
+  badge.className = "badge " + health.status;
+
+  const plugins = health.plugins || {};
+  const upstreams = [];
+  for (const [tag, st] of Object.entries(plugins).sort()) {
+    for (const u of (st.details && st.details.upstreams) || []) {
+      upstreams.push(row(tag, u.name, u.queries, u.errors,
+        el("td", pct(u.errors, u.queries), u.error_rate >= 0.5 ? "text-failed" : "")));
+    }
+  }
+  fillTable("upstreams", upstreams, 5);
+
+  const rows = (graph || []).map((n) => {
+    const st = plugins[n.tag];
+    return row(n.tag, n.preset ? "(preset)" : n.type,
+      el("td", st ? st.status : "", st ? "text-" + st.status : ""));
+  });
+  fillTable("plugins", rows, 3);
+}
+
+async function action(path, method, confirmMsg) {
+  if (confirmMsg && !confirm(confirmMsg)) {
+    return;
+  }
+  try {
+    await api(path, { method });
+    showError("");
+    poll();
+  } catch (e) {
+    showError(e.message);
+  }
+}
+
+$("reload").addEventListener("click", () => action("reload", "POST", "Reload config from file?"));
+
+function showError(msg) {
+  $("error").textContent = msg;
+  $("error").hidden = !msg;
+}
+
+async function poll() {
+  try {
+    const [metrics, health, graph] = await Promise.all([
+      api("metrics").then((r) => r.text()),
+      api("health").then((r) => r.json()),
+      api("graph").then((r) => r.json()).catch(() => []),
+    ]);
+    const samples = parseMetrics(metrics);
+    renderQueries(samples);
+    renderCaches(samples);
+    renderHealth(health, graph);
+    showError("");
+  } catch (e) {
+    showError(e.message);
+  }
+}
+
+poll();
+setInterval(poll, pollInterval);
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_uiHandler(t *testing.T) {
+	m := NewTestMosdnsWithPlugins(nil)
+	m.initHttpMux(APIConfig{})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		m.httpMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if w := get("/ui"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/ui/" {
+		t.Fatalf("want a redirect, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w := get("/ui/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "app.js") {
+		t.Fatalf("unexpected index %d", w.Code)
+	}
+	for _, f := range []string{"/ui/app.js", "/ui/style.css"} {
+		if w := get(f); w.Code != http.StatusOK {
+			t.Fatalf("%s: %d", f, w.Code)
+		}
+	}
+
+	m = NewTestMosdnsWithPlugins(nil)
+	m.initHttpMux(APIConfig{DisableUI: true})
+	if w := get("/ui/"); strings.Contains(w.Body.String(), "app.js") {
+		t.Fatal("ui should be disabled")
+	}
+}
+
+// Test_uiMetricNames checks that metrics used by the dashboard exist in
+// the output of "/metrics".
+func Test_uiMetricNames(t *testing.T) {
+	m := NewTestMosdnsWithPlugins(nil)
+	m.initHttpMux(APIConfig{})
+
+	// Register metrics the way metrics_collector and cache plugins do.
+	reg := func(typ string) prometheus.Registerer {
+		return prometheus.WrapRegistererWith(prometheus.Labels{"tag": "t"}, prometheus.WrapRegistererWithPrefix(typ+"_", m.GetMetricsReg()))
+	}
+	for typ, cs := range map[string][]prometheus.Collector{
+		"metrics_collector": {
+			prometheus.NewCounter(prometheus.CounterOpts{Name: "query_total"}),
+			prometheus.NewHistogram(prometheus.HistogramOpts{Name: "response_latency_millisecond"}),
+		},
+		"cache": {
+			prometheus.NewCounter(prometheus.CounterOpts{Name: "query_total"}),
+			prometheus.NewCounter(prometheus.CounterOpts{Name: "hit_total"}),
+			prometheus.NewCounter(prometheus.CounterOpts{Name: "lazy_hit_total"}),
+			prometheus.NewGauge(prometheus.GaugeOpts{Name: "size_current"}),
+		},
+	} {
+		for _, c := range cs {
+			if err := reg(typ).Register(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	m.httpMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	names := make(map[string]bool)
+	for _, l := range strings.Split(w.Body.String(), "\n") {
+		if len(l) == 0 || l[0] == '#' {
+			continue
+		}
+		name, _, _ := strings.Cut(l, "{")
+		name, _, _ = strings.Cut(name, " ")
+		names[name] = true
+	}
+
+	js, err := uiFiles.ReadFile("ui/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := regexp.MustCompile(`(?:sum|byLabel)\(samples, "(\w+)"|s\.name === "(\w+)"`).FindAllStringSubmatch(string(js), -1)
+	if len(used) == 0 {
+		t.Fatal("no metric found in app.js")
+	}
+	for _, u := range used {
+		name := u[1] + u[2]
+		if !names[name] {
+			t.Errorf("metric %s used by the dashboard is not in /metrics", name)
+		}
+	}
+}
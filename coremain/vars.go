@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const maxVarDepth = 8
+
+// expandVars returns a copy of v with variables in its strings expanded.
+// See expandString. Only strings, slices, maps and interfaces are walked.
+// Other values (e.g. typed args from Go callers) are returned as they are.
+func expandVars(v any, vars map[string]string) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	rv, err := expandValue(reflect.ValueOf(v), vars)
+	if err != nil {
+		return nil, err
+	}
+	return rv.Interface(), nil
+}
+
+func expandValue(v reflect.Value, vars map[string]string) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := expandString(v.String(), vars, 0)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		nv := reflect.New(v.Type()).Elem()
+		nv.SetString(s)
+		return nv, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, nil
+		}
+		e, err := expandValue(v.Elem(), vars)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		nv := reflect.New(v.Type()).Elem()
+		nv.Set(e)
+		return nv, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return v, nil
+		}
+		nv := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := expandValue(v.Index(i), vars)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			nv.Index(i).Set(e)
+		}
+		return nv, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return v, nil
+		}
+		nv := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			e, err := expandValue(iter.Value(), vars)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			nv.SetMapIndex(iter.Key(), e)
+		}
+		return nv, nil
+	default:
+		return v, nil
+	}
+}
+
+// expandString replaces "${NAME}" with the value of variable NAME and
+// "${env:NAME}" with the environment variable NAME. Variable names are
+// case-insensitive and must be lower case in vars. Variable values
+// are expanded as well. "$${" is an escape of "${". Other "$" are
+// kept as they are, so "$tag" references are not affected.
+// It is an error if a variable is not defined.
+func expandString(s string, vars map[string]string, depth int) (string, error) {
+	if depth > maxVarDepth {
+		return "", fmt.Errorf("maximum variable depth reached, variables may refer to each other")
+	}
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	b := new(strings.Builder)
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' { // Escaped.
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		name, rest, ok := strings.Cut(s[i+2:], "}")
+		if !ok {
+			return "", fmt.Errorf("unclosed variable in %q", s)
+		}
+		s = rest
+
+		if env, ok := strings.CutPrefix(name, "env:"); ok {
+			v, ok := os.LookupEnv(env)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s is not set", env)
+			}
+			b.WriteString(v)
+			continue
+		}
+		v, ok := vars[strings.ToLower(name)]
+		if !ok {
+			return "", fmt.Errorf("undefined variable %s", name)
+		}
+		v, err := expandString(v, vars, depth+1)
+		if err != nil {
+			return "", fmt.Errorf("failed to expand variable %s, %w", name, err)
+		}
+		b.WriteString(v)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package coremain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_expandString(t *testing.T) {
+	t.Setenv("MOSDNS_TEST_ENV", "env_v")
+	vars := map[string]string{
+		"a":    "a_v",
+		"b":    "${a}:${env:MOSDNS_TEST_ENV}",
+		"loop": "${loop}",
+	}
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"no vars $tag", "no vars $tag", false},
+		{"${A}", "a_v", false},
+		{"x ${b} y", "x a_v:env_v y", false},
+		{"$${a}", "${a}", false},
+		{"${undefined}", "", true},
+		{"${env:MOSDNS_TEST_UNSET_ENV}", "", true},
+		{"${a", "", true},
+		{"${loop}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := expandString(tt.s, vars, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("expandString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_expandVars(t *testing.T) {
+	vars := map[string]string{"addr": "127.0.0.1:53", "n": "1"}
+	args := map[string]any{
+		"listen":  "${addr}",
+		"entries": []any{map[string]any{"exec": "$main ${n}"}},
+		"typed":   []string{"${n}"},
+		"int":     1,
+	}
+	want := map[string]any{
+		"listen":  "127.0.0.1:53",
+		"entries": []any{map[string]any{"exec": "$main 1"}},
+		"typed":   []string{"1"},
+		"int":     1,
+	}
+	got, err := expandVars(args, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expandVars() = %v, want %v", got, want)
+	}
+	if args["listen"] != "${addr}" {
+		t.Fatal("expandVars() modified its input")
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mlog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Levels controls the global log level and per-tag log levels of loggers
+// from NewLoggerWithLevels at runtime. Tags are case-insensitive.
+type Levels struct {
+	global *tagLevel
+
+	m    sync.Mutex
+	tags map[string]*tagLevel
+}
+
+// tagLevel is the level of a tag. If a tag has no level, the global
+// level is used.
+type tagLevel struct {
+	set   atomic.Bool
+	level zap.AtomicLevel
+
+	// Protected by Levels.m.
+	base      *zapcore.Level // Level from config, restored after TTL.
+	expiresAt time.Time
+	timer     *time.Timer
+}
+
+func newLevels(global zapcore.Level, tags map[string]string) (*Levels, error) {
+	ls := &Levels{global: newTagLevel(&global), tags: make(map[string]*tagLevel)}
+	for tag, s := range tags {
+		lvl, err := zapcore.ParseLevel(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level for %s: %w", tag, err)
+		}
+		ls.tags[strings.ToLower(tag)] = newTagLevel(&lvl)
+	}
+	return ls, nil
+}
+
+func newTagLevel(base *zapcore.Level) *tagLevel {
+	tl := &tagLevel{level: zap.NewAtomicLevel(), base: base}
+	if base != nil {
+		tl.level.SetLevel(*base)
+		tl.set.Store(true)
+	}
+	return tl
+}
+
+// get returns the tagLevel of tag. Empty tag is the global level.
+func (ls *Levels) get(tag string) *tagLevel {
+	if len(tag) == 0 {
+		return ls.global
+	}
+	tag = strings.ToLower(tag)
+	ls.m.Lock()
+	defer ls.m.Unlock()
+	tl := ls.tags[tag]
+	if tl == nil {
+		tl = newTagLevel(nil)
+		ls.tags[tag] = tl
+	}
+	return tl
+}
+
+func (ls *Levels) enabled(tl *tagLevel, lvl zapcore.Level) bool {
+	if tl.set.Load() {
+		return tl.level.Enabled(lvl)
+	}
+	return ls.global.level.Enabled(lvl)
+}
+
+// SetLevel sets the level of tag. Empty tag is the global level.
+// If ttl > 0, the level is reverted to its configured level after ttl.
+func (ls *Levels) SetLevel(tag string, lvl zapcore.Level, ttl time.Duration) {
+	tl := ls.get(tag)
+	ls.m.Lock()
+	defer ls.m.Unlock()
+	if tl.timer != nil {
+		tl.timer.Stop()
+		tl.timer = nil
+	}
+	tl.expiresAt = time.Time{}
+	tl.level.SetLevel(lvl)
+	tl.set.Store(true)
+	if ttl > 0 {
+		tl.expiresAt = time.Now().Add(ttl)
+		var timer *time.Timer
+		timer = time.AfterFunc(ttl, func() {
+			ls.m.Lock()
+			defer ls.m.Unlock()
+			if tl.timer == timer { // Not replaced by another SetLevel.
+				ls.revertLocked(tl)
+			}
+		})
+		tl.timer = timer
+	}
+}
+
+// ResetLevel reverts the level of tag to its configured level.
+func (ls *Levels) ResetLevel(tag string) {
+	tl := ls.get(tag)
+	ls.m.Lock()
+	defer ls.m.Unlock()
+	ls.revertLocked(tl)
+}
+
+func (ls *Levels) revertLocked(tl *tagLevel) {
+	if tl.timer != nil {
+		tl.timer.Stop()
+		tl.timer = nil
+	}
+	tl.expiresAt = time.Time{}
+	if tl.base != nil {
+		tl.level.SetLevel(*tl.base)
+		tl.set.Store(true)
+	} else {
+		tl.set.Store(false)
+	}
+}
+
+// LevelInfo is the level of a tag.
+type LevelInfo struct {
+	Level     string     `json:"level"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Level will be reverted at this time.
+}
+
+// Snapshot returns the global level and levels of all tags that have a level.
+func (ls *Levels) Snapshot() (global LevelInfo, tags map[string]LevelInfo) {
+	ls.m.Lock()
+	defer ls.m.Unlock()
+	info := func(tl *tagLevel) LevelInfo {
+		i := LevelInfo{Level: tl.level.Level().String()}
+		if !tl.expiresAt.IsZero() {
+			t := tl.expiresAt
+			i.ExpiresAt = &t
+		}
+		return i
+	}
+	tags = make(map[string]LevelInfo)
+	for tag, tl := range ls.tags {
+		if tl.set.Load() {
+			tags[tag] = info(tl)
+		}
+	}
+	return info(ls.global), tags
+}
+
+// levelCore filters logs by the level of its tag. The wrapped core
+// must enable all levels.
+type levelCore struct {
+	zapcore.Core
+	ls *Levels
+	tl *tagLevel
+}
+
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return c.ls.enabled(c.tl, lvl)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), ls: c.ls, tl: c.tl}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return c.Core.Check(ent, ce)
+	}
+	return ce
+}
+
+// WithTag returns a logger named tag that uses the level of tag.
+// If l was not created by NewLoggerWithLevels, it is the same as l.Named(tag).
+func WithTag(l *zap.Logger, tag string) *zap.Logger {
+	return l.Named(tag).WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		if lc, ok := c.(*levelCore); ok {
+			return &levelCore{Core: lc.Core, ls: lc.ls, tl: lc.ls.get(tag)}
+		}
+		return c
+	}))
+}
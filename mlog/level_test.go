@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mlog
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLevels(t *testing.T) {
+	ls, err := newLevels(zapcore.InfoLevel, map[string]string{"Cfg": "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, logs := observer.New(zapcore.DebugLevel)
+	root := zap.New(&levelCore{Core: core, ls: ls, tl: ls.global})
+	p1 := WithTag(root, "p1")
+	cfg := WithTag(root, "cfg").With(zap.String("k", "v"))
+
+	debugCount := func() int {
+		n := logs.FilterLevelExact(zapcore.DebugLevel).Len()
+		logs.TakeAll()
+		return n
+	}
+
+	root.Debug("")
+	p1.Debug("")
+	cfg.Debug("")
+	if n := debugCount(); n != 1 {
+		t.Fatalf("want 1 debug log from the configured tag, got %d", n)
+	}
+
+	ls.SetLevel("p1", zapcore.DebugLevel, time.Millisecond*50)
+	p1.Debug("")
+	root.Debug("")
+	if n := debugCount(); n != 1 {
+		t.Fatalf("want 1 debug log from p1, got %d", n)
+	}
+	if _, tags := ls.Snapshot(); tags["p1"].ExpiresAt == nil {
+		t.Fatal("p1 level should have an expiration time")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	p1.Debug("")
+	if n := debugCount(); n != 0 {
+		t.Fatalf("p1 level should be reverted after ttl, got %d debug logs", n)
+	}
+
+	ls.SetLevel("", zapcore.DebugLevel, 0)
+	ls.SetLevel("cfg", zapcore.ErrorLevel, 0)
+	root.Debug("")
+	p1.Debug("")
+	cfg.Info("")
+	if n := debugCount(); n != 2 {
+		t.Fatalf("want 2 debug logs, got %d", n)
+	}
+	ls.ResetLevel("cfg")
+	cfg.Debug("")
+	if n := debugCount(); n != 1 {
+		t.Fatalf("cfg level should be reset to debug, got %d", n)
+	}
+}
@@ -21,9 +21,12 @@ package mlog
 
 import (
 	"fmt"
+	"os"
+	"time"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"os"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type LogConfig struct {
@@ -36,6 +39,51 @@ type LogConfig struct {
 
 	// Production enables json output.
 	Production bool `yaml:"production"`
+
+	// PluginLevels overrides Level for plugins. Keys are plugin tags.
+	// See Levels.
+	PluginLevels map[string]string `yaml:"plugin_levels"`
+
+	// Rotate rotates File. See RotateConfig.
+	Rotate RotateConfig `yaml:"rotate"`
+
+	// Syslog also sends logs to a syslog server, if Syslog.Addr
+	// or Syslog.Network is set.
+	Syslog *SyslogConfig `yaml:"syslog"`
+
+	// Sampling limits the number of logs that have the same level and
+	// message per tick. See zapcore.NewSamplerWithOptions.
+	Sampling SamplingConfig `yaml:"sampling"`
+
+	// RateLimit collapses repeated logs into periodic summaries.
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+}
+
+// RotateConfig configures log file rotation. Rotation is disabled if
+// MaxSize is 0.
+type RotateConfig struct {
+	// MaxSize in megabytes of the log file before it gets rotated.
+	MaxSize int `yaml:"max_size"`
+	// MaxAge in days to retain old log files. 0 means no limit.
+	MaxAge int `yaml:"max_age"`
+	// MaxBackups is the maximum number of old log files to retain.
+	// 0 means no limit.
+	MaxBackups int `yaml:"max_backups"`
+	// Compress old log files with gzip.
+	Compress bool `yaml:"compress"`
+	// LocalTime uses local time in names of old log files. Default is UTC.
+	LocalTime bool `yaml:"local_time"`
+}
+
+// SamplingConfig configures zap sampling. Sampling is disabled if
+// Initial is 0.
+type SamplingConfig struct {
+	// Initial logs per tick are always logged.
+	Initial int `yaml:"initial"`
+	// Thereafter, every Nth log is logged. 0 drops all of them.
+	Thereafter int `yaml:"thereafter"`
+	// Tick in seconds. Default is 1.
+	Tick int `yaml:"tick"`
 }
 
 var (
@@ -47,27 +95,95 @@ var (
 	nop = zap.NewNop()
 )
 
+// Validate checks lc without opening the log file or connecting to the
+// syslog server.
+func (lc LogConfig) Validate() error {
+	lvl, err := zapcore.ParseLevel(lc.Level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+	if _, err := newLevels(lvl, lc.PluginLevels); err != nil {
+		return err
+	}
+	if sc := lc.Syslog; sc != nil && (len(sc.Addr) > 0 || len(sc.Network) > 0) {
+		if _, _, _, err := sc.parse(); err != nil {
+			return fmt.Errorf("invalid syslog config: %w", err)
+		}
+	}
+	return nil
+}
+
 func NewLogger(lc LogConfig) (*zap.Logger, error) {
+	l, _, err := NewLoggerWithLevels(lc)
+	return l, err
+}
+
+// NewLoggerWithLevels returns a logger and the Levels that controls it.
+// Use WithTag to create loggers that have their own levels.
+func NewLoggerWithLevels(lc LogConfig) (*zap.Logger, *Levels, error) {
 	lvl, err := zapcore.ParseLevel(lc.Level)
 	if err != nil {
-		return nil, fmt.Errorf("invalid log level: %w", err)
+		return nil, nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	ls, err := newLevels(lvl, lc.PluginLevels)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var out zapcore.WriteSyncer
 	if lf := lc.File; len(lf) > 0 {
-		f, _, err := zap.Open(lf)
-		if err != nil {
-			return nil, fmt.Errorf("open log file: %w", err)
+		if lc.Rotate.MaxSize > 0 {
+			// lumberjack is safe for concurrent use.
+			out = zapcore.AddSync(&lumberjack.Logger{
+				Filename:   lf,
+				MaxSize:    lc.Rotate.MaxSize,
+				MaxAge:     lc.Rotate.MaxAge,
+				MaxBackups: lc.Rotate.MaxBackups,
+				LocalTime:  lc.Rotate.LocalTime,
+				Compress:   lc.Rotate.Compress,
+			})
+		} else {
+			f, _, err := zap.Open(lf)
+			if err != nil {
+				return nil, nil, fmt.Errorf("open log file: %w", err)
+			}
+			out = zapcore.Lock(f)
 		}
-		out = zapcore.Lock(f)
 	} else {
 		out = stderr
 	}
 
+	var enc zapcore.Encoder
 	if lc.Production {
-		return zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), out, lvl)), nil
+		enc = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	} else {
+		enc = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	}
+	// Levels are checked by levelCore.
+	core := zapcore.NewCore(enc, out, zapcore.DebugLevel)
+
+	if sc := lc.Syslog; sc != nil && (len(sc.Addr) > 0 || len(sc.Network) > 0) {
+		sysCore, err := newSyslogCore(*sc, lc.Production)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid syslog config: %w", err)
+		}
+		core = zapcore.NewTee(core, sysCore)
 	}
-	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), out, lvl)), nil
+
+	if sc := lc.Sampling; sc.Initial > 0 {
+		tick := time.Duration(sc.Tick) * time.Second
+		if tick <= 0 {
+			tick = time.Second
+		}
+		core = zapcore.NewSamplerWithOptions(core, tick, sc.Initial, sc.Thereafter)
+	}
+
+	if lc.RateLimit.Burst > 0 {
+		core = &rateLimitCore{Core: core, rl: newRateLimiter(lc.RateLimit, core)}
+	}
+
+	// levelCore must be the outermost core. See WithTag.
+	return zap.New(&levelCore{Core: core, ls: ls, tl: ls.global}), ls, nil
 }
 
 // L is a global logger.
@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogConfig_Validate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mosdns.log")
+	lc := LogConfig{
+		Level:        "warn",
+		File:         file,
+		Rotate:       RotateConfig{MaxSize: 1},
+		PluginLevels: map[string]string{"a": "debug"},
+		Syslog:       &SyslogConfig{Network: "udp", Addr: "127.0.0.1:514"},
+	}
+	if err := lc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("Validate() should not create the log file, %v", err)
+	}
+
+	for _, lc := range []LogConfig{
+		{Level: "verbose"},
+		{PluginLevels: map[string]string{"a": "verbose"}},
+		{Syslog: &SyslogConfig{Network: "tcp"}},
+		{Syslog: &SyslogConfig{Addr: "/dev/log", Facility: "no_such_facility"}},
+	} {
+		if err := lc.Validate(); err == nil {
+			t.Fatalf("want error for %+v", lc)
+		}
+	}
+}
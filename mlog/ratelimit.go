@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mlog
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RateLimitConfig limits logs that have the same key. The key of a log is
+// its level, logger name and message. Logs over the limit are dropped and
+// counted. A summary is logged for each key at the end of each interval
+// in which logs were dropped.
+type RateLimitConfig struct {
+	// Burst is the maximum number of logs per key per interval.
+	// Zero disables rate limit.
+	Burst int `yaml:"burst"`
+	// Interval in seconds. Default is 10.
+	Interval int `yaml:"interval"`
+}
+
+type rateLimitKey struct {
+	level   zapcore.Level
+	logger  string
+	message string
+}
+
+type rateLimitCounter struct {
+	windowStart time.Time
+	n           int
+	dropped     int
+}
+
+// rateLimiter is shared by all rateLimitCores of the same logger.
+type rateLimiter struct {
+	burst    int
+	interval time.Duration
+	out      zapcore.Core // Summaries are written to it.
+
+	m        sync.Mutex
+	counters map[rateLimitKey]*rateLimitCounter
+	flushing bool
+}
+
+func newRateLimiter(rc RateLimitConfig, out zapcore.Core) *rateLimiter {
+	interval := time.Duration(rc.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+	return &rateLimiter{
+		burst:    rc.Burst,
+		interval: interval,
+		out:      out,
+		counters: make(map[rateLimitKey]*rateLimitCounter),
+	}
+}
+
+func (rl *rateLimiter) allow(k rateLimitKey, now time.Time) bool {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+	c := rl.counters[k]
+	if c == nil {
+		c = &rateLimitCounter{windowStart: now}
+		rl.counters[k] = c
+	}
+	if now.Sub(c.windowStart) >= rl.interval && c.dropped == 0 {
+		c.windowStart = now
+		c.n = 0
+	}
+	if c.n < rl.burst {
+		c.n++
+		return true
+	}
+	c.dropped++
+	if !rl.flushing {
+		rl.flushing = true
+		go rl.flushLoop()
+	}
+	return false
+}
+
+// flushLoop writes summaries of dropped logs every interval. It exits
+// once there is nothing to flush, and is restarted by allow.
+func (rl *rateLimiter) flushLoop() {
+	t := time.NewTicker(rl.interval)
+	defer t.Stop()
+	for now := range t.C {
+		if !rl.flush(now) {
+			return
+		}
+	}
+}
+
+// flush writes summaries of windows that ended before now, and removes
+// idle counters. It returns false and stops flushing if no logs are
+// being dropped.
+func (rl *rateLimiter) flush(now time.Time) bool {
+	type summary struct {
+		k       rateLimitKey
+		dropped int
+	}
+	var ss []summary
+	rl.m.Lock()
+	active := false
+	for k, c := range rl.counters {
+		if now.Sub(c.windowStart) < rl.interval {
+			if c.dropped > 0 {
+				active = true
+			}
+			continue
+		}
+		if c.dropped > 0 {
+			ss = append(ss, summary{k: k, dropped: c.dropped})
+		}
+		delete(rl.counters, k)
+	}
+	if !active {
+		rl.flushing = false
+	}
+	rl.m.Unlock()
+
+	for _, s := range ss {
+		ent := zapcore.Entry{Level: s.k.level, Time: now, LoggerName: s.k.logger, Message: "repeated logs were suppressed"}
+		if ce := rl.out.Check(ent, nil); ce != nil {
+			ce.Write(zap.String("msg", s.k.message), zap.Int("suppressed", s.dropped), zap.Duration("interval", rl.interval))
+		}
+	}
+	return active
+}
+
+type rateLimitCore struct {
+	zapcore.Core
+	rl *rateLimiter
+}
+
+func (c *rateLimitCore) With(fields []zapcore.Field) zapcore.Core {
+	return &rateLimitCore{Core: c.Core.With(fields), rl: c.rl}
+}
+
+func (c *rateLimitCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.rl.allow(rateLimitKey{level: ent.Level, logger: ent.LoggerName, message: ent.Message}, ent.Time) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mlog
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func Test_rateLimitCore(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	rl := newRateLimiter(RateLimitConfig{Burst: 2}, core)
+	rl.flushing = true // Flush manually.
+	l := zap.New(&rateLimitCore{Core: core, rl: rl})
+
+	for i := 0; i < 5; i++ {
+		l.Warn("upstream failed", zap.Int("i", i))
+		l.Warn("other")
+	}
+	if n := logs.FilterMessage("upstream failed").Len(); n != 2 {
+		t.Fatalf("want 2 logs, got %d", n)
+	}
+	if n := logs.FilterMessage("other").Len(); n != 2 {
+		t.Fatalf("want 2 logs, got %d", n)
+	}
+
+	if !rl.flush(time.Now()) {
+		t.Fatal("flush() should be active until the window ends")
+	}
+	if rl.flush(time.Now().Add(rl.interval)) {
+		t.Fatal("flush() should be inactive after summaries were logged")
+	}
+	ss := logs.FilterMessage("repeated logs were suppressed").FilterField(zap.String("msg", "upstream failed")).All()
+	if len(ss) != 1 || ss[0].ContextMap()["suppressed"] != int64(3) || ss[0].Level != zapcore.WarnLevel {
+		t.Fatalf("unexpected summary %+v", ss)
+	}
+
+	// The window was reset.
+	logs.TakeAll()
+	l.Warn("upstream failed")
+	if n := logs.Len(); n != 1 {
+		t.Fatalf("want 1 log in the new window, got %d", n)
+	}
+}
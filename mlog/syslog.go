@@ -0,0 +1,269 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SyslogConfig configures a RFC 5424 syslog output.
+type SyslogConfig struct {
+	// Network is "unix", "unixgram" or "udp". Default is "unix", which
+	// tries "unixgram" and then "unix" (stream).
+	Network string `yaml:"network"`
+	// Addr is the socket path or the udp address. Default is "/dev/log".
+	Addr string `yaml:"addr"`
+	// AppName in syslog messages. Default is "mosdns".
+	AppName string `yaml:"app_name"`
+	// Facility name, e.g. "daemon", "local0". Default is "daemon".
+	Facility string `yaml:"facility"`
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogCore is a zapcore.Core that sends entries to a syslog server.
+// Levels are checked by levelCore, so it enables all levels.
+type syslogCore struct {
+	enc      zapcore.Encoder
+	w        *syslogWriter
+	facility int
+	appName  string
+	hostname string
+}
+
+// parse returns the facility, network and addr of sc with defaults.
+func (sc SyslogConfig) parse() (facility int, network, addr string, err error) {
+	facility = 3
+	if len(sc.Facility) > 0 {
+		f, ok := syslogFacilities[strings.ToLower(sc.Facility)]
+		if !ok {
+			return 0, "", "", fmt.Errorf("invalid syslog facility %s", sc.Facility)
+		}
+		facility = f
+	}
+	network = sc.Network
+	switch network {
+	case "":
+		network = "unix"
+	case "unix", "unixgram", "udp":
+	default:
+		return 0, "", "", fmt.Errorf("invalid syslog network %s", network)
+	}
+	addr = sc.Addr
+	if len(addr) == 0 {
+		addr = "/dev/log"
+	}
+	return facility, network, addr, nil
+}
+
+func newSyslogCore(sc SyslogConfig, production bool) (*syslogCore, error) {
+	facility, network, addr, err := sc.parse()
+	if err != nil {
+		return nil, err
+	}
+	appName := sc.AppName
+	if len(appName) == 0 {
+		appName = "mosdns"
+	}
+	hostname, _ := os.Hostname()
+	if len(hostname) == 0 {
+		hostname = "-"
+	}
+
+	// Time and level are in the syslog header.
+	var enc zapcore.Encoder
+	if production {
+		ec := zap.NewProductionEncoderConfig()
+		ec.TimeKey, ec.LevelKey = "", ""
+		enc = zapcore.NewJSONEncoder(ec)
+	} else {
+		ec := zap.NewDevelopmentEncoderConfig()
+		ec.TimeKey, ec.LevelKey = "", ""
+		enc = zapcore.NewConsoleEncoder(ec)
+	}
+	return &syslogCore{
+		enc:      enc,
+		w:        &syslogWriter{network: network, addr: addr},
+		facility: facility,
+		appName:  appName,
+		hostname: hostname,
+	}, nil
+}
+
+func (c *syslogCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	nc := *c
+	nc.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(nc.enc)
+	}
+	return &nc
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	msg := formatSyslog(c.facility, ent.Level, ent.Time, c.hostname, c.appName, bytes.TrimRight(buf.Bytes(), "\n"))
+	return c.w.write(msg)
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
+
+func syslogSeverity(l zapcore.Level) int {
+	switch l {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel, zapcore.PanicLevel:
+		return 2
+	default: // Fatal
+		return 1
+	}
+}
+
+// formatSyslog formats a RFC 5424 message. There is no structured data.
+func formatSyslog(facility int, l zapcore.Level, t time.Time, hostname, appName string, msg []byte) []byte {
+	b := make([]byte, 0, 64+len(msg))
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(facility*8+syslogSeverity(l)), 10)
+	b = append(b, ">1 "...)
+	b = t.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, ' ')
+	b = append(b, hostname...)
+	b = append(b, ' ')
+	b = append(b, appName...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(os.Getpid()), 10)
+	b = append(b, " - - "...)
+	b = append(b, msg...)
+	return b
+}
+
+const (
+	syslogWriteTimeout  = time.Second
+	syslogRetryInterval = 5 * time.Second
+)
+
+var errSyslogDisconnected = errors.New("syslog is disconnected")
+
+// syslogWriter writes messages to a syslog socket. It (re)connects lazily.
+// A stalled daemon blocks a write for at most syslogWriteTimeout. After a
+// failed dial or a timed out write, messages are dropped without redialling
+// for syslogRetryInterval.
+type syslogWriter struct {
+	network string
+	addr    string
+
+	m          sync.Mutex
+	c          net.Conn
+	stream     bool // Messages are framed by '\n'.
+	retryAfter time.Time
+}
+
+func (w *syslogWriter) write(msg []byte) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	// Retry once with a new connection.
+	for i := 0; i < 2; i++ {
+		if w.c == nil {
+			now := time.Now()
+			if now.Before(w.retryAfter) {
+				return errSyslogDisconnected
+			}
+			if err := w.connect(); err != nil {
+				w.retryAfter = now.Add(syslogRetryInterval)
+				return err
+			}
+		}
+		_ = w.c.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		var err error
+		if w.stream {
+			_, err = w.c.Write(append(msg, '\n'))
+		} else {
+			_, err = w.c.Write(msg)
+		}
+		if err == nil {
+			return nil
+		}
+		_ = w.c.Close()
+		w.c = nil
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// The daemon is stalled. Don't block on it again right away.
+			w.retryAfter = time.Now().Add(syslogRetryInterval)
+			return err
+		}
+		if i == 1 {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *syslogWriter) connect() error {
+	const dialTimeout = time.Second
+	var err error
+	switch w.network {
+	case "unix":
+		if w.c, err = net.DialTimeout("unixgram", w.addr, dialTimeout); err == nil {
+			w.stream = false
+			return nil
+		}
+		w.c, err = net.DialTimeout("unix", w.addr, dialTimeout)
+		w.stream = true
+	default:
+		w.c, err = net.DialTimeout(w.network, w.addr, dialTimeout)
+		w.stream = false
+	}
+	return err
+}
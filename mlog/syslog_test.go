@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mlog
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_syslogCore(t *testing.T) {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	l, _, err := NewLoggerWithLevels(LogConfig{
+		Level:      "info",
+		File:       t.TempDir() + "/mosdns.log",
+		Production: true,
+		Syslog:     &SyslogConfig{Network: "udp", Addr: c.LocalAddr().String(), Facility: "local0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Named("forward").Warn("upstream failed")
+
+	b := make([]byte, 1024)
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := c.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local0 (16) * 8 + warning (4) = 132
+	re := regexp.MustCompile(`^<132>1 \S+ \S+ mosdns \d+ - - \{"logger":"forward","msg":"upstream failed"\}$`)
+	if msg := string(b[:n]); !re.MatchString(msg) {
+		t.Fatalf("unexpected syslog message %q", msg)
+	}
+
+	if _, err := newSyslogCore(SyslogConfig{Facility: "no_such_facility"}, false); err == nil {
+		t.Fatal("invalid facility should be rejected")
+	}
+}
+
+func Test_syslogWriter_stalled(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "syslog.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		// Accept but never read.
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	w := &syslogWriter{network: "unix", addr: sock}
+	msg := make([]byte, 1<<20)
+	start := time.Now()
+	var werr error
+	for i := 0; i < 64 && werr == nil; i++ {
+		werr = w.write(msg)
+	}
+	if !errors.Is(werr, os.ErrDeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", werr)
+	}
+	if d := time.Since(start); d > 5*syslogWriteTimeout {
+		t.Fatalf("write blocked for %s", d)
+	}
+
+	// Stalled daemon is not redialled right away.
+	start = time.Now()
+	if err := w.write([]byte("msg")); !errors.Is(err, errSyslogDisconnected) {
+		t.Fatalf("want errSyslogDisconnected, got %v", err)
+	}
+	if d := time.Since(start); d > syslogWriteTimeout/2 {
+		t.Fatalf("write blocked for %s", d)
+	}
+}
+
+func Test_syslogWriter_retryInterval(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "syslog.sock")
+	w := &syslogWriter{network: "unixgram", addr: sock}
+	if err := w.write([]byte("msg")); err == nil || errors.Is(err, errSyslogDisconnected) {
+		t.Fatalf("want dial error, got %v", err)
+	}
+
+	// The daemon comes up, but the writer waits for the retry interval.
+	c, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := w.write([]byte("msg")); !errors.Is(err, errSyslogDisconnected) {
+		t.Fatalf("want errSyslogDisconnected, got %v", err)
+	}
+
+	w.m.Lock()
+	w.retryAfter = time.Time{}
+	w.m.Unlock()
+	if err := w.write([]byte("msg")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 16)
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := c.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "msg" {
+		t.Fatalf("unexpected message %q", b[:n])
+	}
+}
@@ -20,6 +20,7 @@
 package query_context
 
 import (
+	"slices"
 	"sync/atomic"
 	"time"
 
@@ -49,6 +50,9 @@ type Context struct {
 	respOpt     *dns.OPT // nil if clientOpt == nil
 	upstreamOpt *dns.OPT // may be nil
 
+	upstream string // Name of the upstream that answered the query.
+	traceID  string // Empty if the query is not traced.
+
 	// lazy init.
 	kv    map[uint32]any
 	marks map[uint32]struct{}
@@ -188,6 +192,8 @@ func (ctx *Context) CopyTo(d *Context) *Context {
 		d.respOpt = dns.Copy(ctx.respOpt).(*dns.OPT)
 	}
 	d.upstreamOpt = ctx.upstreamOpt
+	d.upstream = ctx.upstream
+	d.traceID = ctx.traceID
 
 	d.kv = copyMap(ctx.kv)
 	d.marks = copyMap(ctx.marks)
@@ -233,9 +239,48 @@ func (ctx *Context) DeleteMark(m uint32) {
 	delete(ctx.marks, m)
 }
 
+// Marks returns all marks of this Context in ascending order.
+func (ctx *Context) Marks() []uint32 {
+	if len(ctx.marks) == 0 {
+		return nil
+	}
+	ms := make([]uint32, 0, len(ctx.marks))
+	for m := range ctx.marks {
+		ms = append(ms, m)
+	}
+	slices.Sort(ms)
+	return ms
+}
+
+// SetUpstream records the name of the upstream that answered the query.
+// It should be called by plugins that set the response from an upstream.
+func (ctx *Context) SetUpstream(name string) {
+	ctx.upstream = name
+}
+
+// Upstream returns the name set by SetUpstream. It is empty if the response
+// was not from an upstream.
+func (ctx *Context) Upstream() string {
+	return ctx.upstream
+}
+
+// SetTraceID sets the id of the trace that this query belongs to.
+func (ctx *Context) SetTraceID(id string) {
+	ctx.traceID = id
+}
+
+// TraceID returns the id set by SetTraceID. It is empty if the query
+// is not traced.
+func (ctx *Context) TraceID() string {
+	return ctx.traceID
+}
+
 // MarshalLogObject implements zapcore.ObjectMarshaler.
 func (ctx *Context) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
 	encoder.AddUint32("uqid", ctx.id)
+	if len(ctx.traceID) > 0 {
+		encoder.AddString("trace_id", ctx.traceID)
+	}
 
 	if clientAddr := ctx.ServerMeta.ClientAddr; clientAddr.IsValid() {
 		zap.Stringer("client", clientAddr).AddTo(encoder)
@@ -22,8 +22,6 @@ package server
 import (
 	"context"
 	"fmt"
-	"net"
-	"net/netip"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
@@ -69,11 +67,8 @@ func ServeDoQ(l *quic.Listener, h Handler, opts DoQServerOpts) error {
 			defer c.CloseWithError(0, "")
 			defer cancelConn(errConnectionCtxCanceled)
 
-			var clientAddr netip.Addr
-			ta, ok := c.RemoteAddr().(*net.UDPAddr)
-			if ok {
-				clientAddr = ta.AddrPort().Addr()
-			}
+			clientAddr := netAddrPort(c.RemoteAddr())
+			serverAddr := netAddrPort(c.LocalAddr())
 
 			firstRead := true
 			for {
@@ -105,7 +100,10 @@ func ServeDoQ(l *quic.Listener, h Handler, opts DoQServerOpts) error {
 						return
 					}
 					queryMeta := QueryMeta{
-						ClientAddr: clientAddr,
+						ClientAddr: clientAddr.Addr(),
+						ClientPort: clientAddr.Port(),
+						ServerAddr: serverAddr,
+						Protocol:   ProtocolQUIC,
 						ServerName: c.ConnectionState().TLS.ServerName,
 					}
 
@@ -24,6 +24,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/netip"
 	"strings"
@@ -96,6 +97,13 @@ func (h *HttpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	queryMeta := QueryMeta{
 		ClientAddr: clientAddr,
+		Protocol:   ProtocolHTTPS,
+	}
+	if clientAddr == addrPort.Addr() {
+		queryMeta.ClientPort = addrPort.Port()
+	}
+	if la, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		queryMeta.ServerAddr = netAddrPort(la)
 	}
 	if u := req.URL; u != nil {
 		queryMeta.UrlPath = u.Path
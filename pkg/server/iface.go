@@ -24,6 +24,20 @@ type QueryMeta struct {
 
 	// Optional
 	ClientAddr netip.Addr
+	ClientPort uint16
+	ServerAddr netip.AddrPort // Local address that received the query.
+	Protocol   Protocol
 	ServerName string
 	UrlPath    string
 }
+
+// Protocol is the transport protocol of a query.
+type Protocol string
+
+const (
+	ProtocolUDP   Protocol = "udp"
+	ProtocolTCP   Protocol = "tcp"
+	ProtocolTLS   Protocol = "tls"
+	ProtocolHTTPS Protocol = "https" // DoH. Including DoH over plain http.
+	ProtocolQUIC  Protocol = "quic"
+)
@@ -24,7 +24,6 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
-	"net/netip"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
@@ -89,19 +88,18 @@ func ServeTCP(l net.Listener, h Handler, opts TCPServerOpts) error {
 				}
 
 				// Try to get server name from tls conn.
-				var serverName string
+				meta := QueryMeta{Protocol: ProtocolTCP}
 				if tlsConn, ok := c.(*tls.Conn); ok {
-					serverName = tlsConn.ConnectionState().ServerName
+					meta.ServerName = tlsConn.ConnectionState().ServerName
+					meta.Protocol = ProtocolTLS
 				}
 
 				// handle query
 				go func() {
-					var clientAddr netip.Addr
-					ta, ok := c.RemoteAddr().(*net.TCPAddr)
-					if ok {
-						clientAddr = ta.AddrPort().Addr()
-					}
-					r := h.Handle(tcpConnCtx, req, QueryMeta{ClientAddr: clientAddr, ServerName: serverName}, pool.PackTCPBuffer)
+					clientAddr := netAddrPort(c.RemoteAddr())
+					meta.ClientAddr, meta.ClientPort = clientAddr.Addr(), clientAddr.Port()
+					meta.ServerAddr = netAddrPort(c.LocalAddr())
+					r := h.Handle(tcpConnCtx, req, meta, pool.PackTCPBuffer)
 					if r == nil {
 						c.Close() // abort the connection
 						return
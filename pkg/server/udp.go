@@ -23,6 +23,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/netip"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
 	"github.com/miekg/dns"
@@ -52,6 +53,7 @@ func ServeUDP(c *net.UDPConn, h Handler, opts UDPServerOpts) error {
 	if err != nil {
 		return fmt.Errorf("failed to init oob handler, %w", err)
 	}
+	localAddr := netAddrPort(c.LocalAddr())
 	var ob []byte
 	if oobReader != nil {
 		obp := pool.GetBuf(1024)
@@ -88,7 +90,17 @@ func ServeUDP(c *net.UDPConn, h Handler, opts UDPServerOpts) error {
 
 		// handle query
 		go func() {
-			payload := h.Handle(listenerCtx, q, QueryMeta{ClientAddr: remoteAddr.Addr(), FromUDP: true}, pool.PackBuffer)
+			meta := QueryMeta{
+				FromUDP:    true,
+				ClientAddr: remoteAddr.Addr(),
+				ClientPort: remoteAddr.Port(),
+				ServerAddr: localAddr,
+				Protocol:   ProtocolUDP,
+			}
+			if dst, ok := netip.AddrFromSlice(dstIpFromCm); ok {
+				meta.ServerAddr = netip.AddrPortFrom(dst.Unmap(), localAddr.Port())
+			}
+			payload := h.Handle(listenerCtx, q, meta, pool.PackBuffer)
 			if payload == nil {
 				return
 			}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"errors"
+	"net"
+	"net/netip"
 
 	"go.uber.org/zap"
 )
@@ -14,3 +16,18 @@ var (
 var (
 	nopLogger = zap.NewNop()
 )
+
+// netAddrPort returns the ip and port of a. It returns an invalid
+// netip.AddrPort if a is not an ip address (e.g. an unix socket).
+func netAddrPort(a net.Addr) netip.AddrPort {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort()
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case nil:
+		return netip.AddrPort{}
+	}
+	ap, _ := netip.ParseAddrPort(a.String())
+	return ap
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/IrineSistiana/mosdns/v5/mlog"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
@@ -60,6 +61,9 @@ type EntryHandlerOpts struct {
 	// QueryTimeout limits the timeout value of each query.
 	// Default is defaultQueryTimeout.
 	QueryTimeout time.Duration
+
+	// Tracer starts a root span for each query. Nil disables tracing.
+	Tracer *trace.Tracer
 }
 
 func (opts *EntryHandlerOpts) init() {
@@ -96,8 +100,24 @@ func (h *EntryHandler) Handle(ctx context.Context, q *dns.Msg, serverMeta server
 	qCtx := query_context.NewContext(q)
 	qCtx.ServerMeta = serverMeta
 
+	ctx, span := h.opts.Tracer.Start(ctx, "dns.query", trace.KindServer)
+	defer span.End()
+	if span != nil {
+		qCtx.SetTraceID(span.TraceID())
+		question := q.Question[0]
+		span.SetAttr("dns.qname", question.Name)
+		span.SetAttr("dns.qtype", dns.Type(question.Qtype))
+		if serverMeta.ClientAddr.IsValid() {
+			span.SetAttr("client.address", serverMeta.ClientAddr)
+		}
+		if len(serverMeta.Protocol) > 0 {
+			span.SetAttr("network.protocol", string(serverMeta.Protocol))
+		}
+	}
+
 	// exec entry
 	err := h.opts.Entry.Exec(ctx, qCtx)
+	span.SetError(err)
 	var resp *dns.Msg
 	if err != nil {
 		h.opts.Logger.Warn("entry err", qCtx.InfoField(), zap.Error(err))
@@ -115,6 +135,7 @@ func (h *EntryHandler) Handle(ctx context.Context, q *dns.Msg, serverMeta server
 	}
 	// We assume that our server is a forwarder.
 	resp.RecursionAvailable = true
+	span.SetAttr("dns.rcode", dns.RcodeToString[resp.Rcode])
 
 	// add respOpt back to resp
 	if respOpt := qCtx.RespOpt(); respOpt != nil {
@@ -0,0 +1,290 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package trace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	queueSize     = 4096
+	batchSize     = 512
+	flushInterval = time.Second * 5
+	exportTimeout = time.Second * 10
+)
+
+// exporter batches ended spans and exports them in background.
+// Spans are dropped if the queue is full, so queries are never blocked.
+type exporter struct {
+	serviceName string
+	endpoint    string
+	headers     map[string]string
+	client      *http.Client
+	f           *os.File
+	logger      *zap.Logger
+
+	q         chan *Span
+	closeOnce sync.Once
+	closeReq  chan struct{}
+	closeDone chan struct{}
+}
+
+func newExporter(cfg Config, logger *zap.Logger) (*exporter, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	e := &exporter{
+		serviceName: cfg.ServiceName,
+		endpoint:    cfg.Endpoint,
+		headers:     cfg.Headers,
+		logger:      logger,
+		q:           make(chan *Span, queueSize),
+		closeReq:    make(chan struct{}),
+		closeDone:   make(chan struct{}),
+	}
+	if len(e.serviceName) == 0 {
+		e.serviceName = "mosdns"
+	}
+	if len(cfg.Endpoint) > 0 {
+		e.client = &http.Client{Timeout: exportTimeout}
+	}
+	if len(cfg.File) > 0 {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file, %w", err)
+		}
+		e.f = f
+	}
+	go e.loop()
+	return e, nil
+}
+
+func (e *exporter) add(s *Span) {
+	select {
+	case e.q <- s:
+	default:
+		e.logger.Debug("trace queue is full, span dropped")
+	}
+}
+
+func (e *exporter) loop() {
+	defer close(e.closeDone)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case s := <-e.q:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.closeReq:
+			for {
+				select {
+				case s := <-e.q:
+					batch = append(batch, s)
+					if len(batch) >= batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) close() error {
+	e.closeOnce.Do(func() { close(e.closeReq) })
+	<-e.closeDone
+	if e.f != nil {
+		return e.f.Close()
+	}
+	return nil
+}
+
+func (e *exporter) export(spans []*Span) {
+	b, err := json.Marshal(e.exportRequest(spans))
+	if err != nil {
+		e.logger.Error("failed to marshal spans", zap.Error(err))
+		return
+	}
+	if e.f != nil {
+		if _, err := e.f.Write(append(b, '\n')); err != nil {
+			e.logger.Warn("failed to write spans", zap.Error(err))
+		}
+	}
+	if e.client != nil {
+		if err := e.post(b); err != nil {
+			e.logger.Warn("failed to export spans", zap.String("endpoint", e.endpoint), zap.Error(err))
+		}
+	}
+}
+
+func (e *exporter) post(b []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON structures. See opentelemetry-proto ExportTraceServiceRequest.
+// Ids are hex encoded and 64-bit integers are strings, as the spec requires.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is STATUS_CODE_ERROR
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func otlpValue(v any) otlpAnyValue {
+	switch x := v.(type) {
+	case bool:
+		return otlpAnyValue{BoolValue: &x}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &x}
+	default:
+		s := fmt.Sprint(x)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
+
+func (e *exporter) exportRequest(spans []*Span) otlpRequest {
+	ss := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		ss = append(ss, s.otlp())
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpValue(e.serviceName)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "mosdns"},
+			Spans: ss,
+		}},
+	}}}
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.m.Lock()
+	defer s.m.Unlock()
+	o := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.id[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parent != ([8]byte{}) {
+		o.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for _, a := range s.attrs {
+		o.Attributes = append(o.Attributes, otlpKeyValue{Key: a.key, Value: otlpValue(a.v)})
+	}
+	if len(s.err) > 0 {
+		o.Status = &otlpStatus{Code: 2, Message: s.err}
+	}
+	return o
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package trace is a minimal tracer that exports spans in the OTLP/JSON
+// format. It exports to an OTLP/HTTP collector or to a file.
+//
+// Spans are propagated by context.Context. All functions and methods are
+// safe to call on a nil *Tracer or *Span, which are noops. So callers
+// don't need to check whether tracing is enabled or the query is sampled.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Config struct {
+	// SampleRate is the fraction of queries to trace, in [0, 1].
+	// 0 disables tracing.
+	SampleRate float64 `yaml:"sample_rate"`
+	// Endpoint is an OTLP/HTTP traces endpoint,
+	// e.g. "http://127.0.0.1:4318/v1/traces".
+	Endpoint string `yaml:"endpoint"`
+	// Headers are added to requests to Endpoint.
+	Headers map[string]string `yaml:"headers"`
+	// File that spans will be written into, as JSON Lines of OTLP/JSON
+	// export requests.
+	File string `yaml:"file"`
+	// ServiceName of the resource. Default is "mosdns".
+	ServiceName string `yaml:"service_name"`
+}
+
+// Enabled reports whether tracing is enabled by c.
+func (c Config) Enabled() bool {
+	return c.SampleRate > 0
+}
+
+type Kind int
+
+// Span kinds, same as OTLP.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+type Tracer struct {
+	sampleAll   bool
+	sampleBound uint64 // Trace ids with the lower 8 bytes < sampleBound are sampled.
+	e           *exporter
+}
+
+// Validate checks c without opening any output.
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.SampleRate > 1 {
+		return fmt.Errorf("invalid sample rate %v", c.SampleRate)
+	}
+	if len(c.Endpoint) == 0 && len(c.File) == 0 {
+		return errors.New("tracing is enabled but neither endpoint nor file is set")
+	}
+	if len(c.Endpoint) > 0 {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint, %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid endpoint scheme %q", u.Scheme)
+		}
+	}
+	return nil
+}
+
+// NewTracer returns a Tracer. It returns nil if tracing is disabled by cfg.
+func NewTracer(cfg Config, logger *zap.Logger) (*Tracer, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	e, err := newExporter(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracer{e: e}
+	const maxBound = float64(1<<63) * 2
+	if b := cfg.SampleRate * maxBound; b >= maxBound {
+		t.sampleAll = true
+	} else {
+		t.sampleBound = uint64(b)
+	}
+	return t, nil
+}
+
+// Start starts a root span if the new trace is sampled. Otherwise, it
+// returns ctx and a nil span.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	var traceID [16]byte
+	_, _ = rand.Read(traceID[:])
+	if !t.sampleAll && binary.BigEndian.Uint64(traceID[8:]) >= t.sampleBound {
+		return ctx, nil
+	}
+	s := t.newSpan(traceID, [8]byte{}, name, kind)
+	return ContextWithSpan(ctx, s), s
+}
+
+// Close exports all ended spans and stops the exporter.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.e.close()
+}
+
+func (t *Tracer) newSpan(traceID [16]byte, parent [8]byte, name string, kind Kind) *Span {
+	s := &Span{
+		t:       t,
+		traceID: traceID,
+		parent:  parent,
+		name:    name,
+		kind:    kind,
+		start:   time.Now(),
+	}
+	_, _ = rand.Read(s.id[:])
+	return s
+}
+
+// Span is a traced operation. A span can be used concurrently.
+type Span struct {
+	t       *Tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte // zero for root spans
+	name    string
+	kind    Kind
+	start   time.Time
+
+	m     sync.Mutex
+	end   time.Time
+	attrs []attr
+	err   string
+}
+
+type attr struct {
+	key string
+	v   any // string, int64, bool or float64
+}
+
+type spanCtxKey struct{}
+
+// ContextWithSpan returns a copy of ctx that carries s.
+// It can be used to pass s to a context that is not derived from the
+// span's context.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanCtxKey{}, s)
+}
+
+// SpanFromContext returns the span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanCtxKey{}).(*Span)
+	return s
+}
+
+// Start starts a child span of the span in ctx. If ctx has no span,
+// it returns ctx and a nil span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	p := SpanFromContext(ctx)
+	if p == nil {
+		return ctx, nil
+	}
+	s := p.t.newSpan(p.traceID, p.id, name, KindInternal)
+	return ContextWithSpan(ctx, s), s
+}
+
+// TraceID returns the trace id in hex. It returns an empty string if s is nil.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// SetKind sets the kind of s. Default is KindInternal for child spans.
+func (s *Span) SetKind(k Kind) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	s.kind = k
+	s.m.Unlock()
+}
+
+// SetAttr sets an attribute. v can be a string, bool, float, integer or
+// fmt.Stringer. Other types are formatted by fmt.Sprint.
+func (s *Span) SetAttr(key string, v any) {
+	if s == nil {
+		return
+	}
+	switch x := v.(type) {
+	case string, bool, int64, float64:
+	case int:
+		v = int64(x)
+	case uint16:
+		v = int64(x)
+	case uint32:
+		v = int64(x)
+	case float32:
+		v = float64(x)
+	case fmt.Stringer:
+		v = x.String()
+	default:
+		v = fmt.Sprint(x)
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].v = v
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attr{key: key, v: v})
+}
+
+// SetError marks s as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.m.Lock()
+	s.err = err.Error()
+	s.m.Unlock()
+}
+
+// End ends s and queues it for export. Calls after the first one are noops.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	if !s.end.IsZero() {
+		s.m.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.m.Unlock()
+	s.t.e.add(s)
+}
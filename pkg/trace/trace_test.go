@@ -0,0 +1,197 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"disabled", Config{}, false},
+		{"file", Config{SampleRate: 1, File: "t.json"}, false},
+		{"endpoint", Config{SampleRate: 0.1, Endpoint: "http://127.0.0.1:4318/v1/traces"}, false},
+		{"no output", Config{SampleRate: 1}, true},
+		{"invalid rate", Config{SampleRate: 2, File: "t.json"}, true},
+		{"invalid scheme", Config{SampleRate: 1, Endpoint: "grpc://127.0.0.1:4317"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	tracer, err := NewTracer(Config{}, nil)
+	if err != nil || tracer != nil {
+		t.Fatalf("NewTracer() = %v, %v, want nil tracer", tracer, err)
+	}
+	ctx, s := tracer.Start(context.Background(), "q", KindServer)
+	if s != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("nil tracer should not start spans")
+	}
+	_, c := Start(ctx, "child")
+	c.SetAttr("k", 1)
+	c.SetError(errors.New("err"))
+	c.End()
+	if c.TraceID() != "" {
+		t.Fatal("nil span should have an empty trace id")
+	}
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRequests(t *testing.T, r io.Reader) []otlpRequest {
+	t.Helper()
+	var rs []otlpRequest
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var req otlpRequest
+		if err := json.Unmarshal(s.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+		rs = append(rs, req)
+	}
+	return rs
+}
+
+func TestTracer_file(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "trace.json")
+	tracer, err := NewTracer(Config{SampleRate: 1, File: f, ServiceName: "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, root := tracer.Start(context.Background(), "dns.query", KindServer)
+	root.SetAttr("dns.qname", "example.com.")
+	_, child := Start(ctx, "exec forward")
+	child.SetAttr("conn.reused", true)
+	child.SetAttr("retry", 1)
+	child.SetError(errors.New("timeout"))
+	child.End()
+	root.End()
+	root.End() // noop
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	rs := readRequests(t, b)
+	if len(rs) != 1 {
+		t.Fatalf("want 1 export request, got %d", len(rs))
+	}
+	rsp := rs[0].ResourceSpans[0]
+	if v := rsp.Resource.Attributes[0].Value.StringValue; v == nil || *v != "test" {
+		t.Fatalf("unexpected service name %v", v)
+	}
+	spans := rsp.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("want 2 spans, got %d", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if r.Name != "dns.query" || r.Kind != KindServer || len(r.ParentSpanID) != 0 || r.Status != nil {
+		t.Fatalf("unexpected root span %+v", r)
+	}
+	if r.TraceID != root.TraceID() || len(r.TraceID) != 32 || len(r.SpanID) != 16 {
+		t.Fatalf("unexpected root ids %+v", r)
+	}
+	if c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID || c.Kind != KindInternal {
+		t.Fatalf("child span is not a child of root: %+v", c)
+	}
+	if c.Status == nil || c.Status.Code != 2 || c.Status.Message != "timeout" {
+		t.Fatalf("unexpected child status %+v", c.Status)
+	}
+	if len(c.Attributes) != 2 || *c.Attributes[0].Value.BoolValue != true || *c.Attributes[1].Value.IntValue != "1" {
+		t.Fatalf("unexpected child attributes %+v", c.Attributes)
+	}
+}
+
+func TestTracer_endpoint(t *testing.T) {
+	reqs := make(chan otlpRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer x" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		for _, req := range readRequests(t, r.Body) {
+			reqs <- req
+		}
+	}))
+	defer srv.Close()
+
+	tracer, err := NewTracer(Config{SampleRate: 1, Endpoint: srv.URL, Headers: map[string]string{"Authorization": "Bearer x"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, s := tracer.Start(context.Background(), "dns.query", KindServer)
+	s.End()
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case req := <-reqs:
+		if n := len(req.ResourceSpans[0].ScopeSpans[0].Spans); n != 1 {
+			t.Fatalf("want 1 span, got %d", n)
+		}
+	default:
+		t.Fatal("no spans were exported")
+	}
+}
+
+func TestTracer_sampling(t *testing.T) {
+	tracer, err := NewTracer(Config{SampleRate: 0.25, File: filepath.Join(t.TempDir(), "trace.json")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close()
+
+	const n = 10000
+	sampled := 0
+	for i := 0; i < n; i++ {
+		if _, s := tracer.Start(context.Background(), "q", KindServer); s != nil {
+			sampled++
+		}
+	}
+	if sampled < n/5 || sampled > n*3/10 {
+		t.Fatalf("sampled %d of %d queries, want about 25%%", sampled, n)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
 	"go.uber.org/zap"
 )
 
@@ -78,7 +79,10 @@ func (t *PipelineTransport) ExchangeContext(ctx context.Context, m []byte) (*[]b
 		if err != nil {
 			return nil, err
 		}
-		r, err := dc.ExchangeReserved(ctx, m)
+		spanCtx, span := startExchangeSpan(ctx, !isNewConn, retry)
+		r, err := dc.ExchangeReserved(spanCtx, m)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			// Reused connection may not stable.
 			// Try to re-send this query if it failed on a reused connection.
@@ -149,3 +153,14 @@ func (t *PipelineTransport) getReservedExchanger() (_ ReservedExchanger, isNewCo
 	}
 	return rxc, isNewConn, err
 }
+
+// startExchangeSpan starts a span for one attempt to exchange a query
+// on a connection.
+func startExchangeSpan(ctx context.Context, reused bool, retry int) (context.Context, *trace.Span) {
+	ctx, span := trace.Start(ctx, "conn.exchange")
+	span.SetAttr("conn.reused", reused)
+	if retry > 0 {
+		span.SetAttr("retry", retry)
+	}
+	return ctx, span
+}
@@ -108,7 +108,10 @@ func (t *ReuseConnTransport) ExchangeContext(ctx context.Context, m []byte) (*[]
 			return nil, err
 		}
 
-		resp, err := c.exchange(ctx, queryPayload)
+		spanCtx, span := startExchangeSpan(ctx, !isNewConn, retry)
+		resp, err := c.exchange(spanCtx, queryPayload)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			if !isNewConn && retry <= maxRetry {
 				retry++
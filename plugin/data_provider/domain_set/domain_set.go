@@ -48,6 +48,11 @@ type Args struct {
 	Files []string `yaml:"files"`
 }
 
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	return a.Sets
+}
+
 var _ data_provider.DomainMatcherProvider = (*DomainSet)(nil)
 
 type DomainSet struct {
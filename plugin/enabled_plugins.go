@@ -45,6 +45,7 @@ import (
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/black_hole"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/cache"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/debug_print"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dnstap"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/drop_resp"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/dual_selector"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ecs_handler"
@@ -54,13 +55,19 @@ import (
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ipset"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/metrics_collector"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/nftset"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/query_history"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/query_log"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/query_summary"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/rate_limiter"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/redirect"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/reverse_lookup"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence/fallback"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence/parallel"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence/retry"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence/timeout"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/sleep"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/stats"
 	_ "github.com/IrineSistiana/mosdns/v5/plugin/executable/ttl"
 
 	// executable and matcher
@@ -32,6 +32,7 @@ import (
 const PluginType = "black_hole"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "black_hole ip...")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
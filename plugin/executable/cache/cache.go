@@ -53,6 +53,7 @@ const (
 
 func init() {
 	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.RegQuickSetupFormat(PluginType, "cache [size]")
 	sequence.MustRegExecQuickSetup(PluginType, quickSetupCache)
 }
 
@@ -94,9 +95,20 @@ type Cache struct {
 	hitTotal     prometheus.Counter
 	lazyHitTotal prometheus.Counter
 	size         prometheus.GaugeFunc
+
+	dumpStatus struct {
+		sync.Mutex
+		loaded   int   // Entries loaded from DumpFile.
+		loadErr  error // Error of loading DumpFile.
+		dumpErr  error // Error of the last dump.
+		lastDump time.Time
+	}
 }
 
 func Init(bp *coremain.BP, args any) (any, error) {
+	if bp.DryRun() { // Don't touch the dump file.
+		args.(*Args).DumpFile = ""
+	}
 	c := NewCache(args.(*Args), Opts{
 		Logger:     bp.L(),
 		MetricsTag: bp.Tag(),
@@ -187,6 +199,9 @@ func (c *Cache) RegMetricsTo(r prometheus.Registerer) error {
 }
 
 func (c *Cache) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	if sequence.IsExplaining(ctx) {
+		return c.execExplain(ctx, qCtx, next)
+	}
 	c.queryTotal.Inc()
 	q := qCtx.Q()
 
@@ -215,6 +230,19 @@ func (c *Cache) Exec(ctx context.Context, qCtx *query_context.Context, next sequ
 	return err
 }
 
+// execExplain runs an explain query. It may use the cached response, but
+// it doesn't store the response, start a lazy update or count the query.
+func (c *Cache) execExplain(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	q := qCtx.Q()
+	if msgKey := getMsgKey(q); len(msgKey) > 0 {
+		if cachedResp, _ := getRespFromCache(msgKey, c.backend, c.args.LazyCacheTTL > 0, expiredMsgTtl); cachedResp != nil {
+			cachedResp.Id = q.Id
+			qCtx.SetResponse(cachedResp)
+		}
+	}
+	return next.ExecNext(ctx, qCtx)
+}
+
 // doLazyUpdate starts a new goroutine to execute next node and update the cache in the background.
 // It has an inner singleflight.Group to de-duplicate same msgKey.
 func (c *Cache) doLazyUpdate(msgKey string, qCtx *query_context.Context, next sequence.ChainWalker) {
@@ -257,12 +285,14 @@ func (c *Cache) loadDump() error {
 	if len(c.args.DumpFile) == 0 {
 		return nil
 	}
-	f, err := os.Open(c.args.DumpFile)
-	if err != nil {
-		return err
+	en, err := c.readDumpFile()
+	c.dumpStatus.Lock()
+	c.dumpStatus.loaded, c.dumpStatus.loadErr = en, err
+	if errors.Is(err, os.ErrNotExist) { // No dump yet. This is fine.
+		c.dumpStatus.loadErr = nil
+		err = nil
 	}
-	defer f.Close()
-	en, err := c.readDump(f)
+	c.dumpStatus.Unlock()
 	if err != nil {
 		return err
 	}
@@ -270,6 +300,15 @@ func (c *Cache) loadDump() error {
 	return nil
 }
 
+func (c *Cache) readDumpFile() (int, error) {
+	f, err := os.Open(c.args.DumpFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return c.readDump(f)
+}
+
 // startDumpLoop starts a dump loop in another goroutine. It does not block.
 func (c *Cache) startDumpLoop() {
 	if len(c.args.DumpFile) == 0 {
@@ -303,23 +342,62 @@ func (c *Cache) dumpCache() error {
 		return nil
 	}
 
-	f, err := os.Create(c.args.DumpFile)
+	en, err := c.writeDumpFile()
+	c.dumpStatus.Lock()
+	c.dumpStatus.dumpErr = err
+	c.dumpStatus.lastDump = time.Now()
+	c.dumpStatus.Unlock()
 	if err != nil {
 		return err
 	}
+	c.logger.Info("cache dumped", zap.Int("entries", en))
+	return nil
+}
+
+func (c *Cache) writeDumpFile() (int, error) {
+	f, err := os.Create(c.args.DumpFile)
+	if err != nil {
+		return 0, err
+	}
 	defer f.Close()
 
 	en, err := c.writeDump(f)
 	if err != nil {
-		return fmt.Errorf("failed to write dump, %w", err)
+		return en, fmt.Errorf("failed to write dump, %w", err)
 	}
-	c.logger.Info("cache dumped", zap.Int("entries", en))
-	return nil
+	return en, nil
+}
+
+// Status implements coremain.StatusReporter. It is degraded if
+// the dump file could not be loaded or the last dump failed.
+func (c *Cache) Status() coremain.PluginStatus {
+	details := map[string]any{"size": c.backend.Len()}
+	status := coremain.StatusHealthy
+	if len(c.args.DumpFile) > 0 {
+		c.dumpStatus.Lock()
+		details["dump_file"] = c.args.DumpFile
+		details["dump_loaded_entries"] = c.dumpStatus.loaded
+		if err := c.dumpStatus.loadErr; err != nil {
+			status = coremain.StatusDegraded
+			details["dump_load_error"] = err.Error()
+		}
+		if !c.dumpStatus.lastDump.IsZero() {
+			details["last_dump"] = c.dumpStatus.lastDump
+		}
+		if err := c.dumpStatus.dumpErr; err != nil {
+			status = coremain.StatusDegraded
+			details["dump_error"] = err.Error()
+		}
+		c.dumpStatus.Unlock()
+	}
+	return coremain.PluginStatus{Status: status, Details: details}
 }
 
 func (c *Cache) Api() *chi.Mux {
 	r := chi.NewRouter()
-	r.Get("/flush", func(w http.ResponseWriter, req *http.Request) {
+	// Flushing changes the cache, so it is POST only and requires the
+	// admin role of the api.
+	r.Post("/flush", func(w http.ResponseWriter, req *http.Request) {
 		c.backend.Flush()
 	})
 	r.Get("/dump", func(w http.ResponseWriter, req *http.Request) {
@@ -21,8 +21,15 @@ package cache
 
 import (
 	"bytes"
+	"context"
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -60,3 +67,92 @@ func Test_cachePlugin_Dump(t *testing.T) {
 		t.Fatalf("read err, wrote %d entries, read %d", enw, enr)
 	}
 }
+
+func Test_cachePlugin_Status(t *testing.T) {
+	dir := t.TempDir()
+	dumpFile := filepath.Join(dir, "dump")
+
+	// Missing dump file is fine.
+	c := NewCache(&Args{DumpFile: dumpFile}, Opts{})
+	if s := c.Status(); s.Status != coremain.StatusHealthy {
+		t.Fatalf("want healthy, got %+v", s)
+	}
+	_ = c.Close()
+
+	// Invalid dump file.
+	if err := os.WriteFile(dumpFile, []byte("invalid dump"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = NewCache(&Args{DumpFile: dumpFile}, Opts{})
+	defer c.Close()
+	s := c.Status()
+	if s.Status != coremain.StatusDegraded || s.Details.(map[string]any)["dump_load_error"] == nil {
+		t.Fatalf("want degraded with load error, got %+v", s)
+	}
+}
+
+func Test_cachePlugin_Explain(t *testing.T) {
+	c := NewCache(&Args{Size: 1024}, Opts{})
+	defer c.Close()
+	ps := map[string]any{
+		"cache": c,
+		"upstream": sequence.ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+			if qCtx.R() != nil { // Cache hit.
+				return nil
+			}
+			r := sequence.StubResponse(ctx, qCtx.Q())
+			if r == nil {
+				r = new(dns.Msg)
+				r.SetReply(qCtx.Q())
+				rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.2")
+				r.Answer = append(r.Answer, rr)
+			}
+			qCtx.SetResponse(r)
+			return nil
+		}),
+	}
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	s, err := sequence.NewSequence(coremain.NewBP("main", m), []sequence.RuleArgs{
+		{Exec: "$cache"},
+		{Exec: "$upstream"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	explain := func() *sequence.ExplainResult {
+		t.Helper()
+		res, err := s.Explain(context.Background(), &sequence.ExplainArgs{
+			Qname: "example.com",
+			Stub:  &sequence.ExplainStub{Answers: []string{"example.com. 300 IN A 192.0.2.1"}},
+		})
+		if err != nil || res.Error != "" || res.Response == nil {
+			t.Fatalf("explain failed, %v %+v", err, res)
+		}
+		return res
+	}
+
+	// The stub response is not stored.
+	if res := explain(); !strings.Contains(res.Response.Answers[0], "192.0.2.1") {
+		t.Fatalf("want the stub response, got %v", res.Response.Answers)
+	}
+	if n := c.backend.Len(); n != 0 {
+		t.Fatalf("explain should not change the cache, got %d entries", n)
+	}
+
+	// A cached response is used by explain, and is not replaced.
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	if err := s.Exec(context.Background(), query_context.NewContext(q)); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.backend.Len(); n != 1 {
+		t.Fatalf("want 1 cache entry, got %d", n)
+	}
+	if res := explain(); !strings.Contains(res.Response.Answers[0], "192.0.2.2") {
+		t.Fatalf("want the cached response, got %v", res.Response.Answers)
+	}
+	v, _, _ := c.backend.Get(key(getMsgKey(q)))
+	if v == nil || !strings.Contains(v.resp.Answer[0].String(), "192.0.2.2") {
+		t.Fatal("cached response should not be changed")
+	}
+}
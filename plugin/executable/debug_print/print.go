@@ -29,6 +29,7 @@ import (
 const PluginType = "debug_print"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "debug_print [msg]")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
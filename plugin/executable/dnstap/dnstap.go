@@ -0,0 +1,265 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const PluginType = "dnstap"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Network is "unix", "tcp" or "file". Default is "unix".
+	Network string `yaml:"network"`
+	// Addr is the socket path, the tcp address or the file path. Required.
+	// Frames are appended to the file. A new stream is started each time
+	// the file is opened.
+	Addr string `yaml:"addr"`
+	// Identity of this server. Default is the hostname.
+	Identity string `yaml:"identity"`
+	// Version of this server. Default is "mosdns".
+	Version string `yaml:"version"`
+	// LogForwarder also logs FORWARDER_QUERY and FORWARDER_RESPONSE if the
+	// response was from an upstream.
+	LogForwarder bool `yaml:"log_forwarder"`
+	// BufferSize is the maximum number of messages waiting to be sent.
+	// Messages are dropped if the buffer is full. Default is 1024.
+	BufferSize int `yaml:"buffer_size"`
+}
+
+func (a *Args) init() error {
+	switch a.Network {
+	case "":
+		a.Network = "unix"
+	case "unix", "tcp", "file":
+	default:
+		return fmt.Errorf("invalid network %s", a.Network)
+	}
+	if len(a.Addr) == 0 {
+		return errors.New("missing addr")
+	}
+	if len(a.Identity) == 0 {
+		a.Identity, _ = os.Hostname()
+	}
+	if len(a.Version) == 0 {
+		a.Version = "mosdns"
+	}
+	utils.SetDefaultUnsignNum(&a.BufferSize, 1024)
+	return nil
+}
+
+var _ sequence.RecursiveExecutable = (*Dnstap)(nil)
+
+type Dnstap struct {
+	identity     []byte
+	version      []byte
+	logForwarder bool
+	out          *output
+
+	sentTotal    prometheus.CounterFunc
+	droppedTotal prometheus.CounterFunc
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	if err := a.init(); err != nil {
+		return nil, err
+	}
+	if bp.DryRun() { // Don't connect.
+		return &Dnstap{}, nil
+	}
+	// Keep the output of the previous plugin, so the old and the new
+	// plugin don't write the same file or socket at the same time.
+	var d *Dnstap
+	if prev, _ := bp.PrevPlugin().(*Dnstap); prev != nil && prev.out != nil && prev.out.sameDst(a) && prev.out.ref() {
+		d = newDnstap(a, prev.out)
+	} else {
+		d = NewDnstap(a, bp.L())
+	}
+	r := prometheus.WrapRegistererWith(prometheus.Labels{"tag": bp.Tag()}, prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg()))
+	for _, c := range [...]prometheus.Collector{d.sentTotal, d.droppedTotal} {
+		if err := r.Register(c); err != nil {
+			_ = d.Close()
+			return nil, fmt.Errorf("failed to register metrics, %w", err)
+		}
+	}
+	return d, nil
+}
+
+// NewDnstap returns a Dnstap that sends messages to the output of args.
+// The output is opened in background. args must be initialized.
+func NewDnstap(args *Args, logger *zap.Logger) *Dnstap {
+	return newDnstap(args, newOutput(args.Network, args.Addr, args.BufferSize, logger))
+}
+
+func newDnstap(args *Args, out *output) *Dnstap {
+	d := &Dnstap{
+		identity:     []byte(args.Identity),
+		version:      []byte(args.Version),
+		logForwarder: args.LogForwarder,
+		out:          out,
+	}
+	d.sentTotal = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "sent_total",
+		Help: "The total number of dnstap messages sent",
+	}, func() float64 { return float64(d.out.written.Load()) })
+	d.droppedTotal = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "dropped_total",
+		Help: "The total number of dnstap messages dropped",
+	}, func() float64 { return float64(d.out.dropped.Load()) })
+	return d
+}
+
+func (d *Dnstap) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	if sequence.IsExplaining(ctx) {
+		return next.ExecNext(ctx, qCtx)
+	}
+	meta := qCtx.ServerMeta
+	clientAddr := netip.AddrPortFrom(meta.ClientAddr, meta.ClientPort)
+	queryTime := qCtx.StartTime()
+	d.send(&message{
+		typ:          clientQuery,
+		protocol:     meta.Protocol,
+		queryAddr:    clientAddr,
+		responseAddr: meta.ServerAddr,
+		queryTime:    queryTime,
+	}, clientQueryMsg(qCtx))
+
+	// The query may be modified by next plugins.
+	var fq *dns.Msg
+	if d.logForwarder {
+		fq = qCtx.Q().Copy()
+	}
+
+	err := next.ExecNext(ctx, qCtx)
+	now := time.Now()
+
+	if d.logForwarder && len(qCtx.Upstream()) > 0 && qCtx.R() != nil {
+		// Upstreams are not ip addresses. Addresses are omitted.
+		d.send(&message{typ: forwarderQuery, queryTime: queryTime}, fq)
+		r := qCtx.R().Copy()
+		if opt := qCtx.UpstreamOpt(); opt != nil {
+			r.Extra = append(r.Extra, opt)
+		}
+		d.send(&message{typ: forwarderResponse, queryTime: queryTime, responseTime: now}, r)
+	}
+
+	d.send(&message{
+		typ:          clientResponse,
+		protocol:     meta.Protocol,
+		queryAddr:    clientAddr,
+		responseAddr: meta.ServerAddr,
+		queryTime:    queryTime,
+		responseTime: now,
+	}, clientResponseMsg(qCtx, err))
+	return err
+}
+
+func (d *Dnstap) send(m *message, msg *dns.Msg) {
+	b, err := msg.Pack()
+	if err != nil {
+		return
+	}
+	m.msg = b
+	d.out.write(appendDnstap(nil, d.identity, d.version, m))
+}
+
+// clientQueryMsg returns the query with the OPT from the client.
+func clientQueryMsg(qCtx *query_context.Context) *dns.Msg {
+	q := qCtx.Q().Copy()
+	extra := q.Extra[:0]
+	for _, rr := range q.Extra {
+		if _, ok := rr.(*dns.OPT); !ok {
+			extra = append(extra, rr)
+		}
+	}
+	if opt := qCtx.ClientOpt(); opt != nil {
+		extra = append(extra, opt)
+	}
+	q.Extra = extra
+	return q
+}
+
+// clientResponseMsg returns the response that the server will send.
+// See server_handler.EntryHandler.
+func clientResponseMsg(qCtx *query_context.Context, err error) *dns.Msg {
+	var r *dns.Msg
+	switch {
+	case err != nil:
+		r = new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		r.Rcode = dns.RcodeServerFailure
+	case qCtx.R() != nil:
+		r = qCtx.R().Copy()
+	default:
+		r = new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		r.Rcode = dns.RcodeRefused
+	}
+	r.RecursionAvailable = true
+	if opt := qCtx.RespOpt(); opt != nil {
+		r.Extra = append(r.Extra, opt)
+	}
+	return r
+}
+
+// Status implements coremain.StatusReporter. It is degraded if the output
+// is disconnected.
+func (d *Dnstap) Status() coremain.PluginStatus {
+	if d.out == nil { // Dry run.
+		return coremain.PluginStatus{Status: coremain.StatusHealthy}
+	}
+	s := coremain.PluginStatus{Status: coremain.StatusHealthy}
+	details := map[string]any{
+		"sent":    d.out.written.Load(),
+		"dropped": d.out.dropped.Load(),
+	}
+	if err := d.out.lastErr(); err != nil {
+		s.Status = coremain.StatusDegraded
+		details["error"] = err.Error()
+	}
+	s.Details = details
+	return s
+}
+
+// Close sends buffered messages and closes the output.
+func (d *Dnstap) Close() error {
+	if d.out != nil {
+		d.out.close()
+	}
+	return nil
+}
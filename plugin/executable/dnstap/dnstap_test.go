@@ -0,0 +1,296 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// readStream acts as a dnstap collector. It returns data frames.
+func readStream(t *testing.T, c net.Conn) [][]byte {
+	t.Helper()
+	expectControl := func(want uint32) {
+		typ, cts, err := readControlFrame(c)
+		if err != nil || typ != want || len(cts) != 1 || cts[0] != contentType {
+			t.Errorf("want control frame %d, got %d %v %v", want, typ, cts, err)
+		}
+	}
+	expectControl(fstrmControlReady)
+	if err := writeControlFrame(c, fstrmControlAccept, true); err != nil {
+		t.Error(err)
+	}
+	expectControl(fstrmControlStart)
+
+	var frames [][]byte
+	for {
+		var h [4]byte
+		if _, err := io.ReadFull(c, h[:]); err != nil {
+			t.Error(err)
+			return frames
+		}
+		l := binary.BigEndian.Uint32(h[:])
+		if l == 0 { // Control frame, must be STOP.
+			if _, err := io.ReadFull(c, h[:]); err != nil {
+				t.Error(err)
+				return frames
+			}
+			b := make([]byte, binary.BigEndian.Uint32(h[:]))
+			_, _ = io.ReadFull(c, b)
+			if typ := binary.BigEndian.Uint32(b); typ != fstrmControlStop {
+				t.Errorf("want STOP, got %d", typ)
+			}
+			_ = writeControlFrame(c, fstrmControlFinish, false)
+			return frames
+		}
+		b := make([]byte, l)
+		if _, err := io.ReadFull(c, b); err != nil {
+			t.Error(err)
+			return frames
+		}
+		frames = append(frames, b)
+	}
+}
+
+// fields returns the first varint/bytes value of each field number in b.
+func fields(t *testing.T, b []byte) map[protowire.Number]any {
+	t.Helper()
+	m := make(map[protowire.Number]any)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v any
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed32Type:
+			v, n = protowire.ConsumeFixed32(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		if _, ok := m[num]; !ok {
+			m[num] = v
+		}
+	}
+	return m
+}
+
+func Test_Dnstap(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "dnstap.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	framesC := make(chan [][]byte, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			framesC <- nil
+			return
+		}
+		defer c.Close()
+		framesC <- readStream(t, c)
+	}()
+
+	args := &Args{Addr: sock, Identity: "test", LogForwarder: true}
+	if err := args.init(); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDnstap(args, zap.NewNop())
+
+	var upstream sequence.ExecutableFunc = func(_ context.Context, qCtx *query_context.Context) error {
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		qCtx.SetResponse(r)
+		qCtx.SetUpstream("u1")
+		return nil
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	qCtx := query_context.NewContext(q)
+	qCtx.ServerMeta = server.QueryMeta{
+		ClientAddr: netip.MustParseAddr("192.0.2.1"),
+		ClientPort: 5353,
+		ServerAddr: netip.MustParseAddrPort("127.0.0.1:53"),
+		Protocol:   server.ProtocolUDP,
+	}
+	if err := d.Exec(context.Background(), qCtx, sequence.NewChainWalker([]*sequence.ChainNode{{E: upstream}}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.Close()
+
+	frames := <-framesC
+	if len(frames) != 4 {
+		t.Fatalf("want 4 frames, got %d", len(frames))
+	}
+	var types []uint64
+	for _, f := range frames {
+		fs := fields(t, f)
+		if string(fs[dnstapIdentity].([]byte)) != "test" {
+			t.Fatalf("unexpected identity %v", fs[dnstapIdentity])
+		}
+		types = append(types, fields(t, fs[dnstapMessage].([]byte))[msgType].(uint64))
+	}
+	want := []uint64{uint64(clientQuery), uint64(forwarderQuery), uint64(forwarderResponse), uint64(clientResponse)}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("message types = %v, want %v", types, want)
+		}
+	}
+
+	m := fields(t, fields(t, frames[0])[dnstapMessage].([]byte))
+	if m[msgSocketFamily] != uint64(socketFamilyInet) || m[msgSocketProtocol] != uint64(socketProtocolUDP) ||
+		netip.AddrFrom4([4]byte(m[msgQueryAddress].([]byte))) != netip.MustParseAddr("192.0.2.1") || m[msgQueryPort] != uint64(5353) ||
+		m[msgResponsePort] != uint64(53) {
+		t.Fatalf("unexpected client query message %v", m)
+	}
+	qm := new(dns.Msg)
+	if err := qm.Unpack(m[msgQueryMessage].([]byte)); err != nil || qm.Question[0].Name != "example.com." {
+		t.Fatalf("invalid query message, %v", err)
+	}
+}
+
+func Test_output_reconnect(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "dnstap.sock")
+	o := newOutput("unix", sock, 16, zap.NewNop())
+	defer o.close()
+
+	// No collector. Messages are dropped.
+	o.write([]byte("a"))
+	for o.dropped.Load() == 0 {
+		runtime.Gosched()
+	}
+	if o.lastErr() == nil {
+		t.Fatal("output should be disconnected")
+	}
+
+	// The collector is up. The output reconnects after the retry interval.
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		readStream(t, c)
+	}()
+	deadline := time.Now().Add(time.Second * 5)
+	for o.written.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("output did not reconnect")
+		}
+		o.write([]byte("a"))
+		time.Sleep(time.Millisecond * 50)
+	}
+	if err := o.lastErr(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_output_file(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	o1 := newOutput("file", p, 16, zap.NewNop())
+	o1.write([]byte("a"))
+	o1.close()
+
+	// A reload reuses the output. Closing the old user keeps it open.
+	o2 := newOutput("file", p, 16, zap.NewNop())
+	o2.write([]byte("b"))
+	if !o2.ref() {
+		t.Fatal("ref failed")
+	}
+	o2.close()
+	o2.write([]byte("c"))
+	o2.close()
+	if o2.ref() {
+		t.Fatal("ref of a closed output should fail")
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	r := bytes.NewReader(b)
+	for r.Len() > 0 {
+		var h [4]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			t.Fatal(err)
+		}
+		l := binary.BigEndian.Uint32(h[:])
+		if l == 0 { // Control frame.
+			if _, err := io.ReadFull(r, h[:]); err != nil {
+				t.Fatal(err)
+			}
+			f := make([]byte, binary.BigEndian.Uint32(h[:]))
+			if _, err := io.ReadFull(r, f); err != nil {
+				t.Fatal(err)
+			}
+			switch binary.BigEndian.Uint32(f) {
+			case fstrmControlStart:
+				got = append(got, "START")
+			case fstrmControlStop:
+				got = append(got, "STOP")
+			}
+			continue
+		}
+		f := make([]byte, l)
+		if _, err := io.ReadFull(r, f); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(f))
+	}
+	want := []string{"START", "a", "STOP", "START", "b", "c", "STOP"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("want frames %v, got %v", want, got)
+	}
+}
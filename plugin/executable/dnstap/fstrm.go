@@ -0,0 +1,363 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Frame Streams protocol.
+// See https://farsightsec.github.io/fstrm/
+const (
+	fstrmControlAccept = 0x01
+	fstrmControlStart  = 0x02
+	fstrmControlStop   = 0x03
+	fstrmControlReady  = 0x04
+	fstrmControlFinish = 0x05
+
+	fstrmFieldContentType = 0x01
+
+	fstrmMaxControlFrameSize = 512
+
+	contentType = "protobuf:dnstap.Dnstap"
+)
+
+func writeControlFrame(w io.Writer, typ uint32, withContentType bool) error {
+	b := make([]byte, 0, 12+8+len(contentType))
+	b = binary.BigEndian.AppendUint32(b, 0) // Escape.
+	l := 4
+	if withContentType {
+		l += 8 + len(contentType)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(l))
+	b = binary.BigEndian.AppendUint32(b, typ)
+	if withContentType {
+		b = binary.BigEndian.AppendUint32(b, fstrmFieldContentType)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(contentType)))
+		b = append(b, contentType...)
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readControlFrame reads a control frame and returns its type and the
+// content types in it.
+func readControlFrame(r io.Reader) (uint32, []string, error) {
+	h := make([]byte, 8)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return 0, nil, err
+	}
+	if binary.BigEndian.Uint32(h) != 0 {
+		return 0, nil, errors.New("not a control frame")
+	}
+	l := binary.BigEndian.Uint32(h[4:])
+	if l < 4 || l > fstrmMaxControlFrameSize {
+		return 0, nil, fmt.Errorf("invalid control frame length %d", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	typ := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	var cts []string
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return 0, nil, errors.New("invalid control frame field")
+		}
+		ft, fl := binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:])
+		b = b[8:]
+		if uint32(len(b)) < fl {
+			return 0, nil, errors.New("invalid control frame field length")
+		}
+		if ft == fstrmFieldContentType {
+			cts = append(cts, string(b[:fl]))
+		}
+		b = b[fl:]
+	}
+	return typ, cts, nil
+}
+
+const (
+	handshakeTimeout = time.Second * 5
+	writeTimeout     = time.Second * 5
+	finishTimeout    = time.Second
+	maxRetryInterval = time.Second * 30
+)
+
+// fstrmConn is a Frame Streams writer.
+type fstrmConn struct {
+	c  io.WriteCloser
+	nc net.Conn // nil if c is a file, which is unidirectional.
+	bw *bufio.Writer
+}
+
+// openFstrm opens a Frame Streams output. Network can be "unix", "tcp"
+// or "file". Sockets use the bidirectional handshake.
+func openFstrm(network, addr string) (*fstrmConn, error) {
+	if network == "file" {
+		f, err := os.OpenFile(addr, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		fc := &fstrmConn{c: f, bw: bufio.NewWriter(f)}
+		if err := writeControlFrame(fc.bw, fstrmControlStart, true); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return fc, nil
+	}
+
+	nc, err := net.DialTimeout(network, addr, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := handshake(nc); err != nil {
+		_ = nc.Close()
+		return nil, fmt.Errorf("handshake failed, %w", err)
+	}
+	return &fstrmConn{c: nc, nc: nc, bw: bufio.NewWriter(nc)}, nil
+}
+
+func handshake(c net.Conn) error {
+	_ = c.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+	if err := writeControlFrame(c, fstrmControlReady, true); err != nil {
+		return err
+	}
+	typ, cts, err := readControlFrame(c)
+	if err != nil {
+		return err
+	}
+	if typ != fstrmControlAccept {
+		return fmt.Errorf("unexpected control frame type %d", typ)
+	}
+	if len(cts) > 0 && !containsString(cts, contentType) {
+		return fmt.Errorf("content type %s is not accepted", contentType)
+	}
+	return writeControlFrame(c, fstrmControlStart, true)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (fc *fstrmConn) writeFrame(b []byte) error {
+	if fc.nc != nil {
+		_ = fc.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+	var h [4]byte
+	binary.BigEndian.PutUint32(h[:], uint32(len(b)))
+	if _, err := fc.bw.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := fc.bw.Write(b)
+	return err
+}
+
+func (fc *fstrmConn) flush() error {
+	if fc.nc != nil {
+		_ = fc.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+	return fc.bw.Flush()
+}
+
+// finish stops the stream and closes the connection.
+func (fc *fstrmConn) finish() error {
+	err := writeControlFrame(fc.bw, fstrmControlStop, false)
+	if err == nil {
+		err = fc.flush()
+	}
+	if err == nil && fc.nc != nil {
+		_ = fc.nc.SetReadDeadline(time.Now().Add(finishTimeout))
+		var typ uint32
+		typ, _, err = readControlFrame(fc.nc)
+		if err == nil && typ != fstrmControlFinish {
+			err = fmt.Errorf("unexpected control frame type %d", typ)
+		}
+	}
+	if cErr := fc.c.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// output writes frames in a background goroutine. write never blocks.
+// Frames are dropped if the buffer is full or the output is not connected.
+// It reconnects with backoff after errors.
+type output struct {
+	network string
+	addr    string
+	logger  *zap.Logger
+
+	m      sync.RWMutex
+	refs   int // See ref.
+	closed bool
+	ch     chan []byte
+	done   chan struct{}
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+	errM    sync.Mutex
+	err     error // Last connection error, nil if connected.
+}
+
+func newOutput(network, addr string, bufferSize int, logger *zap.Logger) *output {
+	o := &output{
+		network: network,
+		addr:    addr,
+		logger:  logger,
+		refs:    1,
+		ch:      make(chan []byte, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go o.loop()
+	return o
+}
+
+// sameDst reports whether o writes to the output of args.
+func (o *output) sameDst(args *Args) bool {
+	return o.network == args.Network && o.addr == args.Addr && cap(o.ch) == args.BufferSize
+}
+
+func (o *output) write(b []byte) {
+	o.m.RLock()
+	defer o.m.RUnlock()
+	if o.closed {
+		return
+	}
+	select {
+	case o.ch <- b:
+	default:
+		o.dropped.Add(1)
+	}
+}
+
+func (o *output) loop() {
+	defer close(o.done)
+	var (
+		fc        *fstrmConn
+		retryAt   time.Time
+		retryWait time.Duration
+	)
+	for b := range o.ch {
+		if fc == nil {
+			if time.Now().Before(retryAt) {
+				o.dropped.Add(1)
+				continue
+			}
+			var err error
+			fc, err = openFstrm(o.network, o.addr)
+			if err != nil {
+				retryWait = min(max(retryWait*2, time.Second), maxRetryInterval)
+				retryAt = time.Now().Add(retryWait)
+				o.setErr(fmt.Errorf("failed to open dnstap output, %w", err))
+				o.dropped.Add(1)
+				continue
+			}
+			retryWait = 0
+			o.setErr(nil)
+		}
+
+		err := fc.writeFrame(b)
+		if err == nil && len(o.ch) == 0 {
+			err = fc.flush()
+		}
+		if err != nil {
+			_ = fc.c.Close()
+			fc = nil
+			o.setErr(fmt.Errorf("failed to write dnstap output, %w", err))
+			o.dropped.Add(1)
+			continue
+		}
+		o.written.Add(1)
+	}
+	if fc != nil {
+		if err := fc.finish(); err != nil {
+			o.logger.Warn("failed to finish dnstap output", zap.Error(err))
+		}
+	}
+}
+
+// setErr records the connection state and logs changes.
+func (o *output) setErr(err error) {
+	o.errM.Lock()
+	defer o.errM.Unlock()
+	switch {
+	case err != nil && o.err == nil:
+		o.logger.Warn("dnstap output disconnected", zap.Error(err))
+	case err == nil && o.err != nil:
+		o.logger.Info("dnstap output reconnected")
+	}
+	o.err = err
+}
+
+func (o *output) lastErr() error {
+	o.errM.Lock()
+	defer o.errM.Unlock()
+	return o.err
+}
+
+// ref adds a user of o, so o can be shared by the old and the new plugin
+// during a reload. Each user calls close once. It returns false if o is
+// closed.
+func (o *output) ref() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.closed {
+		return false
+	}
+	o.refs++
+	return true
+}
+
+// close writes buffered frames, stops the stream and closes the output
+// when the last user of o calls it.
+func (o *output) close() {
+	o.m.Lock()
+	if o.closed {
+		o.m.Unlock()
+		return
+	}
+	if o.refs--; o.refs > 0 {
+		o.m.Unlock()
+		return
+	}
+	o.closed = true
+	close(o.ch)
+	o.m.Unlock()
+	<-o.done
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/server"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers and enums of dnstap.proto.
+// See https://github.com/dnstap/dnstap.pb/blob/master/dnstap.proto
+const (
+	dnstapIdentity = 1
+	dnstapVersion  = 2
+	dnstapMessage  = 14
+	dnstapType     = 15
+
+	dnstapTypeMessage = 1
+
+	msgType             = 1
+	msgSocketFamily     = 2
+	msgSocketProtocol   = 3
+	msgQueryAddress     = 4
+	msgResponseAddress  = 5
+	msgQueryPort        = 6
+	msgResponsePort     = 7
+	msgQueryTimeSec     = 8
+	msgQueryTimeNsec    = 9
+	msgQueryMessage     = 10
+	msgResponseTimeSec  = 12
+	msgResponseTimeNsec = 13
+	msgResponseMessage  = 14
+
+	socketFamilyInet  = 1
+	socketFamilyInet6 = 2
+
+	socketProtocolUDP = 1
+	socketProtocolTCP = 2
+	socketProtocolDOT = 3
+	socketProtocolDOH = 4
+	socketProtocolDOQ = 7
+)
+
+type messageType int
+
+const (
+	clientQuery       messageType = 5
+	clientResponse    messageType = 6
+	forwarderQuery    messageType = 7
+	forwarderResponse messageType = 8
+)
+
+func (t messageType) isQuery() bool {
+	return t%2 == 1
+}
+
+// message is a dnstap Message.
+type message struct {
+	typ          messageType
+	protocol     server.Protocol
+	queryAddr    netip.AddrPort
+	responseAddr netip.AddrPort
+	queryTime    time.Time
+	responseTime time.Time // Response only.
+	msg          []byte    // Packed dns message.
+}
+
+// appendDnstap appends m, wrapped in a Dnstap, to b in protobuf wire format.
+func appendDnstap(b []byte, identity, version []byte, m *message) []byte {
+	if len(identity) > 0 {
+		b = protowire.AppendTag(b, dnstapIdentity, protowire.BytesType)
+		b = protowire.AppendBytes(b, identity)
+	}
+	if len(version) > 0 {
+		b = protowire.AppendTag(b, dnstapVersion, protowire.BytesType)
+		b = protowire.AppendBytes(b, version)
+	}
+	mb := appendMessage(nil, m)
+	b = protowire.AppendTag(b, dnstapMessage, protowire.BytesType)
+	b = protowire.AppendBytes(b, mb)
+	b = protowire.AppendTag(b, dnstapType, protowire.VarintType)
+	b = protowire.AppendVarint(b, dnstapTypeMessage)
+	return b
+}
+
+func appendMessage(b []byte, m *message) []byte {
+	appendVarint := func(num protowire.Number, v uint64) {
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	}
+	appendBytes := func(num protowire.Number, v []byte) {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	appendTime := func(secNum, nsecNum protowire.Number, t time.Time) {
+		appendVarint(secNum, uint64(t.Unix()))
+		b = protowire.AppendTag(b, nsecNum, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, uint32(t.Nanosecond()))
+	}
+
+	appendVarint(msgType, uint64(m.typ))
+	if a := m.queryAddr.Addr(); a.IsValid() {
+		if a.Unmap().Is4() {
+			appendVarint(msgSocketFamily, socketFamilyInet)
+		} else {
+			appendVarint(msgSocketFamily, socketFamilyInet6)
+		}
+	}
+	if p := socketProtocol(m.protocol); p > 0 {
+		appendVarint(msgSocketProtocol, p)
+	}
+	if a := m.queryAddr; a.Addr().IsValid() {
+		appendBytes(msgQueryAddress, a.Addr().Unmap().AsSlice())
+		appendVarint(msgQueryPort, uint64(a.Port()))
+	}
+	if a := m.responseAddr; a.Addr().IsValid() {
+		appendBytes(msgResponseAddress, a.Addr().Unmap().AsSlice())
+		appendVarint(msgResponsePort, uint64(a.Port()))
+	}
+	appendTime(msgQueryTimeSec, msgQueryTimeNsec, m.queryTime)
+	if m.typ.isQuery() {
+		appendBytes(msgQueryMessage, m.msg)
+	} else {
+		appendTime(msgResponseTimeSec, msgResponseTimeNsec, m.responseTime)
+		appendBytes(msgResponseMessage, m.msg)
+	}
+	return b
+}
+
+func socketProtocol(p server.Protocol) uint64 {
+	switch p {
+	case server.ProtocolUDP:
+		return socketProtocolUDP
+	case server.ProtocolTCP:
+		return socketProtocolTCP
+	case server.ProtocolTLS:
+		return socketProtocolDOT
+	case server.ProtocolHTTPS:
+		return socketProtocolDOH
+	case server.ProtocolQUIC:
+		return socketProtocolDOQ
+	default:
+		return 0
+	}
+}
@@ -28,6 +28,7 @@ import (
 const PluginType = "drop_resp"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "drop_resp")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
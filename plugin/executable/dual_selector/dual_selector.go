@@ -44,9 +44,11 @@ const (
 )
 
 func init() {
+	sequence.RegQuickSetupFormat("prefer_ipv4", "prefer_ipv4")
 	sequence.MustRegExecQuickSetup("prefer_ipv4", func(bq sequence.BQ, _ string) (any, error) {
 		return NewPreferIpv4(bq), nil
 	})
+	sequence.RegQuickSetupFormat("prefer_ipv6", "prefer_ipv6")
 	sequence.MustRegExecQuickSetup("prefer_ipv6", func(bq sequence.BQ, _ string) (any, error) {
 		return NewPreferIpv6(bq), nil
 	})
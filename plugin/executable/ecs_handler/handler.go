@@ -40,6 +40,7 @@ func init() {
 
 	// Compatible for old ecs plugin
 	// TODO: Remove this in mosdns v6, probably.
+	sequence.RegQuickSetupFormat("ecs", "ecs [preset_ip]")
 	sequence.MustRegExecQuickSetup("ecs", QuickSetupOldECS)
 }
 
@@ -31,6 +31,7 @@ import (
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
 	"github.com/IrineSistiana/mosdns/v5/pkg/upstream"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
@@ -43,6 +44,7 @@ const PluginType = "forward"
 
 func init() {
 	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.RegQuickSetupFormat(PluginType, "forward upstream_addr...")
 	sequence.MustRegExecQuickSetup(PluginType, quickSetup)
 }
 
@@ -194,11 +196,12 @@ func (f *Forward) RegisterMetricsTo(r prometheus.Registerer) error {
 }
 
 func (f *Forward) Exec(ctx context.Context, qCtx *query_context.Context) (err error) {
-	r, err := f.exchange(ctx, qCtx, f.us)
+	r, u, err := f.exchange(ctx, qCtx, f.us)
 	if err != nil {
 		return err
 	}
 	qCtx.SetResponse(r)
+	qCtx.SetUpstream(u)
 	return nil
 }
 
@@ -217,16 +220,59 @@ func (f *Forward) QuickConfigureExec(args string) (any, error) {
 		}
 	}
 	var execFunc sequence.ExecutableFunc = func(ctx context.Context, qCtx *query_context.Context) error {
-		r, err := f.exchange(ctx, qCtx, us)
+		r, u, err := f.exchange(ctx, qCtx, us)
 		if err != nil {
 			return err
 		}
 		qCtx.SetResponse(r)
+		qCtx.SetUpstream(u)
 		return nil
 	}
 	return execFunc, nil
 }
 
+type upstreamStatus struct {
+	Name      string  `json:"name"`
+	Queries   int     `json:"queries"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// Status implements coremain.StatusReporter. It reports upstream error
+// rates in the last minute. It is degraded if any upstream has an error
+// rate >= 50%, and failed if all upstreams that received queries failed.
+func (f *Forward) Status() coremain.PluginStatus {
+	now := time.Now()
+	status := coremain.StatusHealthy
+	var us []upstreamStatus
+	used, failed := 0, 0
+	for _, u := range f.us {
+		q, e := u.recent.count(now)
+		s := upstreamStatus{Name: u.name(), Queries: q, Errors: e}
+		if q > 0 {
+			used++
+			s.ErrorRate = float64(e) / float64(q)
+			if e == q {
+				failed++
+			}
+			if e*2 >= q {
+				status = coremain.StatusDegraded
+			}
+		}
+		us = append(us, s)
+	}
+	if used > 0 && failed == used {
+		status = coremain.StatusFailed
+	}
+	return coremain.PluginStatus{
+		Status: status,
+		Details: map[string]any{
+			"window":    (errWindowBuckets * errWindowInterval).String(),
+			"upstreams": us,
+		},
+	}
+}
+
 func (f *Forward) Close() error {
 	for _, u := range f.us {
 		_ = u.Close()
@@ -234,14 +280,18 @@ func (f *Forward) Close() error {
 	return nil
 }
 
-func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us []*upstreamWrapper) (*dns.Msg, error) {
+// exchange returns the response and the name of the upstream that sent it.
+func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us []*upstreamWrapper) (*dns.Msg, string, error) {
 	if len(us) == 0 {
-		return nil, errors.New("no upstream to exchange")
+		return nil, "", errors.New("no upstream to exchange")
+	}
+	if r := sequence.StubResponse(ctx, qCtx.Q()); r != nil {
+		return r, "stub", nil
 	}
 
 	queryPayload, err := pool.PackBuffer(qCtx.Q())
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer pool.ReleaseBuf(queryPayload)
 
@@ -255,6 +305,7 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 
 	type res struct {
 		r   *dns.Msg
+		u   string
 		err error
 	}
 
@@ -269,7 +320,8 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 		go func(uqid uint32, question dns.Question) {
 			defer pool.ReleaseBuf(qc)
 			// Give each upstream a fixed timeout to finish the query.
-			upstreamCtx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+			// The span is kept so that the exchange is traced as a part of the query.
+			upstreamCtx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), queryTimeout)
 			defer cancel()
 
 			var r *dns.Msg
@@ -293,7 +345,7 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 				}
 			}
 			select {
-			case resChan <- res{r: r, err: err}:
+			case resChan <- res{r: r, u: u.name(), err: err}:
 			case <-done:
 			}
 		}(qCtx.Id(), qCtx.QQuestion())
@@ -311,12 +363,12 @@ func (f *Forward) exchange(ctx context.Context, qCtx *query_context.Context, us
 			if i < concurrent-1 && r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
 				continue
 			}
-			return r, nil
+			return r, res.u, nil
 		case <-ctx.Done():
-			return nil, context.Cause(ctx)
+			return nil, "", context.Cause(ctx)
 		}
 	}
-	return nil, errors.New("all upstream servers failed")
+	return nil, "", errors.New("all upstream servers failed")
 }
 
 func quickSetup(bq sequence.BQ, s string) (any, error) {
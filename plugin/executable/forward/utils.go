@@ -21,9 +21,12 @@ package fastforward
 
 import (
 	"context"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
 	"github.com/IrineSistiana/mosdns/v5/pkg/upstream"
 	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
@@ -41,6 +44,8 @@ type upstreamWrapper struct {
 
 	connOpened prometheus.Counter
 	connClosed prometheus.Counter
+
+	recent errWindow
 }
 
 func (uw *upstreamWrapper) OnEvent(typ upstream.Event) {
@@ -118,14 +123,34 @@ func (uw *upstreamWrapper) name() string {
 	return uw.cfg.Addr
 }
 
+// protocol returns the protocol of the upstream, which is the scheme of
+// its address. Default is "udp".
+func (uw *upstreamWrapper) protocol() string {
+	if p, _, ok := strings.Cut(uw.cfg.Addr, "://"); ok {
+		return p
+	}
+	return "udp"
+}
+
 func (uw *upstreamWrapper) ExchangeContext(ctx context.Context, m []byte) (*[]byte, error) {
 	uw.queryTotal.Inc()
 
+	ctx, span := trace.Start(ctx, "upstream.exchange")
+	if span != nil {
+		span.SetKind(trace.KindClient)
+		span.SetAttr("upstream.name", uw.name())
+		span.SetAttr("upstream.address", uw.cfg.Addr)
+		span.SetAttr("network.protocol", uw.protocol())
+	}
+
 	start := time.Now()
 	uw.thread.Inc()
 	r, err := uw.u.ExchangeContext(ctx, m)
 	uw.thread.Dec()
+	span.SetError(err)
+	span.End()
 
+	uw.recent.add(time.Now(), err != nil)
 	if err != nil {
 		uw.errTotal.Inc()
 	} else {
@@ -138,6 +163,51 @@ func (uw *upstreamWrapper) Close() error {
 	return uw.u.Close()
 }
 
+const (
+	errWindowBuckets  = 6
+	errWindowInterval = 10 * time.Second
+)
+
+// errWindow counts queries and errors in the last
+// errWindowBuckets * errWindowInterval.
+type errWindow struct {
+	m       sync.Mutex
+	buckets [errWindowBuckets]errBucket
+}
+
+type errBucket struct {
+	n       int64 // Interval number since unix epoch.
+	queries int
+	errs    int
+}
+
+func (w *errWindow) add(now time.Time, failed bool) {
+	n := now.UnixNano() / int64(errWindowInterval)
+	w.m.Lock()
+	defer w.m.Unlock()
+	b := &w.buckets[n%errWindowBuckets]
+	if b.n != n {
+		*b = errBucket{n: n}
+	}
+	b.queries++
+	if failed {
+		b.errs++
+	}
+}
+
+func (w *errWindow) count(now time.Time) (queries, errs int) {
+	n := now.UnixNano() / int64(errWindowInterval)
+	w.m.Lock()
+	defer w.m.Unlock()
+	for _, b := range w.buckets {
+		if n-b.n < errWindowBuckets {
+			queries += b.queries
+			errs += b.errs
+		}
+	}
+	return queries, errs
+}
+
 type queryInfo dns.Msg
 
 func (q *queryInfo) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package fastforward
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_errWindow(t *testing.T) {
+	w := new(errWindow)
+	now := time.Unix(1000, 0)
+	w.add(now, false)
+	w.add(now, true)
+	w.add(now.Add(errWindowInterval), true)
+	if q, e := w.count(now.Add(errWindowInterval)); q != 3 || e != 2 {
+		t.Fatalf("count() = %d, %d, want 3, 2", q, e)
+	}
+
+	// The first bucket expires.
+	later := now.Add(errWindowBuckets * errWindowInterval)
+	if q, e := w.count(later); q != 1 || e != 1 {
+		t.Fatalf("count() = %d, %d, want 1, 1", q, e)
+	}
+	// Reuse the first bucket.
+	w.add(later, false)
+	if q, e := w.count(later); q != 2 || e != 1 {
+		t.Fatalf("count() = %d, %d, want 2, 1", q, e)
+	}
+}
@@ -31,6 +31,7 @@ import (
 const PluginType = "forward_edns0opt"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "forward_edns0opt edns0_option_code...")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
@@ -29,6 +29,7 @@ import (
 const PluginType = "ipset"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "ipset set_name,{inet|inet6},mask...")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
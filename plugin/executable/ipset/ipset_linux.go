@@ -25,6 +25,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/miekg/dns"
 	"github.com/nadoo/ipset"
 	"net/netip"
@@ -54,9 +55,9 @@ func newIpSetPlugin(args *Args) (*ipSetPlugin, error) {
 	}, nil
 }
 
-func (p *ipSetPlugin) Exec(_ context.Context, qCtx *query_context.Context) error {
+func (p *ipSetPlugin) Exec(ctx context.Context, qCtx *query_context.Context) error {
 	r := qCtx.R()
-	if r != nil {
+	if r != nil && !sequence.IsExplaining(ctx) {
 		if err := p.addIPSet(r); err != nil {
 			return fmt.Errorf("ipset: %w", err)
 		}
@@ -31,6 +31,7 @@ import (
 const PluginType = "metrics_collector"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "metrics_collector metrics_name")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
@@ -83,6 +84,9 @@ func NewCollector(r prometheus.Registerer, name string) (*Collector, error) {
 }
 
 func (c *Collector) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	if sequence.IsExplaining(ctx) {
+		return next.ExecNext(ctx, qCtx)
+	}
 	c.thread.Inc()
 	defer c.thread.Dec()
 
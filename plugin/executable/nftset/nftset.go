@@ -29,6 +29,7 @@ import (
 const PluginType = "nftset"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "nftset {ip|ip6|inet},table_name,set_name,{ipv4_addr|ipv6_addr},mask...")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
@@ -29,6 +29,7 @@ import (
 	"github.com/IrineSistiana/mosdns/v5/pkg/nftset_utils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/google/nftables"
 	"github.com/miekg/dns"
 )
@@ -80,9 +81,9 @@ func newNftSetPlugin(args *Args) (*nftSetPlugin, error) {
 	return p, nil
 }
 
-func (p *nftSetPlugin) Exec(_ context.Context, qCtx *query_context.Context) error {
+func (p *nftSetPlugin) Exec(ctx context.Context, qCtx *query_context.Context) error {
 	r := qCtx.R()
-	if r != nil {
+	if r != nil && !sequence.IsExplaining(ctx) {
 		if err := p.addElems(r); err != nil {
 			return fmt.Errorf("nftable: %w", err)
 		}
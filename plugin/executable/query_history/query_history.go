@@ -0,0 +1,404 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "query_history"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	flushInterval      = time.Second
+)
+
+type Args struct {
+	// Dir that stores history segments. Required.
+	Dir string `yaml:"dir"`
+	// MaxSize in megabytes of all segments. Default is 100.
+	MaxSize int `yaml:"max_size"`
+	// MaxAge in seconds of records. Default is 86400 (1 day).
+	MaxAge int `yaml:"max_age"`
+	// BufferSize is the maximum number of records waiting to be written.
+	// Records are dropped if the buffer is full. Default is 1024.
+	BufferSize int `yaml:"buffer_size"`
+}
+
+func (a *Args) init() error {
+	if len(a.Dir) == 0 {
+		return errors.New("missing dir")
+	}
+	utils.SetDefaultUnsignNum(&a.MaxSize, 100)
+	utils.SetDefaultUnsignNum(&a.MaxAge, 86400)
+	utils.SetDefaultUnsignNum(&a.BufferSize, 1024)
+	return nil
+}
+
+func (a *Args) maxSize() int64 {
+	return int64(a.MaxSize) << 20
+}
+
+func (a *Args) maxAge() time.Duration {
+	return time.Duration(a.MaxAge) * time.Second
+}
+
+var _ sequence.RecursiveExecutable = (*QueryHistory)(nil)
+
+type QueryHistory struct {
+	logger *zap.Logger
+	s      *store
+
+	m      sync.RWMutex
+	closed bool
+	ch     chan *record
+	done   chan struct{}
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	if err := a.init(); err != nil {
+		return nil, err
+	}
+	if bp.DryRun() { // Don't touch the dir.
+		return &QueryHistory{}, nil
+	}
+	// Share the store with the previous plugin, so they don't write the
+	// same dir and remove each other's segments.
+	var h *QueryHistory
+	if prev, _ := bp.PrevPlugin().(*QueryHistory); prev != nil && prev.s != nil && prev.s.dir == filepath.Clean(a.Dir) && prev.s.ref(a.maxSize(), a.maxAge()) {
+		h = newQueryHistory(a, bp.L(), prev.s)
+	} else {
+		var err error
+		if h, err = NewQueryHistory(a, bp.L()); err != nil {
+			return nil, err
+		}
+	}
+	bp.RegAPI(h.Api())
+	return h, nil
+}
+
+// NewQueryHistory opens the store in args.Dir. args must be initialized.
+func NewQueryHistory(args *Args, logger *zap.Logger) (*QueryHistory, error) {
+	s, err := openStore(filepath.Clean(args.Dir), args.maxSize(), args.maxAge())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store, %w", err)
+	}
+	return newQueryHistory(args, logger, s), nil
+}
+
+func newQueryHistory(args *Args, logger *zap.Logger, s *store) *QueryHistory {
+	h := &QueryHistory{
+		logger: logger,
+		s:      s,
+		ch:     make(chan *record, args.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go h.writeLoop()
+	return h
+}
+
+// record is a query in the history.
+type record struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client,omitempty"`
+	Qname     string    `json:"qname"`
+	Qtype     string    `json:"qtype"`
+	Rcode     *int      `json:"rcode,omitempty"` // nil if there is no response.
+	Answers   []string  `json:"answers,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	Marks     []uint32  `json:"marks,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (h *QueryHistory) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	err := next.ExecNext(ctx, qCtx)
+	if !sequence.IsExplaining(ctx) {
+		h.add(newRecord(qCtx, err))
+	}
+	return err
+}
+
+func newRecord(qCtx *query_context.Context, err error) *record {
+	q := qCtx.QQuestion()
+	r := &record{
+		Time:      qCtx.StartTime(),
+		Qname:     q.Name,
+		Qtype:     dns.Type(q.Qtype).String(),
+		LatencyMs: float64(time.Since(qCtx.StartTime()).Microseconds()) / 1000,
+		Marks:     qCtx.Marks(),
+	}
+	if a := qCtx.ServerMeta.ClientAddr; a.IsValid() {
+		r.Client = a.Unmap().String()
+	}
+	if resp := qCtx.R(); resp != nil {
+		rcode := resp.Rcode
+		r.Rcode = &rcode
+		for _, rr := range resp.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				r.Answers = append(r.Answers, rr.A.String())
+			case *dns.AAAA:
+				r.Answers = append(r.Answers, rr.AAAA.String())
+			case *dns.CNAME:
+				r.Answers = append(r.Answers, rr.Target)
+			}
+		}
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// add adds r to the write buffer. It never blocks.
+func (h *QueryHistory) add(r *record) {
+	h.m.RLock()
+	defer h.m.RUnlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.ch <- r:
+	default:
+	}
+}
+
+func (h *QueryHistory) writeLoop() {
+	defer close(h.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var lastErr error
+	logErr := func(err error) {
+		if err != nil && lastErr == nil {
+			h.logger.Error("failed to write query history", zap.Error(err))
+		}
+		lastErr = err
+	}
+	for {
+		select {
+		case r, ok := <-h.ch:
+			if !ok {
+				logErr(h.s.flush())
+				return
+			}
+			b, err := json.Marshal(r)
+			if err != nil {
+				continue
+			}
+			logErr(h.s.append(r.Time, append(b, '\n')))
+		case <-ticker.C:
+			logErr(h.s.flush())
+		}
+	}
+}
+
+// Api serves "GET /search". See searchParams for its parameters.
+func (h *QueryHistory) Api() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/search", h.handleSearch)
+	return r
+}
+
+type searchResult struct {
+	Records    []*record `json:"records"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+func (h *QueryHistory) handleSearch(w http.ResponseWriter, req *http.Request) {
+	f, limit, after, err := searchParams(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Make records in buffer visible.
+	if err := h.s.flush(); err != nil {
+		h.logger.Warn("failed to flush query history", zap.Error(err))
+	}
+	rs, next, err := h.s.search(f, limit, after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := searchResult{Records: rs}
+	if res.Records == nil {
+		res.Records = []*record{}
+	}
+	if next != nil {
+		res.NextCursor = next.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// searchParams parses query parameters of a search request.
+//   - client: ip or prefix of clients, e.g. "192.168.1.0/24".
+//   - domain: domain suffix, matched by labels. e.g. "example.com"
+//     matches "example.com" and "www.example.com".
+//   - from, to: time range, RFC 3339 or unix seconds.
+//   - rcode: rcode name or number, e.g. "NXDOMAIN" or "3".
+//   - limit: max records per page. Default is 100, max is 1000.
+//   - cursor: "next_cursor" of the previous page.
+func searchParams(req *http.Request) (*filter, int, *cursor, error) {
+	q := req.URL.Query()
+	f := new(filter)
+	if s := q.Get("client"); len(s) > 0 {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("invalid client, %w", err)
+		}
+		f.client = &p
+	}
+	if s := q.Get("domain"); len(s) > 0 {
+		f.domain = dns.Fqdn(strings.ToLower(s))
+	}
+	var err error
+	if f.from, err = parseTime(q.Get("from")); err != nil {
+		return nil, 0, nil, fmt.Errorf("invalid from, %w", err)
+	}
+	if f.to, err = parseTime(q.Get("to")); err != nil {
+		return nil, 0, nil, fmt.Errorf("invalid to, %w", err)
+	}
+	if s := q.Get("rcode"); len(s) > 0 {
+		rcode, ok := dns.StringToRcode[strings.ToUpper(s)]
+		if !ok {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, 0, nil, fmt.Errorf("invalid rcode %s", s)
+			}
+			rcode = n
+		}
+		f.rcode = &rcode
+	}
+	limit := defaultSearchLimit
+	if s := q.Get("limit"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, 0, nil, fmt.Errorf("invalid limit %s", s)
+		}
+		limit = min(n, maxSearchLimit)
+	}
+	var after *cursor
+	if s := q.Get("cursor"); len(s) > 0 {
+		c, err := parseCursor(s)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		after = &c
+	}
+	return f, limit, after, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	a = a.Unmap()
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// filter matches records. Zero fields match all records.
+type filter struct {
+	client *netip.Prefix
+	domain string // Fqdn, lower case.
+	from   time.Time
+	to     time.Time
+	rcode  *int
+}
+
+func (f *filter) match(r *record) bool {
+	if f.client != nil {
+		a, err := netip.ParseAddr(r.Client)
+		if err != nil || !f.client.Contains(a.Unmap()) {
+			return false
+		}
+	}
+	if len(f.domain) > 0 {
+		name := strings.ToLower(r.Qname)
+		if name != f.domain && !(f.domain == "." || strings.HasSuffix(name, "."+f.domain)) {
+			return false
+		}
+	}
+	if !f.from.IsZero() && r.Time.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && r.Time.After(f.to) {
+		return false
+	}
+	if f.rcode != nil && (r.Rcode == nil || *r.Rcode != *f.rcode) {
+		return false
+	}
+	return true
+}
+
+// Close writes buffered records and closes the store.
+func (h *QueryHistory) Close() error {
+	if h.s == nil { // Dry run.
+		return nil
+	}
+	h.m.Lock()
+	if h.closed {
+		h.m.Unlock()
+		return nil
+	}
+	h.closed = true
+	close(h.ch)
+	h.m.Unlock()
+	<-h.done
+	return h.s.close()
+}
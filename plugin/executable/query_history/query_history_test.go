@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+func search(t *testing.T, h *QueryHistory, query string) searchResult {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.Api().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("search %s: %d %s", query, w.Code, w.Body)
+	}
+	var res searchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func Test_QueryHistory_search(t *testing.T) {
+	args := &Args{Dir: t.TempDir()}
+	if err := args.init(); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewQueryHistory(args, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.s.segmentSize = 512 // Records span several segments.
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	nx, ok := 3, 0
+	for i := 0; i < 20; i++ {
+		r := &record{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Client: fmt.Sprintf("192.168.%d.1", i%2),
+			Qname:  fmt.Sprintf("q%d.example.com.", i),
+			Qtype:  "A",
+			Rcode:  &ok,
+		}
+		if i%4 == 0 {
+			r.Rcode = &nx
+			r.Qname = fmt.Sprintf("q%d.other.org.", i)
+		}
+		b, _ := json.Marshal(r)
+		if err := h.s.append(r.Time, append(b, '\n')); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(h.s.segs) < 2 {
+		t.Fatalf("want several segments, got %d", len(h.s.segs))
+	}
+
+	res := search(t, h, "")
+	if len(res.Records) != 20 || res.Records[0].Qname != "q19.example.com." {
+		t.Fatalf("want 20 records, newest first, got %d", len(res.Records))
+	}
+	if res := search(t, h, "client=192.168.1.0/24"); len(res.Records) != 10 {
+		t.Fatalf("client filter: got %d", len(res.Records))
+	}
+	if res := search(t, h, "domain=Other.org&rcode=NXDOMAIN"); len(res.Records) != 5 {
+		t.Fatalf("domain and rcode filter: got %d", len(res.Records))
+	}
+	if res := search(t, h, "domain=ample.com"); len(res.Records) != 0 {
+		t.Fatalf("domain should be matched by labels, got %d", len(res.Records))
+	}
+	q := fmt.Sprintf("from=%d&to=%d", start.Unix()+5, start.Unix()+9)
+	if res := search(t, h, q); len(res.Records) != 5 || res.Records[4].Qname != "q5.example.com." {
+		t.Fatalf("time filter: got %+v", res.Records)
+	}
+
+	// Paging.
+	var names []string
+	cursor := ""
+	for page := 0; ; page++ {
+		res := search(t, h, "limit=3&cursor="+cursor)
+		for _, r := range res.Records {
+			names = append(names, r.Qname)
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		if page > 10 {
+			t.Fatal("too many pages")
+		}
+		cursor = res.NextCursor
+	}
+	if len(names) != 20 || names[3] != "q16.other.org." || names[19] != "q0.other.org." {
+		t.Fatalf("unexpected pages %v", names)
+	}
+}
+
+func Test_store_retention(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir, 1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.segmentSize = 256
+	line := []byte(`{"qname":"a."}` + "\n")
+	now := time.Now()
+	for i := 0; i < 200; i++ {
+		if err := s.append(now.Add(time.Duration(i)), line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = s.flush()
+	var total int64
+	for _, id := range s.segs {
+		total += s.sizes[id]
+	}
+	if total > 1024+s.segmentSize {
+		t.Fatalf("store size %d exceeds the limit", total)
+	}
+	des, _ := os.ReadDir(dir)
+	if len(des) != len(s.segs) {
+		t.Fatalf("%d files, %d segments", len(des), len(s.segs))
+	}
+
+	// Expired segments are removed, except the newest one.
+	s.applyRetention(now.Add(time.Hour * 2))
+	if len(s.segs) != 1 {
+		t.Fatalf("want 1 segment after expiration, got %d", len(s.segs))
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen.
+	s, err = openStore(dir, 1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if res, _, err := s.search(new(filter), 1000, nil); err != nil || len(res) == 0 {
+		t.Fatalf("records should be loaded after reopen, %d %v", len(res), err)
+	}
+}
+
+func Test_QueryHistory_unmap(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	qCtx := query_context.NewContext(m)
+	qCtx.ServerMeta.ClientAddr = netip.MustParseAddr("::ffff:192.168.1.1")
+	r := newRecord(qCtx, nil)
+	if r.Client != "192.168.1.1" {
+		t.Fatalf("client should be unmapped, got %s", r.Client)
+	}
+
+	p, err := parsePrefix("192.168.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &filter{client: &p}
+	if !f.match(r) || !f.match(&record{Client: "::ffff:192.168.1.1"}) {
+		t.Fatal("ipv4-mapped clients should match ipv4 prefixes")
+	}
+}
+
+func Test_store_shared(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir, 1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(`{"qname":"a."}` + "\n")
+	if err := s.append(time.Now(), line); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reload shares the store and updates its limits. Closing the old
+	// user keeps the newest segment open.
+	if !s.ref(2048, time.Hour*2) {
+		t.Fatal("ref failed")
+	}
+	if s.maxSize != 2048 || s.maxAge != time.Hour*2 {
+		t.Fatal("limits are not updated")
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	if s.f == nil {
+		t.Fatal("store should not be closed by the old user")
+	}
+	if err := s.append(time.Now(), line); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	if s.f != nil || s.ref(2048, time.Hour) {
+		t.Fatal("store should be closed")
+	}
+	if res, _, err := s.search(new(filter), 1000, nil); err != nil || len(res) != 2 {
+		t.Fatalf("want 2 records, got %d %v", len(res), err)
+	}
+	des, _ := os.ReadDir(dir)
+	if len(des) != 1 {
+		t.Fatalf("want 1 segment, got %d", len(des))
+	}
+}
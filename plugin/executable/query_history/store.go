@@ -0,0 +1,297 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const segmentSuffix = ".jsonl"
+
+// store is a segmented on-disk ring of records. Records are appended to
+// the newest segment as JSON Lines. A segment is named by the unix nano
+// time of its first record. The oldest segments are removed when the
+// store exceeds its size or age limit.
+//
+// A store can be shared by the old and the new plugin during a reload, so
+// only one of them writes segments and applies retention. See ref.
+type store struct {
+	dir         string
+	maxSize     int64
+	maxAge      time.Duration
+	segmentSize int64
+
+	m       sync.Mutex
+	refs    int
+	segs    []int64 // Start time of segments, ascending.
+	sizes   map[int64]int64
+	f       *os.File // The newest segment, nil if not opened yet.
+	bw      *bufio.Writer
+	curSize int64
+}
+
+func openStore(dir string, maxSize int64, maxAge time.Duration) (*store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &store{
+		dir:   dir,
+		refs:  1,
+		sizes: make(map[int64]int64),
+	}
+	s.setLimits(maxSize, maxAge)
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, de := range des {
+		id, ok := parseSegmentName(de.Name())
+		if !ok || !de.Type().IsRegular() {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.segs = append(s.segs, id)
+		s.sizes[id] = info.Size()
+	}
+	sort.Slice(s.segs, func(i, j int) bool { return s.segs[i] < s.segs[j] })
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.applyRetention(time.Now())
+	return s, nil
+}
+
+// setLimits sets the size and age limits. s.m must be held if s is in use.
+func (s *store) setLimits(maxSize int64, maxAge time.Duration) {
+	s.maxSize = maxSize
+	s.maxAge = maxAge
+	s.segmentSize = min(max(maxSize/8, 64<<10), 64<<20)
+}
+
+// ref adds a user of s and updates its limits. Each user calls close once.
+// It returns false if s is closed.
+func (s *store) ref(maxSize int64, maxAge time.Duration) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.refs <= 0 {
+		return false
+	}
+	s.refs++
+	s.setLimits(maxSize, maxAge)
+	return true
+}
+
+func parseSegmentName(name string) (int64, bool) {
+	n, ok := strings.CutSuffix(name, segmentSuffix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(n, 10, 64)
+	return id, err == nil
+}
+
+func (s *store) segmentPath(id int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+// append appends a record (a JSON line) with time t.
+// Records are not visible to search until flush is called.
+func (s *store) append(t time.Time, line []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.f == nil || s.curSize >= s.segmentSize {
+		if err := s.newSegment(t); err != nil {
+			return err
+		}
+	}
+	n, err := s.bw.Write(line)
+	s.curSize += int64(n)
+	s.sizes[s.segs[len(s.segs)-1]] = s.curSize
+	return err
+}
+
+// newSegment closes the current segment and creates a new one.
+// Always append to a new segment after a restart, because the last line
+// of an old segment may be incomplete.
+func (s *store) newSegment(t time.Time) error {
+	if err := s.closeSegment(); err != nil {
+		return err
+	}
+	id := t.UnixNano()
+	if len(s.segs) > 0 && id <= s.segs[len(s.segs)-1] {
+		id = s.segs[len(s.segs)-1] + 1
+	}
+	f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	s.f, s.bw, s.curSize = f, bufio.NewWriter(f), 0
+	s.segs = append(s.segs, id)
+	s.sizes[id] = 0
+	s.applyRetention(t)
+	return nil
+}
+
+func (s *store) closeSegment() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.bw.Flush()
+	if cErr := s.f.Close(); err == nil {
+		err = cErr
+	}
+	s.f, s.bw = nil, nil
+	return err
+}
+
+// applyRetention removes the oldest segments until the store is within
+// its limits. The newest segment is never removed.
+func (s *store) applyRetention(now time.Time) {
+	var total int64
+	for _, id := range s.segs {
+		total += s.sizes[id]
+	}
+	for len(s.segs) > 1 {
+		oldest, next := s.segs[0], s.segs[1]
+		// All records in the oldest segment are older than next.
+		expired := s.maxAge > 0 && now.Sub(time.Unix(0, next)) > s.maxAge
+		if !expired && (s.maxSize <= 0 || total <= s.maxSize) {
+			break
+		}
+		_ = os.Remove(s.segmentPath(oldest))
+		total -= s.sizes[oldest]
+		delete(s.sizes, oldest)
+		s.segs = s.segs[1:]
+	}
+}
+
+// flush makes appended records visible to search, and removes expired
+// segments.
+func (s *store) flush() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.applyRetention(time.Now())
+	if s.bw == nil {
+		return nil
+	}
+	return s.bw.Flush()
+}
+
+// close closes the newest segment when the last user of s calls it.
+func (s *store) close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.refs--; s.refs > 0 {
+		return nil
+	}
+	return s.closeSegment()
+}
+
+// cursor is the position of a record. Search results after a record start
+// from the record just before its position.
+type cursor struct {
+	seg int64
+	off int64
+}
+
+func (c cursor) String() string {
+	return fmt.Sprintf("%d-%d", c.seg, c.off)
+}
+
+func parseCursor(s string) (cursor, error) {
+	a, b, ok := strings.Cut(s, "-")
+	if !ok {
+		return cursor{}, fmt.Errorf("invalid cursor %s", s)
+	}
+	seg, err1 := strconv.ParseInt(a, 10, 64)
+	off, err2 := strconv.ParseInt(b, 10, 64)
+	if err1 != nil || err2 != nil {
+		return cursor{}, fmt.Errorf("invalid cursor %s", s)
+	}
+	return cursor{seg: seg, off: off}, nil
+}
+
+// search returns up to limit records that match f, newest first.
+// If after is not nil, only records older than it are returned.
+// The returned cursor is nil if there are no more records.
+func (s *store) search(f *filter, limit int, after *cursor) ([]*record, *cursor, error) {
+	s.m.Lock()
+	segs := append([]int64(nil), s.segs...)
+	s.m.Unlock()
+
+	var rs []*record
+	for i := len(segs) - 1; i >= 0; i-- {
+		id := segs[i]
+		if after != nil && id > after.seg {
+			continue
+		}
+		if !f.to.IsZero() && time.Unix(0, id).After(f.to) {
+			continue
+		}
+		if !f.from.IsZero() && i+1 < len(segs) && time.Unix(0, segs[i+1]).Before(f.from) {
+			break // This and older segments are out of the range.
+		}
+
+		b, err := os.ReadFile(s.segmentPath(id))
+		if err != nil {
+			if os.IsNotExist(err) { // Removed by retention.
+				continue
+			}
+			return nil, nil, err
+		}
+		end := int64(len(b))
+		if after != nil && id == after.seg {
+			end = min(after.off, end)
+		}
+		// Walk lines backward. The last line may be incomplete.
+		for end > 0 {
+			start := int64(bytes.LastIndexByte(b[:end-1], '\n') + 1)
+			line := b[start:end]
+			off := start
+			end = start
+
+			r := new(record)
+			if err := json.Unmarshal(line, r); err != nil {
+				continue
+			}
+			if !f.match(r) {
+				continue
+			}
+			if len(rs) == limit {
+				// There are more records. The next page starts before r.
+				return rs, &cursor{seg: id, off: off + int64(len(line))}, nil
+			}
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil, nil
+}
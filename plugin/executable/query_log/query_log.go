@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const PluginType = "query_log"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+const defaultBufferSize = 1024
+
+// Fields that can be logged.
+const (
+	FieldTime       = "time"
+	FieldID         = "id"
+	FieldClient     = "client"
+	FieldServerName = "server_name"
+	FieldURLPath    = "url_path"
+	FieldQuestion   = "question"
+	FieldRcode      = "rcode"
+	FieldAnswers    = "answers"
+	FieldElapsed    = "elapsed"
+	FieldMarks      = "marks"
+	FieldUpstream   = "upstream"
+	FieldError      = "error"
+)
+
+var allFields = []string{
+	FieldTime, FieldID, FieldClient, FieldServerName, FieldURLPath, FieldQuestion,
+	FieldRcode, FieldAnswers, FieldElapsed, FieldMarks, FieldUpstream, FieldError,
+}
+
+type Args struct {
+	// File that queries will be written into, as JSON Lines. Required.
+	File string `yaml:"file"`
+	// Rotate rotates File. See mlog.RotateConfig.
+	Rotate mlog.RotateConfig `yaml:"rotate"`
+	// Fields to log. Default is all fields.
+	Fields []string `yaml:"fields"`
+	// SampleRate is the fraction of queries to log, in (0, 1].
+	// Default is 1, all queries are logged.
+	SampleRate float64 `yaml:"sample_rate"`
+	// BufferSize is the maximum number of records waiting to be written.
+	// Records are dropped if the buffer is full. Default is 1024.
+	BufferSize int `yaml:"buffer_size"`
+}
+
+var _ sequence.RecursiveExecutable = (*QueryLog)(nil)
+
+type QueryLog struct {
+	fields     map[string]bool
+	sampleRate float64
+	w          *asyncWriter
+
+	writtenTotal prometheus.CounterFunc
+	droppedTotal prometheus.CounterFunc
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	if bp.DryRun() { // Don't touch the file.
+		_, err := newQueryLog(a, nil, bp.L())
+		return &QueryLog{}, err
+	}
+	out, err := openOutput(a.File, a.Rotate)
+	if err != nil {
+		return nil, err
+	}
+	q, err := newQueryLog(a, out, bp.L())
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	if err := q.registerMetricsTo(prometheus.WrapRegistererWith(prometheus.Labels{"tag": bp.Tag()}, prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg()))); err != nil {
+		_ = q.Close()
+		return nil, fmt.Errorf("failed to register metrics, %w", err)
+	}
+	return q, nil
+}
+
+// newQueryLog validates args. If out is nil, the returned QueryLog
+// cannot be used.
+func newQueryLog(args *Args, out io.WriteCloser, logger *zap.Logger) (*QueryLog, error) {
+	if len(args.File) == 0 {
+		return nil, errors.New("missing file")
+	}
+	fields := make(map[string]bool)
+	if len(args.Fields) == 0 {
+		for _, f := range allFields {
+			fields[f] = true
+		}
+	}
+	for _, f := range args.Fields {
+		valid := false
+		for _, af := range allFields {
+			if f == af {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown field %s", f)
+		}
+		fields[f] = true
+	}
+	sampleRate := args.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("invalid sample rate %v, must be in (0, 1]", args.SampleRate)
+	}
+	bufferSize := args.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	if out == nil {
+		return nil, nil
+	}
+
+	q := &QueryLog{
+		fields:     fields,
+		sampleRate: sampleRate,
+		w:          newAsyncWriter(out, bufferSize, logger),
+	}
+	q.writtenTotal = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "written_total",
+		Help: "The total number of records written",
+	}, func() float64 { return float64(q.w.written.Load()) })
+	q.droppedTotal = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "dropped_total",
+		Help: "The total number of records dropped because the buffer was full",
+	}, func() float64 { return float64(q.w.dropped.Load()) })
+	return q, nil
+}
+
+func (q *QueryLog) registerMetricsTo(r prometheus.Registerer) error {
+	for _, c := range [...]prometheus.Collector{q.writtenTotal, q.droppedTotal} {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type question struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+}
+
+// record is a line in the log file. Fields that are not selected are empty.
+type record struct {
+	Time       string    `json:"time,omitempty"`
+	ID         uint32    `json:"id,omitempty"`
+	Client     string    `json:"client,omitempty"`
+	ServerName string    `json:"server_name,omitempty"`
+	URLPath    string    `json:"url_path,omitempty"`
+	Question   *question `json:"question,omitempty"`
+	Rcode      string    `json:"rcode,omitempty"`
+	Answers    []string  `json:"answers,omitempty"`
+	ElapsedMs  *float64  `json:"elapsed_ms,omitempty"`
+	Marks      []uint32  `json:"marks,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (q *QueryLog) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	err := next.ExecNext(ctx, qCtx)
+	if sequence.IsExplaining(ctx) || q.sampleRate < 1 && rand.Float64() >= q.sampleRate {
+		return err
+	}
+	b, mErr := json.Marshal(q.record(qCtx, err))
+	if mErr == nil {
+		q.w.write(append(b, '\n'))
+	}
+	return err
+}
+
+func (q *QueryLog) record(qCtx *query_context.Context, err error) *record {
+	f := q.fields
+	rec := new(record)
+	if f[FieldTime] {
+		rec.Time = qCtx.StartTime().Format(time.RFC3339Nano)
+	}
+	if f[FieldID] {
+		rec.ID = qCtx.Id()
+	}
+	meta := qCtx.ServerMeta
+	if f[FieldClient] && meta.ClientAddr.IsValid() {
+		rec.Client = meta.ClientAddr.String()
+	}
+	if f[FieldServerName] {
+		rec.ServerName = meta.ServerName
+	}
+	if f[FieldURLPath] {
+		rec.URLPath = meta.UrlPath
+	}
+	if f[FieldQuestion] {
+		qq := qCtx.QQuestion()
+		rec.Question = &question{Name: qq.Name, Type: dns.Type(qq.Qtype).String(), Class: dns.Class(qq.Qclass).String()}
+	}
+	r := qCtx.R()
+	if f[FieldRcode] && r != nil {
+		rec.Rcode = dns.RcodeToString[r.Rcode]
+	}
+	if f[FieldAnswers] && r != nil {
+		for _, rr := range r.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				rec.Answers = append(rec.Answers, rr.A.String())
+			case *dns.AAAA:
+				rec.Answers = append(rec.Answers, rr.AAAA.String())
+			}
+		}
+	}
+	if f[FieldElapsed] {
+		ms := float64(time.Since(qCtx.StartTime()).Microseconds()) / 1000
+		rec.ElapsedMs = &ms
+	}
+	if f[FieldMarks] {
+		rec.Marks = qCtx.Marks()
+	}
+	if f[FieldUpstream] {
+		rec.Upstream = qCtx.Upstream()
+	}
+	if f[FieldError] && err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
+
+// Status implements coremain.StatusReporter. It is degraded if the last
+// write failed.
+func (q *QueryLog) Status() coremain.PluginStatus {
+	if q.w == nil { // Dry run.
+		return coremain.PluginStatus{Status: coremain.StatusHealthy}
+	}
+	s := coremain.PluginStatus{Status: coremain.StatusHealthy}
+	details := map[string]any{
+		"written": q.w.written.Load(),
+		"dropped": q.w.dropped.Load(),
+	}
+	if err := q.w.lastErr(); err != nil {
+		s.Status = coremain.StatusDegraded
+		details["error"] = err.Error()
+	}
+	s.Details = details
+	return s
+}
+
+// Close writes all buffered records and closes the file.
+func (q *QueryLog) Close() error {
+	if q.w == nil {
+		return nil
+	}
+	return q.w.close()
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_log
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+var answer sequence.ExecutableFunc = func(_ context.Context, qCtx *query_context.Context) error {
+	r := new(dns.Msg)
+	r.SetReply(qCtx.Q())
+	r.Answer = append(r.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+		A:   net.IPv4(1, 2, 3, 4),
+	})
+	qCtx.SetResponse(r)
+	qCtx.SetUpstream("u1")
+	qCtx.SetMark(2)
+	return nil
+}
+
+func newTestQueryLog(t *testing.T, args *Args) *QueryLog {
+	t.Helper()
+	args.File = filepath.Join(t.TempDir(), "query.log")
+	out, err := openOutput(args.File, args.Rotate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := newQueryLog(args, out, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func exec(t *testing.T, q *QueryLog) {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	qCtx := query_context.NewContext(m)
+	qCtx.ServerMeta = query_context.ServerMeta{ClientAddr: netip.MustParseAddr("127.0.0.1"), UrlPath: "/dns-query"}
+	if err := q.Exec(context.Background(), qCtx, sequence.NewChainWalker([]*sequence.ChainNode{{E: answer}}, nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRecords(t *testing.T, file string) []map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		r := make(map[string]any)
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func Test_QueryLog(t *testing.T) {
+	args := &Args{}
+	q := newTestQueryLog(t, args)
+	exec(t, q)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rs := readRecords(t, args.File)
+	if len(rs) != 1 {
+		t.Fatalf("want 1 record, got %d", len(rs))
+	}
+	r := rs[0]
+	for k, want := range map[string]any{
+		"client":   "127.0.0.1",
+		"url_path": "/dns-query",
+		"question": map[string]any{"name": "example.com.", "type": "A", "class": "IN"},
+		"rcode":    "NOERROR",
+		"answers":  []any{"1.2.3.4"},
+		"marks":    []any{float64(2)},
+		"upstream": "u1",
+	} {
+		if got, _ := json.Marshal(r[k]); string(got) != mustJSON(want) {
+			t.Errorf("%s = %s, want %s", k, got, mustJSON(want))
+		}
+	}
+	if _, ok := r["elapsed_ms"]; !ok {
+		t.Error("missing elapsed_ms")
+	}
+
+	// Selected fields only.
+	args = &Args{Fields: []string{FieldQuestion, FieldUpstream}}
+	q = newTestQueryLog(t, args)
+	exec(t, q)
+	_ = q.Close()
+	rs = readRecords(t, args.File)
+	if len(rs) != 1 || len(rs[0]) != 2 {
+		t.Fatalf("want only selected fields, got %v", rs)
+	}
+
+	if _, err := newQueryLog(&Args{File: "f", Fields: []string{"no_such_field"}}, nil, nil); err == nil {
+		t.Fatal("unknown field should be rejected")
+	}
+}
+
+func mustJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	<-w.unblock
+	return len(b), nil
+}
+
+func (w *blockingWriter) Close() error {
+	return nil
+}
+
+func Test_asyncWriter_drop(t *testing.T) {
+	bw := &blockingWriter{unblock: make(chan struct{})}
+	w := newAsyncWriter(bw, 2, zap.NewNop())
+	line := []byte(strings.Repeat("a", 8192) + "\n") // Larger than the bufio buffer.
+	for i := 0; i < 10; i++ {
+		w.write(line)
+	}
+	close(bw.unblock)
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	// The first record is being written, 2 records are buffered.
+	written, dropped := w.written.Load(), w.dropped.Load()
+	if written+dropped != 10 || dropped < 7 {
+		t.Fatalf("written %d, dropped %d", written, dropped)
+	}
+	w.write(line) // Writing after close is a noop.
+}
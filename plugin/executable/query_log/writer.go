@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package query_log
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+func openOutput(file string, rc mlog.RotateConfig) (io.WriteCloser, error) {
+	if rc.MaxSize > 0 {
+		return &lumberjack.Logger{
+			Filename:   file,
+			MaxSize:    rc.MaxSize,
+			MaxAge:     rc.MaxAge,
+			MaxBackups: rc.MaxBackups,
+			LocalTime:  rc.LocalTime,
+			Compress:   rc.Compress,
+		}, nil
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file, %w", err)
+	}
+	return f, nil
+}
+
+// asyncWriter writes records to out in a background goroutine.
+// write never blocks. Records are dropped if the buffer is full.
+type asyncWriter struct {
+	out    io.WriteCloser
+	logger *zap.Logger
+
+	m      sync.RWMutex
+	closed bool
+	ch     chan []byte
+	done   chan struct{}
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+
+	errM sync.Mutex
+	err  error // Last write error, nil if the last write succeeded.
+}
+
+func newAsyncWriter(out io.WriteCloser, size int, logger *zap.Logger) *asyncWriter {
+	w := &asyncWriter{
+		out:    out,
+		logger: logger,
+		ch:     make(chan []byte, size),
+		done:   make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *asyncWriter) write(b []byte) {
+	w.m.RLock()
+	defer w.m.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.ch <- b:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+func (w *asyncWriter) loop() {
+	defer close(w.done)
+	bw := bufio.NewWriter(w.out)
+	n := 0
+	for b := range w.ch {
+		_, _ = bw.Write(b)
+		n++
+		// Flush when the buffer is drained, so records are not delayed.
+		if len(w.ch) == 0 {
+			w.flush(bw, n)
+			n = 0
+		}
+	}
+	w.flush(bw, n)
+}
+
+// flush flushes n records in bw. If it fails, the records are dropped.
+func (w *asyncWriter) flush(bw *bufio.Writer, n int) {
+	err := bw.Flush()
+	if err != nil {
+		// A bufio.Writer stops working after an error.
+		bw.Reset(w.out)
+	}
+	w.setErr(err, n)
+}
+
+// setErr records the result of a flush of n records.
+func (w *asyncWriter) setErr(err error, n int) {
+	w.errM.Lock()
+	defer w.errM.Unlock()
+	if err != nil {
+		if w.err == nil {
+			w.logger.Error("failed to write query log", zap.Error(err))
+		}
+		w.dropped.Add(uint64(n))
+	} else {
+		w.written.Add(uint64(n))
+	}
+	w.err = err
+}
+
+func (w *asyncWriter) lastErr() error {
+	w.errM.Lock()
+	defer w.errM.Unlock()
+	return w.err
+}
+
+// close writes all buffered records and closes out.
+func (w *asyncWriter) close() error {
+	w.m.Lock()
+	if w.closed {
+		w.m.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.ch)
+	w.m.Unlock()
+	<-w.done
+	return w.out.Close()
+}
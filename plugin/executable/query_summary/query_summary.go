@@ -31,6 +31,7 @@ const (
 )
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "query_summary [msg_title]")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
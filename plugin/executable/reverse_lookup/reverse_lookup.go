@@ -89,7 +89,9 @@ func (p *ReverseLookup) Exec(ctx context.Context, qCtx *query_context.Context, n
 	if err := next.ExecNext(ctx, qCtx); err != nil {
 		return err
 	}
-	p.saveIPs(q, qCtx.R())
+	if !sequence.IsExplaining(ctx) {
+		p.saveIPs(q, qCtx.R())
+	}
 	return nil
 }
 
@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+)
+
+var _ RecursiveExecutable = (*block)(nil)
+
+// block is an if block or a switch block. The selected branch is walked
+// as a chain, then the walk continues with the rules after the block.
+type block struct {
+	// sel returns the index of the selected branch, or -1 for else.
+	// e is nil if the query is not in explain mode.
+	sel      func(ctx context.Context, qCtx *query_context.Context, e *explainer) (int, error)
+	branches [][]*ChainNode
+	names    []string // Branch names for explain.
+	els      []*ChainNode
+}
+
+func (b *block) Exec(ctx context.Context, qCtx *query_context.Context, next ChainWalker) error {
+	e := explainerFromCtx(ctx)
+	i, err := b.sel(ctx, qCtx, e)
+	if err != nil {
+		return err
+	}
+	c := b.els
+	if i >= 0 {
+		c = b.branches[i]
+	}
+	if e != nil {
+		name := "else"
+		if i >= 0 {
+			name = b.names[i]
+		}
+		e.branch(name)
+	}
+	if len(c) == 0 {
+		return next.ExecNext(ctx, qCtx)
+	}
+	w := ChainWalker{chain: c, jumpBack: next.jumpBack, cont: &next}
+	return w.ExecNext(ctx, qCtx)
+}
+
+// newBlock builds a block from bc. name identifies the block rule in the
+// sequence, e.g. "r1".
+func (s *Sequence) newBlock(bq BQ, bc *BlockConfig, name string) (*block, error) {
+	b := new(block)
+	var errs []error
+	for i, br := range bc.Branches {
+		c, err := s.buildRules(bq, br.Then, fmt.Sprintf("%s.b%d", name, i))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to init branch #%d %q, %w", i, br.String(), err))
+			continue
+		}
+		b.branches = append(b.branches, c)
+		b.names = append(b.names, br.String())
+	}
+	els, err := s.buildRules(bq, bc.Else, name+".else")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to init else branch, %w", err))
+	}
+	b.els = els
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	switch bc.Switch {
+	case "":
+		b.sel, err = s.newIfSelector(bq, bc.Branches, name)
+	case switchQtype:
+		b.sel, err = newQtypeSelector(bc.Branches)
+	case switchMark:
+		b.sel, err = newMarkSelector(bc.Branches)
+	case switchClient:
+		b.sel, err = s.newClientSelector(bq, bc.Branches, name)
+	default:
+		err = fmt.Errorf("invalid switch attribute %q", bc.Switch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newIfSelector selects the first branch whose matchers are all matched.
+func (s *Sequence) newIfSelector(bq BQ, brs []BranchConfig, name string) (func(context.Context, *query_context.Context, *explainer) (int, error), error) {
+	type branch struct {
+		ms    []Matcher
+		names []string
+	}
+	branches := make([]branch, 0, len(brs))
+	var errs []error
+	for i, br := range brs {
+		var b branch
+		for mi, mc := range br.If {
+			m, err := s.newMatcher(bq, mc, fmt.Sprintf("%s.b%d.m%d", name, i, mi))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to init matcher #%d %q of branch #%d, %w", mi, mc.String(), i, err))
+				continue
+			}
+			b.ms = append(b.ms, m)
+			b.names = append(b.names, mc.String())
+		}
+		branches = append(branches, b)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return func(ctx context.Context, qCtx *query_context.Context, e *explainer) (int, error) {
+	branchLoop:
+		for i, b := range branches {
+			for mi, m := range b.ms {
+				ok, err := m.Match(ctx, qCtx)
+				if e != nil {
+					e.blockMatch(b.names[mi], ok, err)
+				}
+				if err != nil {
+					return 0, err
+				}
+				if !ok {
+					continue branchLoop
+				}
+			}
+			return i, nil
+		}
+		return -1, nil
+	}, nil
+}
+
+// newQtypeSelector selects the first case that has the query type.
+func newQtypeSelector(brs []BranchConfig) (func(context.Context, *query_context.Context, *explainer) (int, error), error) {
+	cases := make(map[uint16]int)
+	for i, br := range brs {
+		for _, v := range br.Case {
+			t, err := parseQtype(v)
+			if err != nil || len(v) == 0 {
+				return nil, fmt.Errorf("invalid qtype %q in case #%d", v, i)
+			}
+			if _, dup := cases[t]; !dup {
+				cases[t] = i
+			}
+		}
+	}
+	return func(_ context.Context, qCtx *query_context.Context, _ *explainer) (int, error) {
+		if i, ok := cases[qCtx.Q().Question[0].Qtype]; ok {
+			return i, nil
+		}
+		return -1, nil
+	}, nil
+}
+
+// newMarkSelector selects the first case that the query has any mark of.
+func newMarkSelector(brs []BranchConfig) (func(context.Context, *query_context.Context, *explainer) (int, error), error) {
+	cases := make([][]uint32, 0, len(brs))
+	for i, br := range brs {
+		var marks []uint32
+		for _, v := range br.Case {
+			m, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mark %q in case #%d", v, i)
+			}
+			marks = append(marks, uint32(m))
+		}
+		cases = append(cases, marks)
+	}
+	return func(_ context.Context, qCtx *query_context.Context, _ *explainer) (int, error) {
+		for i, marks := range cases {
+			for _, m := range marks {
+				if qCtx.HasMark(m) {
+					return i, nil
+				}
+			}
+		}
+		return -1, nil
+	}, nil
+}
+
+// newClientSelector selects the first case that has the client ip.
+// Cases are client_ip matchers.
+func (s *Sequence) newClientSelector(bq BQ, brs []BranchConfig, name string) (func(context.Context, *query_context.Context, *explainer) (int, error), error) {
+	const matcherType = "client_ip"
+	f := GetMatchQuickSetup(matcherType)
+	if f == nil {
+		return nil, fmt.Errorf("matcher type %s is not available", matcherType)
+	}
+	ms := make([]Matcher, 0, len(brs))
+	for i, br := range brs {
+		m, err := f(NewBQ(bq.M(), bq.L().Named(fmt.Sprintf("%s.b%d", name, i))), strings.Join(br.Case, " "))
+		if err != nil {
+			return nil, fmt.Errorf("invalid case #%d, %w", i, err)
+		}
+		s.anonymousPlugins = append(s.anonymousPlugins, m)
+		ms = append(ms, m)
+	}
+	return func(ctx context.Context, qCtx *query_context.Context, _ *explainer) (int, error) {
+		for i, m := range ms {
+			ok, err := m.Match(ctx, qCtx)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				return i, nil
+			}
+		}
+		return -1, nil
+	}, nil
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func Test_block(t *testing.T) {
+	tests := []struct {
+		name      string
+		ra        []RuleArgs
+		qtype     uint16
+		mark      uint32
+		wantErr   bool
+		wantRcode int // -1 means no response
+	}{
+		{
+			name: "if then continue",
+			ra: []RuleArgs{
+				{If: []any{"$true"}, Then: []RuleArgs{{Exec: "$nop"}}, Else: []RuleArgs{{Exec: "$err"}}},
+				{Exec: "reject 1"},
+			},
+			wantRcode: 1,
+		},
+		{
+			name: "elif return",
+			ra: []RuleArgs{
+				{
+					If:   []any{"$false"},
+					Then: []RuleArgs{{Exec: "$err"}},
+					Elif: []ElifArgs{
+						{If: []any{"$true", "$false"}, Then: []RuleArgs{{Exec: "$err"}}},
+						{If: []any{map[string]any{"any": []any{"$false", "$true"}}}, Then: []RuleArgs{{Exec: "reject 2"}, {Exec: "return"}}},
+					},
+					Else: []RuleArgs{{Exec: "$err"}},
+				},
+				{Exec: "$err"}, // skipped by return
+			},
+			wantRcode: 2,
+		},
+		{
+			name: "else and matches",
+			ra: []RuleArgs{
+				{Matches: []any{"$false"}, If: []any{"$true"}, Then: []RuleArgs{{Exec: "$err"}}},
+				{If: []any{"$false"}, Then: []RuleArgs{{Exec: "$err"}}, Else: []RuleArgs{{Exec: "$nop"}}},
+				{If: []any{"$false"}, Then: []RuleArgs{{Exec: "$err"}}}, // no else
+				{Exec: "reject 3"},
+			},
+			wantRcode: 3,
+		},
+		{
+			name: "nested jump",
+			ra: []RuleArgs{
+				{If: []any{"$true"}, Then: []RuleArgs{
+					{If: []any{"$true"}, Then: []RuleArgs{{Exec: "jump seq2"}}},
+					{Exec: "reject 4"},
+				}},
+				{Exec: "$err"}, // reject does not run the rest
+			},
+			wantRcode: 4,
+		},
+		{
+			name: "switch qtype",
+			ra: []RuleArgs{
+				{Switch: "qtype", Cases: []CaseArgs{
+					{Case: []string{"A"}, Then: []RuleArgs{{Exec: "$err"}}},
+					{Case: []string{"MX", "28"}, Then: []RuleArgs{{Exec: "reject 5"}}},
+				}, Default: []RuleArgs{{Exec: "$err"}}},
+			},
+			qtype:     dns.TypeAAAA,
+			wantRcode: 5,
+		},
+		{
+			name: "switch qtype default",
+			ra: []RuleArgs{
+				{Switch: "qtype", Cases: []CaseArgs{
+					{Case: []string{"A"}, Then: []RuleArgs{{Exec: "$err"}}},
+				}, Default: []RuleArgs{{Exec: "reject 6"}}},
+			},
+			qtype:     dns.TypeMX,
+			wantRcode: 6,
+		},
+		{
+			name: "switch mark",
+			ra: []RuleArgs{
+				{Switch: "mark", Cases: []CaseArgs{
+					{Case: []string{"1", "2"}, Then: []RuleArgs{{Exec: "$err"}}},
+					{Case: []string{"3"}, Then: []RuleArgs{{Exec: "reject 7"}}},
+				}},
+			},
+			mark:      3,
+			wantRcode: 7,
+		},
+		{
+			name: "branch error",
+			ra: []RuleArgs{
+				{If: []any{"$err"}, Then: []RuleArgs{{Exec: "$nop"}}},
+			},
+			wantErr:   true,
+			wantRcode: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := make(map[string]any)
+			m := coremain.NewTestMosdnsWithPlugins(ps)
+			preparePlugins(ps)
+			s2, err := NewSequence(coremain.NewBP("seq2", m), []RuleArgs{{Exec: "$nop"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ps["seq2"] = s2
+			s, err := NewSequence(coremain.NewBP("test", m), tt.ra)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := new(dns.Msg)
+			qtype := tt.qtype
+			if qtype == 0 {
+				qtype = dns.TypeA
+			}
+			q.SetQuestion("example.com.", qtype)
+			qCtx := query_context.NewContext(q)
+			if tt.mark > 0 {
+				qCtx.SetMark(tt.mark)
+			}
+			if err := s.Exec(context.Background(), qCtx); (err != nil) != tt.wantErr {
+				t.Fatalf("Exec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			rcode := -1
+			if r := qCtx.R(); r != nil {
+				rcode = r.Rcode
+			}
+			if rcode != tt.wantRcode {
+				t.Fatalf("Exec() rcode = %d, want %d", rcode, tt.wantRcode)
+			}
+		})
+	}
+}
+
+func Test_parseBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		ra      RuleArgs
+		wantErr bool
+	}{
+		{"exec and block", RuleArgs{Exec: "accept", If: []any{"$a"}}, true},
+		{"if and switch", RuleArgs{If: []any{"$a"}, Switch: "qtype"}, true},
+		{"then without if", RuleArgs{Then: []RuleArgs{{Exec: "accept"}}}, true},
+		{"elif without if", RuleArgs{If: []any{"$a"}, Elif: []ElifArgs{{Then: []RuleArgs{}}}}, true},
+		{"invalid switch", RuleArgs{Switch: "qname"}, true},
+		{"empty case", RuleArgs{Switch: "qtype", Cases: []CaseArgs{{Then: []RuleArgs{}}}}, true},
+		{"invalid nested rule", RuleArgs{If: []any{"$a"}, Then: []RuleArgs{{Matches: []any{1}}}}, true},
+		{"if", RuleArgs{If: []any{"$a"}, Else: []RuleArgs{{Exec: "accept"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseArgs(tt.ra); (err != nil) != tt.wantErr {
+				t.Fatalf("parseArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -87,6 +87,11 @@ type ActionJump struct {
 }
 
 func (a *ActionJump) Exec(ctx context.Context, qCtx *query_context.Context, next ChainWalker) error {
+	ctx, err := enterJump(ctx)
+	if err != nil {
+		setServfail(qCtx)
+		return err
+	}
 	w := NewChainWalker(a.To, &next)
 	return w.ExecNext(ctx, qCtx)
 }
@@ -106,6 +111,11 @@ type ActionGoto struct {
 }
 
 func (a ActionGoto) Exec(ctx context.Context, qCtx *query_context.Context, _ ChainWalker) error {
+	ctx, err := enterJump(ctx)
+	if err != nil {
+		setServfail(qCtx)
+		return err
+	}
 	w := NewChainWalker(a.To, nil)
 	return w.ExecNext(ctx, qCtx)
 }
@@ -118,6 +128,13 @@ func setupGoto(bq BQ, s string) (any, error) {
 	return &ActionGoto{To: gt.chain}, nil
 }
 
+func setServfail(qCtx *query_context.Context) {
+	r := new(dns.Msg)
+	r.SetReply(qCtx.Q())
+	r.Rcode = dns.RcodeServerFailure
+	qCtx.SetResponse(r)
+}
+
 var _ Matcher = (*MatchAlwaysTrue)(nil)
 
 type MatchAlwaysTrue struct{}
@@ -23,7 +23,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
 	"io"
 )
 
@@ -34,12 +36,24 @@ type ChainNode struct {
 	// In case both are set. E is preferred.
 	E  Executable
 	RE RecursiveExecutable
+
+	// Name is the name of the span of this node if the query is traced.
+	// Optional.
+	Name string
+
+	rule  *ruleInfo  // nil if the node was not built from a rule.
+	stats *ruleStats // nil if stats are not enabled.
 }
 
 type ChainWalker struct {
 	p        int
 	chain    []*ChainNode
 	jumpBack *ChainWalker
+
+	// cont continues the walk at the end of chain, before jumpBack.
+	// It is set when walking a branch of a block, so the rules after the
+	// block run after the branch. Unlike jumpBack, "return" skips it.
+	cont *ChainWalker
 }
 
 func NewChainWalker(chain []*ChainNode, jumpBack *ChainWalker) ChainWalker {
@@ -51,27 +65,53 @@ func NewChainWalker(chain []*ChainNode, jumpBack *ChainWalker) ChainWalker {
 
 func (w *ChainWalker) ExecNext(ctx context.Context, qCtx *query_context.Context) error {
 	p := w.p
+	e := explainerFromCtx(ctx)
+	var step *ExplainStep
 	// Evaluate rules' matchers in loop.
 checkMatchesLoop:
 	for p < len(w.chain) {
 		n := w.chain[p]
+		stats := n.stats
+		if e != nil {
+			step = e.visit(n)
+			stats = nil // Explain queries are not counted.
+		}
+		start := stats.now()
 
-		for _, match := range n.Matches {
+		for i, match := range n.Matches {
 			ok, err := match.Match(ctx, qCtx)
+			stats.evaluated()
+			if e != nil {
+				e.match(step, n, i, ok, err)
+			}
 			if err != nil {
+				stats.done(start, false, err)
 				return err
 			}
 			if !ok {
 				// Skip this node if condition was not matched.
+				stats.done(start, false, nil)
 				p++
 				continue checkMatchesLoop
 			}
 		}
+		stats.matchedAll()
 
 		// Exec rules' executables in loop, or in stack if it is a recursive executable.
 		switch {
 		case n.E != nil:
-			if err := n.E.Exec(ctx, qCtx); err != nil {
+			if e != nil {
+				e.beforeExec(step, n, qCtx)
+			}
+			spanCtx, span := trace.Start(ctx, n.spanName())
+			err := n.E.Exec(spanCtx, qCtx)
+			span.SetError(err)
+			span.End()
+			stats.done(start, true, err)
+			if e != nil {
+				e.afterExec(step, err)
+			}
+			if err != nil {
 				return err
 			}
 			p++
@@ -81,13 +121,30 @@ checkMatchesLoop:
 				p:        p + 1,
 				chain:    w.chain,
 				jumpBack: w.jumpBack,
+				cont:     w.cont,
+			}
+			// The span of a recursive executable also covers the rest of the
+			// chain that it runs.
+			if e != nil {
+				e.beforeExec(step, n, qCtx)
 			}
-			return n.RE.Exec(ctx, qCtx, next)
+			spanCtx, span := trace.Start(ctx, n.spanName())
+			err := n.RE.Exec(spanCtx, qCtx, next)
+			span.SetError(err)
+			span.End()
+			stats.done(start, true, err)
+			if e != nil {
+				e.afterExec(step, err)
+			}
+			return err
 		default:
 			panic("n cannot be executed")
 		}
 	}
 
+	if w.cont != nil { // End of a block branch.
+		return w.cont.ExecNext(ctx, qCtx)
+	}
 	if w.jumpBack != nil { // End of chain, time to jump back.
 		return w.jumpBack.ExecNext(ctx, qCtx)
 	}
@@ -96,53 +153,146 @@ checkMatchesLoop:
 	return nil
 }
 
+func (n *ChainNode) spanName() string {
+	if len(n.Name) > 0 {
+		return n.Name
+	}
+	return "exec"
+}
+
 func (w *ChainWalker) nop() bool {
 	return w.p >= len(w.chain)
 }
 
+// buildChain builds s.chain from rs. It checks all rules and
+// returns all errors found.
 func (s *Sequence) buildChain(bq BQ, rs []RuleConfig) error {
+	c, err := s.buildRules(bq, rs, "")
+	if err != nil {
+		return err
+	}
+	s.chain = c
+	return nil
+}
+
+// buildRules builds nodes from rs. block is the name of the block branch
+// that rs belong to (e.g. "r1.b0"), or empty for top-level rules.
+func (s *Sequence) buildRules(bq BQ, rs []RuleConfig, block string) ([]*ChainNode, error) {
 	c := make([]*ChainNode, 0, len(rs))
+	var errs []error
+	names := make([]string, 0, len(rs))
 	for ri, r := range rs {
-		n, err := s.newNode(bq, r, ri)
+		name := fmt.Sprintf("r%d", ri)
+		if len(block) > 0 {
+			name = block + "." + name
+		}
+		names = append(names, name)
+		n, err := s.newNode(bq, r, ri, block, name)
 		if err != nil {
-			return fmt.Errorf("failed to init rule #%d, %w", ri, err)
+			errs = append(errs, fmt.Errorf("failed to init rule #%d, %w", ri, err))
+			continue
 		}
 		c = append(c, n)
 	}
-	s.chain = c
-	return nil
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	warnUnreachable(bq.L(), rs, names)
+	return c, nil
 }
 
-func (s *Sequence) newNode(bq BQ, r RuleConfig, ri int) (*ChainNode, error) {
+// newNode builds a node from rule r. name identifies r in the sequence,
+// e.g. "r1" or "r1.b0.r2".
+func (s *Sequence) newNode(bq BQ, r RuleConfig, ri int, block, name string) (*ChainNode, error) {
 	n := new(ChainNode)
+	var errs []error
+
+	// Stats are added before the rules in the block, so they are in config order.
+	if s.stats != nil {
+		statName := name
+		if len(r.Name) > 0 {
+			statName = r.Name
+		}
+		if _, dup := s.statNames[statName]; dup {
+			errs = append(errs, fmt.Errorf("duplicated rule name %s", statName))
+		}
+		s.statNames[statName] = struct{}{}
+		n.stats = &ruleStats{name: statName}
+		s.stats = append(s.stats, n.stats)
+	}
 
 	// init matches
 	for mi, mc := range r.Matches {
-		m, err := s.newMatcher(bq, mc, ri, mi)
+		m, err := s.newMatcher(bq, mc, fmt.Sprintf("%s.m%d", name, mi))
 		if err != nil {
-			return nil, fmt.Errorf("failed to init matcher #%d, %w", mi, err)
+			errs = append(errs, fmt.Errorf("failed to init matcher #%d %q, %w", mi, mc.String(), err))
+			continue
 		}
 		n.Matches = append(n.Matches, m)
 	}
 
 	// init exec
-	e, re, err := s.newExec(bq, r, ri)
+	var e Executable
+	var re RecursiveExecutable
+	var err error
+	if r.Block != nil {
+		re, err = s.newBlock(bq, r.Block, name)
+	} else {
+		e, re, err = s.newExec(bq, r, name)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to init exec, %w", err)
+		errs = append(errs, fmt.Errorf("failed to init exec %q, %w", r.execString(), err))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 	n.E = e
 	n.RE = re
+	n.Name = "exec " + r.execString()
+	n.rule = &ruleInfo{s: s, block: block, idx: ri, exec: r.execString()}
+	for _, mc := range r.Matches {
+		n.rule.matches = append(n.rule.matches, mc.String())
+	}
+	if n.stats != nil {
+		n.stats.rule = n.rule
+	}
 	return n, nil
 }
 
-func (s *Sequence) newMatcher(bq BQ, mc MatchConfig, ri, mi int) (Matcher, error) {
+// newMatcher builds a matcher from mc. name identifies mc in the sequence,
+// e.g. "r1.m0".
+func (s *Sequence) newMatcher(bq BQ, mc MatchConfig, name string) (Matcher, error) {
 	var m Matcher
 	switch {
+	case len(mc.Any) > 0 || len(mc.All) > 0:
+		g := &matchGroup{any: len(mc.Any) > 0}
+		sub := mc.All
+		if g.any {
+			sub = mc.Any
+		}
+		var errs []error
+		for i, smc := range sub {
+			sm, err := s.newMatcher(bq, smc, fmt.Sprintf("%s.%d", name, i))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to init matcher #%d %q, %w", i, smc.String(), err))
+				continue
+			}
+			g.ms = append(g.ms, sm)
+		}
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		m = g
+
 	case len(mc.Tag) > 0:
-		m, _ = bq.M().GetPlugin(mc.Tag).(Matcher)
-		if m == nil {
+		p := bq.M().GetPlugin(mc.Tag)
+		if p == nil {
 			return nil, fmt.Errorf("can not find matcher %s", mc.Tag)
 		}
+		m, _ = p.(Matcher)
+		if m == nil {
+			return nil, fmt.Errorf("plugin %s is not a matcher", mc.Tag)
+		}
 		if qc, ok := m.(QuickConfigurableMatch); ok {
 			v, err := qc.QuickConfigureMatch(mc.Args)
 			if err != nil {
@@ -151,12 +301,19 @@ func (s *Sequence) newMatcher(bq BQ, mc MatchConfig, ri, mi int) (Matcher, error
 			m = v
 		}
 
+	case len(mc.Type) > 0 && (mc.PluginArgs != nil || GetMatchQuickSetup(mc.Type) == nil):
+		p, err := s.newInlinePlugin(bq, mc.Type, mc.PluginArgs, name)
+		if err != nil {
+			return nil, err
+		}
+		m, _ = p.(Matcher)
+		if m == nil {
+			return nil, fmt.Errorf("plugin type %s is not a matcher", mc.Type)
+		}
+
 	case len(mc.Type) > 0:
 		f := GetMatchQuickSetup(mc.Type)
-		if f == nil {
-			return nil, fmt.Errorf("invalid matcher type %s", mc.Type)
-		}
-		p, err := f(NewBQ(bq.M(), bq.L().Named(fmt.Sprintf("r%d.m%d", ri, mi))), mc.Args)
+		p, err := f(NewBQ(bq.M(), bq.L().Named(name)), mc.Args)
 		if err != nil {
 			return nil, fmt.Errorf("failed to init matcher, %w", err)
 		}
@@ -172,7 +329,8 @@ func (s *Sequence) newMatcher(bq BQ, mc MatchConfig, ri, mi int) (Matcher, error
 	return m, nil
 }
 
-func (s *Sequence) newExec(bq BQ, rc RuleConfig, ri int) (Executable, RecursiveExecutable, error) {
+// newExec builds the exec of rc. name identifies rc in the sequence.
+func (s *Sequence) newExec(bq BQ, rc RuleConfig, name string) (Executable, RecursiveExecutable, error) {
 	var exec any
 	switch {
 	case len(rc.Tag) > 0:
@@ -190,12 +348,16 @@ func (s *Sequence) newExec(bq BQ, rc RuleConfig, ri int) (Executable, RecursiveE
 			exec = p
 		}
 
+	case len(rc.Type) > 0 && (rc.PluginArgs != nil || GetExecQuickSetup(rc.Type) == nil):
+		p, err := s.newInlinePlugin(bq, rc.Type, rc.PluginArgs, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		exec = p
+
 	case len(rc.Type) > 0:
 		f := GetExecQuickSetup(rc.Type)
-		if f == nil {
-			return nil, nil, fmt.Errorf("invalid executable type %s", rc.Type)
-		}
-		v, err := f(NewBQ(bq.M(), bq.L().Named(fmt.Sprintf("r%d", ri))), rc.Args)
+		v, err := f(NewBQ(bq.M(), bq.L().Named(name)), rc.Args)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to init executable, %w", err)
 		}
@@ -220,10 +382,49 @@ func closePlugin(p any) {
 	}
 }
 
+// newInlinePlugin creates an anonymous plugin of typ that is defined
+// inline in a rule. The plugin will be closed with s.
+func (s *Sequence) newInlinePlugin(bq BQ, typ string, args any, name string) (any, error) {
+	if _, ok := coremain.GetPluginType(typ); !ok {
+		return nil, fmt.Errorf("invalid type %s, it is neither a quick setup type nor a plugin type", typ)
+	}
+	tag := name
+	if len(s.tag) > 0 {
+		tag = s.tag + "." + name
+	}
+	p, err := bq.M().NewAnonymousPlugin(tag, typ, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init inline plugin %s, %w", typ, err)
+	}
+	s.anonymousPlugins = append(s.anonymousPlugins, p)
+	return p, nil
+}
+
 func reverseMatcher(m Matcher) Matcher {
 	return reverseMatch{m: m}
 }
 
+// matchGroup matches if any or all of its matchers are matched.
+// Matchers are evaluated in order and the evaluation stops as soon as
+// the result is known.
+type matchGroup struct {
+	any bool
+	ms  []Matcher
+}
+
+func (g *matchGroup) Match(ctx context.Context, qCtx *query_context.Context) (bool, error) {
+	for _, m := range g.ms {
+		ok, err := m.Match(ctx, qCtx)
+		if err != nil {
+			return false, err
+		}
+		if ok == g.any {
+			return ok, nil
+		}
+	}
+	return !g.any, nil
+}
+
 type reverseMatch struct {
 	m Matcher
 }
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// maxJumpDepth is the maximum number of nested jumps and gotos of a query.
+const maxJumpDepth = 64
+
+var ErrJumpDepthExceeded = fmt.Errorf("maximum jump depth %d exceeded", maxJumpDepth)
+
+type jumpDepthKey struct{}
+
+// enterJump returns a ctx with the jump depth of ctx plus one.
+// It returns ErrJumpDepthExceeded if the depth reaches maxJumpDepth.
+func enterJump(ctx context.Context) (context.Context, error) {
+	d, _ := ctx.Value(jumpDepthKey{}).(int)
+	if d >= maxJumpDepth {
+		return nil, ErrJumpDepthExceeded
+	}
+	return context.WithValue(ctx, jumpDepthKey{}, d+1), nil
+}
+
+// isTerminal reports whether rc always ends the walk of its rules,
+// so rules after it can never be reached.
+func isTerminal(rc RuleConfig) bool {
+	if len(rc.Matches) > 0 || rc.Block != nil || len(rc.Tag) > 0 {
+		return false
+	}
+	switch rc.Type {
+	case "accept", "reject", "return", "goto":
+		return true
+	}
+	return false
+}
+
+// warnUnreachable logs rules in rs that are after a terminal rule.
+// names are names of the rules in rs.
+func warnUnreachable(l *zap.Logger, rs []RuleConfig, names []string) {
+	for i, rc := range rs[:max(len(rs)-1, 0)] {
+		if isTerminal(rc) {
+			l.Warn("unreachable rules",
+				zap.String("after", fmt.Sprintf("%s %q", names[i], rc.execString())),
+				zap.Strings("rules", names[i+1:]),
+			)
+			return
+		}
+	}
+}
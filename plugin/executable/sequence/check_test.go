@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func Test_sequence_jumpDepth(t *testing.T) {
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+
+	// s0 jumps to s1, ..., s(n-1) jumps to sn. It has n nested jumps.
+	jumps := func(n int) *Sequence {
+		t.Helper()
+		next := "$nop"
+		var s *Sequence
+		for i := n; i >= 0; i-- {
+			tag := fmt.Sprintf("s%d", i)
+			var err error
+			s, err = newSequence(coremain.NewBP(tag, m), tag, &Args{Rules: []RuleArgs{{Exec: next}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ps[tag] = s
+			next = "jump " + tag
+		}
+		return s
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.", dns.TypeA)
+	if err := jumps(maxJumpDepth).Exec(context.Background(), query_context.NewContext(q)); err != nil {
+		t.Fatal(err)
+	}
+	qCtx := query_context.NewContext(q)
+	err := jumps(maxJumpDepth+1).Exec(context.Background(), qCtx)
+	if !errors.Is(err, ErrJumpDepthExceeded) {
+		t.Fatalf("want ErrJumpDepthExceeded, got %v", err)
+	}
+	if r := qCtx.R(); r == nil || r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("want SERVFAIL, got %v", r)
+	}
+}
+
+func Test_warnUnreachable(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+	_, err := NewSequence(NewBQ(m, zap.New(core)), []RuleArgs{
+		{Matches: []any{"$true"}, Exec: "accept"}, // conditional
+		{If: []any{"$true"}, Then: []RuleArgs{{Exec: "return"}, {Exec: "$nop"}}},
+		{Exec: "reject 3"},
+		{Exec: "$nop"},
+		{Exec: "$target"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for _, e := range logs.All() {
+		got = append(got, []string{e.ContextMap()["after"].(string), e.ContextMap()["rules"].([]any)[0].(string)})
+	}
+	want := [][]string{
+		{`r1.b0.r0 "return"`, "r1.b0.r1"},
+		{`r2 "reject 3"`, "r3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got warnings %v, want %v", got, want)
+	}
+}
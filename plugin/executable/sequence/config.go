@@ -19,23 +19,285 @@
 
 package sequence
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+)
 
 type RuleArgs struct {
-	Matches []string `yaml:"matches"`
-	Exec    string   `yaml:"exec"`
+	// Name of the rule in rule stats. Optional. Default is the position
+	// of the rule, e.g. "r1", or "r1.b0.r2" for a rule in a block.
+	Name string `yaml:"name"`
+
+	// Matches are evaluated in order and all of them must be matched.
+	// An item is a match string (e.g. "qname $set"), or a group that is a
+	// map with one of keys "any", "all", "!any" and "!all" and a list
+	// of items. Groups can be nested.
+	// An item can also be a map in the same form as Exec, with an
+	// optional bool key "reverse".
+	Matches []any `yaml:"matches"`
+
+	// Exec is an exec string (e.g. "forward 8.8.8.8"), or a map with
+	// key "tag" or "type", and key "args". If "args" of a "type" is a map
+	// or a list, an anonymous plugin of the type is created with "args"
+	// as its plugin args. Otherwise, "args" is used as the args string.
+	Exec any `yaml:"exec"`
+
+	// A rule can be an if block or a switch block instead of an exec.
+	// Matches of a block rule still apply to the whole block.
+
+	// If block. Rules in Then run if all items in If are matched.
+	// Otherwise, rules of the first matched Elif run, or rules in Else.
+	// If and Elif's If use the same item format as Matches.
+	If   []any      `yaml:"if"`
+	Then []RuleArgs `yaml:"then"`
+	Elif []ElifArgs `yaml:"elif"`
+	Else []RuleArgs `yaml:"else"`
+
+	// Switch block. Switch is the query attribute to switch on, one of
+	// "qtype", "client" and "mark". Rules of the first matched case run,
+	// or rules in Default. See CaseArgs.
+	Switch  string     `yaml:"switch"`
+	Cases   []CaseArgs `yaml:"cases"`
+	Default []RuleArgs `yaml:"default"`
+}
+
+type ElifArgs struct {
+	If   []any      `yaml:"if"`
+	Then []RuleArgs `yaml:"then"`
+}
+
+// CaseArgs is a case of a switch block. The case is matched if the
+// attribute matches any of the values in Case. Values are
+//   - qtype: type names (e.g. "AAAA") or numbers.
+//   - client: same as args of the client_ip matcher, e.g. "10.0.0.0/8",
+//     "$ip_set_tag" or "&ip_list_file".
+//   - mark: marks. The case is matched if the query has any of them.
+type CaseArgs struct {
+	Case []string   `yaml:"case"`
+	Then []RuleArgs `yaml:"then"`
+}
+
+const (
+	switchQtype  = "qtype"
+	switchClient = "client"
+	switchMark   = "mark"
+)
+
+func parseArgs(ra RuleArgs) (RuleConfig, error) {
+	rc := RuleConfig{Name: ra.Name}
+	var err error
+	if len(ra.Matches) > 0 {
+		if rc.Matches, err = parseMatchItems(ra.Matches); err != nil {
+			return RuleConfig{}, err
+		}
+	}
+	switch v := ra.Exec.(type) {
+	case nil:
+	case string:
+		rc.Tag, rc.Type, rc.Args = parseExec(v)
+	default:
+		sa, err := parseStructuredArgs(v)
+		if err != nil {
+			return RuleConfig{}, fmt.Errorf("invalid exec, %w", err)
+		}
+		if sa.Reverse {
+			return RuleConfig{}, errors.New("invalid exec, reverse is only valid for matches")
+		}
+		rc.Tag, rc.Type, rc.Args, rc.PluginArgs = sa.Tag, sa.Type, sa.argsString, sa.pluginArgs
+	}
+
+	block, err := parseBlock(ra)
+	if err != nil {
+		return RuleConfig{}, err
+	}
+	if block != nil && ra.Exec != nil {
+		return RuleConfig{}, errors.New("a rule cannot have both exec and a block")
+	}
+	rc.Block = block
+	return rc, nil
+}
+
+func parseRules(ras []RuleArgs) ([]RuleConfig, error) {
+	rcs := make([]RuleConfig, 0, len(ras))
+	for i, ra := range ras {
+		rc, err := parseArgs(ra)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule #%d, %w", i, err)
+		}
+		rcs = append(rcs, rc)
+	}
+	return rcs, nil
+}
+
+// walkRules calls f for each rule in rcs, including rules in blocks.
+func walkRules(rcs []RuleConfig, f func(rc RuleConfig)) {
+	for _, rc := range rcs {
+		f(rc)
+		if b := rc.Block; b != nil {
+			for _, br := range b.Branches {
+				walkRules(br.Then, f)
+			}
+			walkRules(b.Else, f)
+		}
+	}
+}
+
+func parseMatchItems(items []any) ([]MatchConfig, error) {
+	mcs := make([]MatchConfig, 0, len(items))
+	for i, v := range items {
+		mc, err := parseMatchItem(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match #%d, %w", i, err)
+		}
+		mcs = append(mcs, mc)
+	}
+	return mcs, nil
+}
+
+// parseBlock parses the if block or switch block of ra.
+// It returns nil if ra is not a block.
+func parseBlock(ra RuleArgs) (*BlockConfig, error) {
+	isIf := len(ra.If) > 0 || len(ra.Then) > 0 || len(ra.Elif) > 0 || len(ra.Else) > 0
+	isSwitch := len(ra.Switch) > 0 || len(ra.Cases) > 0 || len(ra.Default) > 0
+	switch {
+	case isIf && isSwitch:
+		return nil, errors.New("a rule cannot be both an if block and a switch block")
+	case isIf:
+		if len(ra.If) == 0 {
+			return nil, errors.New("if block without if")
+		}
+		b := new(BlockConfig)
+		branches := append([]ElifArgs{{If: ra.If, Then: ra.Then}}, ra.Elif...)
+		for i, ea := range branches {
+			if len(ea.If) == 0 {
+				return nil, fmt.Errorf("elif #%d without if", i-1)
+			}
+			var br BranchConfig
+			var err error
+			if br.If, err = parseMatchItems(ea.If); err != nil {
+				return nil, fmt.Errorf("invalid if of branch #%d, %w", i, err)
+			}
+			if br.Then, err = parseRules(ea.Then); err != nil {
+				return nil, fmt.Errorf("invalid then of branch #%d, %w", i, err)
+			}
+			b.Branches = append(b.Branches, br)
+		}
+		var err error
+		if b.Else, err = parseRules(ra.Else); err != nil {
+			return nil, fmt.Errorf("invalid else, %w", err)
+		}
+		return b, nil
+	case isSwitch:
+		switch ra.Switch {
+		case switchQtype, switchClient, switchMark:
+		default:
+			return nil, fmt.Errorf("invalid switch attribute %q", ra.Switch)
+		}
+		b := &BlockConfig{Switch: ra.Switch}
+		for i, ca := range ra.Cases {
+			if len(ca.Case) == 0 {
+				return nil, fmt.Errorf("case #%d has no value", i)
+			}
+			br := BranchConfig{Case: ca.Case}
+			var err error
+			if br.Then, err = parseRules(ca.Then); err != nil {
+				return nil, fmt.Errorf("invalid case #%d, %w", i, err)
+			}
+			b.Branches = append(b.Branches, br)
+		}
+		var err error
+		if b.Else, err = parseRules(ra.Default); err != nil {
+			return nil, fmt.Errorf("invalid default, %w", err)
+		}
+		return b, nil
+	}
+	return nil, nil
 }
 
-func parseArgs(ra RuleArgs) RuleConfig {
-	var rc RuleConfig
-	for _, s := range ra.Matches {
-		rc.Matches = append(rc.Matches, parseMatch(s))
+// structuredArgs is the map form of an exec or a match.
+type structuredArgs struct {
+	Tag     string `yaml:"tag"`
+	Type    string `yaml:"type"`
+	Args    any    `yaml:"args"`
+	Reverse bool   `yaml:"reverse"`
+
+	argsString string
+	pluginArgs any
+}
+
+func parseStructuredArgs(v any) (structuredArgs, error) {
+	var sa structuredArgs
+	if err := utils.WeakDecode(v, &sa); err != nil {
+		return sa, err
+	}
+	sa.Tag = strings.TrimPrefix(sa.Tag, "$")
+	if (len(sa.Tag) > 0) == (len(sa.Type) > 0) {
+		return sa, errors.New("one of tag and type must be set")
+	}
+	switch reflect.ValueOf(sa.Args).Kind() {
+	case reflect.Invalid:
+	case reflect.Map, reflect.Slice, reflect.Array:
+		if len(sa.Tag) > 0 {
+			return sa, errors.New("args of a plugin tag must be a string")
+		}
+		sa.pluginArgs = sa.Args
+	default:
+		sa.argsString = fmt.Sprint(sa.Args)
 	}
-	tag, typ, args := parseExec(ra.Exec)
-	rc.Tag = tag
-	rc.Type = typ
-	rc.Args = args
-	return rc
+	return sa, nil
+}
+
+// parseMatchItem parses a match string, a match map or a match group.
+// See RuleArgs.
+func parseMatchItem(v any) (MatchConfig, error) {
+	if s, ok := v.(string); ok {
+		return parseMatch(s), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map {
+		return MatchConfig{}, fmt.Errorf("a match must be a string or a map, got %v", v)
+	}
+	var key string
+	if rv.Len() == 1 {
+		key, _ = rv.MapKeys()[0].Interface().(string)
+	}
+	var mc MatchConfig
+	op, reverse := trimPrefixField(key, "!")
+	if op != "any" && op != "all" {
+		sa, err := parseStructuredArgs(v)
+		if err != nil {
+			return MatchConfig{}, err
+		}
+		mc.Tag, mc.Type, mc.Args, mc.PluginArgs, mc.Reverse = sa.Tag, sa.Type, sa.argsString, sa.pluginArgs, sa.Reverse
+		return mc, nil
+	}
+	mc.Reverse = reverse
+	k := rv.MapKeys()[0]
+
+	items := reflect.ValueOf(rv.MapIndex(k).Interface())
+	if items.Kind() != reflect.Slice || items.Len() == 0 {
+		return MatchConfig{}, fmt.Errorf("match group %q must be a non-empty list", key)
+	}
+	group := make([]MatchConfig, 0, items.Len())
+	for i := 0; i < items.Len(); i++ {
+		sub, err := parseMatchItem(items.Index(i).Interface())
+		if err != nil {
+			return MatchConfig{}, fmt.Errorf("%s #%d, %w", key, i, err)
+		}
+		group = append(group, sub)
+	}
+	if op == "any" {
+		mc.Any = group
+	} else {
+		mc.All = group
+	}
+	return mc, nil
 }
 
 func parseMatch(s string) MatchConfig {
@@ -68,10 +330,46 @@ func parseExec(s string) (tag string, typ string, args string) {
 }
 
 type RuleConfig struct {
+	Name    string        `yaml:"name"`
 	Matches []MatchConfig `yaml:"matches"`
 	Tag     string        `yaml:"tag"`
 	Type    string        `yaml:"type"`
 	Args    string        `yaml:"args"`
+
+	// PluginArgs, if not nil, is the args of an anonymous plugin of Type.
+	// Args is ignored.
+	PluginArgs any `yaml:"plugin_args"`
+
+	// Block, if not nil, makes this rule a block. Exec fields are ignored.
+	Block *BlockConfig `yaml:"block"`
+}
+
+// BlockConfig is an if block or a switch block.
+type BlockConfig struct {
+	// Switch is the attribute of a switch block. Empty for if blocks.
+	Switch string `yaml:"switch"`
+	// Branches are the if and elif branches, or the cases.
+	Branches []BranchConfig `yaml:"branches"`
+	// Else is the else branch, or the default case.
+	Else []RuleConfig `yaml:"else"`
+}
+
+type BranchConfig struct {
+	If   []MatchConfig `yaml:"if"`   // if blocks only
+	Case []string      `yaml:"case"` // switch blocks only
+	Then []RuleConfig  `yaml:"then"`
+}
+
+// String returns the condition of br in a short form.
+func (br BranchConfig) String() string {
+	if len(br.Case) > 0 {
+		return "case " + strings.Join(br.Case, " ")
+	}
+	ss := make([]string, 0, len(br.If))
+	for _, mc := range br.If {
+		ss = append(ss, mc.String())
+	}
+	return "if " + strings.Join(ss, ", ")
 }
 
 type MatchConfig struct {
@@ -79,6 +377,71 @@ type MatchConfig struct {
 	Type    string `yaml:"type"`
 	Args    string `yaml:"args"`
 	Reverse bool   `yaml:"reverse"`
+
+	// PluginArgs, if not nil, is the args of an anonymous plugin of Type.
+	// Args is ignored.
+	PluginArgs any `yaml:"plugin_args"`
+
+	// If Any or All is set, this is a group of matchers. The group is
+	// matched if any or all of its matchers are matched. Tag, Type and
+	// Args are ignored.
+	Any []MatchConfig `yaml:"any"`
+	All []MatchConfig `yaml:"all"`
+}
+
+// String returns mc in the format of a rule's match string.
+// Groups are formatted as "any(m1, m2)" or "all(m1, m2)".
+func (mc MatchConfig) String() string {
+	var s string
+	switch {
+	case len(mc.Any) > 0:
+		s = groupString("any", mc.Any)
+	case len(mc.All) > 0:
+		s = groupString("all", mc.All)
+	default:
+		s = quickString(mc.Tag, mc.Type, mc.Args)
+		if mc.PluginArgs != nil {
+			s += " (inline)"
+		}
+	}
+	if mc.Reverse {
+		s = "!" + s
+	}
+	return s
+}
+
+func groupString(op string, mcs []MatchConfig) string {
+	ss := make([]string, 0, len(mcs))
+	for _, mc := range mcs {
+		ss = append(ss, mc.String())
+	}
+	return op + "(" + strings.Join(ss, ", ") + ")"
+}
+
+// execString returns the exec of rc in the format of a rule's exec string.
+func (rc RuleConfig) execString() string {
+	if b := rc.Block; b != nil {
+		if len(b.Switch) > 0 {
+			return "switch " + b.Switch
+		}
+		return "if"
+	}
+	s := quickString(rc.Tag, rc.Type, rc.Args)
+	if rc.PluginArgs != nil {
+		s += " (inline)"
+	}
+	return s
+}
+
+func quickString(tag, typ, args string) string {
+	s := typ
+	if len(tag) > 0 {
+		s = "$" + tag
+	}
+	if len(args) > 0 {
+		s += " " + args
+	}
+	return s
 }
 
 func trimPrefixField(s, p string) (string, bool) {
@@ -79,3 +79,89 @@ func Test_parseMatch(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseMatchItem(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       any
+		want    string
+		wantErr bool
+	}{
+		{"string", " !$m1 a ", "!$m1 a", false},
+		{"any", map[string]any{"any": []any{"$a", "qtype 1"}}, "any($a, qtype 1)", false},
+		{"nested", map[string]any{"!all": []any{"$a", map[string]any{"any": []string{"$b", "!$c"}}}}, "!all($a, any($b, !$c))", false},
+		{"invalid op", map[string]any{"one": []any{"$a"}}, "", true},
+		{"empty group", map[string]any{"any": []any{}}, "", true},
+		{"two keys", map[string]any{"any": []any{"$a"}, "all": []any{"$b"}}, "", true},
+		{"invalid item", map[string]any{"any": []any{1}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMatchItem(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMatchItem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("parseMatchItem() = %v, want %v", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		ra      RuleArgs
+		want    RuleConfig
+		wantErr bool
+	}{
+		{"string", RuleArgs{Exec: "forward 8.8.8.8"}, RuleConfig{Type: "forward", Args: "8.8.8.8"}, false},
+		{"map", RuleArgs{Exec: map[string]any{"type": "reject", "args": 3}}, RuleConfig{Type: "reject", Args: "3"}, false},
+		{"tag", RuleArgs{Exec: map[string]any{"tag": "$f", "args": "a b"}}, RuleConfig{Tag: "f", Args: "a b"}, false},
+		{
+			"inline",
+			RuleArgs{
+				Matches: []any{map[string]any{"type": "qname", "args": []string{"a.com"}, "reverse": true}},
+				Exec:    map[string]any{"type": "forward", "args": map[string]any{"upstreams": []any{}}},
+			},
+			RuleConfig{
+				Matches:    []MatchConfig{{Type: "qname", PluginArgs: []string{"a.com"}, Reverse: true}},
+				Type:       "forward",
+				PluginArgs: map[string]any{"upstreams": []any{}},
+			},
+			false,
+		},
+		{"tag with plugin args", RuleArgs{Exec: map[string]any{"tag": "f", "args": []any{1}}}, RuleConfig{}, true},
+		{"tag and type", RuleArgs{Exec: map[string]any{"tag": "f", "type": "forward"}}, RuleConfig{}, true},
+		{"unknown key", RuleArgs{Exec: map[string]any{"type": "forward", "arg": "1"}}, RuleConfig{}, true},
+		{"reverse exec", RuleArgs{Exec: map[string]any{"type": "accept", "reverse": true}}, RuleConfig{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArgs(tt.ra)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseArgs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Args_ReferredTags(t *testing.T) {
+	a := &Args{Rules: []RuleArgs{
+		{Exec: map[string]any{"tag": "fwd"}},
+		{Matches: []any{map[string]any{"tag": "set"}, map[string]any{"any": []any{"$a", map[string]any{"all": []any{map[string]any{"tag": "b", "reverse": true}}}}}}, Exec: "accept"},
+		{Exec: "jump seq1"},
+		{
+			If:   []any{map[string]any{"tag": "c"}},
+			Then: []RuleArgs{{Exec: "goto seq2"}},
+			Else: []RuleArgs{{Exec: map[string]any{"type": "forward", "args": map[string]any{"upstreams": []any{}}}}},
+		},
+	}}
+	want := []string{"fwd", "set", "a", "b", "seq1", "c", "seq2"}
+	if got := a.ReferredTags(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReferredTags() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,447 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+const explainTimeout = time.Second * 5
+
+// ruleInfo describes the rule that a ChainNode was built from.
+// It is used by explain mode.
+type ruleInfo struct {
+	s       *Sequence
+	block   string // Name of the block branch, empty for top-level rules.
+	idx     int    // Index in its block branch or in the sequence.
+	matches []string
+	exec    string
+}
+
+// ExplainStep records a visit of a rule.
+type ExplainStep struct {
+	Sequence string        `json:"sequence,omitempty"`
+	Block    string        `json:"block,omitempty"` // e.g. "r1.b0" for rule #1's first branch.
+	Rule     int           `json:"rule"`
+	Matches  []MatchResult `json:"matches,omitempty"`
+	Matched  bool          `json:"matched"`
+	Exec     string        `json:"exec,omitempty"`   // Empty if the rule was not matched.
+	Branch   string        `json:"branch,omitempty"` // The branch taken by a block.
+	Error    string        `json:"error,omitempty"`
+
+	// Effects of the exec. For a recursive executable (e.g. jump), the
+	// effects are recorded until it runs the next rule.
+	Response     *ResponseSummary `json:"response,omitempty"` // Set if the response was changed.
+	MarksAdded   []uint32         `json:"marks_added,omitempty"`
+	MarksRemoved []uint32         `json:"marks_removed,omitempty"`
+}
+
+// MatchResult is the result of a matcher. Matchers after a failed
+// matcher are not evaluated and not recorded.
+type MatchResult struct {
+	Matcher string `json:"matcher"`
+	Result  bool   `json:"result"`
+	Error   string `json:"error,omitempty"`
+}
+
+type ResponseSummary struct {
+	Rcode   string   `json:"rcode"`
+	Answers []string `json:"answers,omitempty"`
+	Ns      []string `json:"ns,omitempty"`
+	Extra   []string `json:"extra,omitempty"`
+}
+
+func summarizeResponse(r *dns.Msg) *ResponseSummary {
+	if r == nil {
+		return nil
+	}
+	s := &ResponseSummary{Rcode: dns.RcodeToString[r.Rcode]}
+	rrStrings := func(rrs []dns.RR) []string {
+		var ss []string
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			ss = append(ss, rr.String())
+		}
+		return ss
+	}
+	s.Answers = rrStrings(r.Answer)
+	s.Ns = rrStrings(r.Ns)
+	s.Extra = rrStrings(r.Extra)
+	return s
+}
+
+func (s *ResponseSummary) equal(o *ResponseSummary) bool {
+	if s == nil || o == nil {
+		return s == o
+	}
+	return s.Rcode == o.Rcode &&
+		slices.Equal(s.Answers, o.Answers) &&
+		slices.Equal(s.Ns, o.Ns) &&
+		slices.Equal(s.Extra, o.Extra)
+}
+
+// explainer records the rule evaluation of a query. It is passed to
+// ChainWalker by context. See withExplainer.
+// Executables may run rules concurrently (e.g. fallback), in which case
+// steps from different branches are interleaved.
+type explainer struct {
+	stub *dns.Msg // may be nil
+
+	m     sync.Mutex
+	steps []*ExplainStep
+
+	// The step whose exec effects are not recorded yet.
+	pending      *ExplainStep
+	pendingQCtx  *query_context.Context
+	pendingResp  *ResponseSummary
+	pendingMarks []uint32
+}
+
+type explainerCtxKey struct{}
+
+func withExplainer(ctx context.Context, e *explainer) context.Context {
+	return context.WithValue(ctx, explainerCtxKey{}, e)
+}
+
+func explainerFromCtx(ctx context.Context) *explainer {
+	e, _ := ctx.Value(explainerCtxKey{}).(*explainer)
+	return e
+}
+
+// IsExplaining reports whether the query is running in explain mode.
+// Plugins that keep state across queries (e.g. caches, logs and stats)
+// should not change it with explain queries, because their responses
+// may come from a stub.
+func IsExplaining(ctx context.Context) bool {
+	return explainerFromCtx(ctx) != nil
+}
+
+// StubResponse returns a stub response for q if the query is running in
+// explain mode with a stub response. Otherwise, it returns nil.
+// Plugins that send queries to upstreams should use the stub response
+// instead, if it is not nil.
+func StubResponse(ctx context.Context, q *dns.Msg) *dns.Msg {
+	e := explainerFromCtx(ctx)
+	if e == nil || e.stub == nil {
+		return nil
+	}
+	r := e.stub.Copy()
+	r.Id = q.Id
+	r.Response = true
+	r.Opcode = q.Opcode
+	r.RecursionDesired = q.RecursionDesired
+	r.Question = slices.Clone(q.Question)
+	return r
+}
+
+// visit starts a step for node n.
+func (e *explainer) visit(n *ChainNode) *ExplainStep {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.settle()
+	st := new(ExplainStep)
+	if ri := n.rule; ri != nil {
+		st.Sequence = ri.s.tag
+		st.Block = ri.block
+		st.Rule = ri.idx
+	} else {
+		st.Rule = -1
+	}
+	e.steps = append(e.steps, st)
+	return st
+}
+
+func (e *explainer) match(st *ExplainStep, n *ChainNode, i int, ok bool, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	mr := MatchResult{Result: ok}
+	if ri := n.rule; ri != nil && i < len(ri.matches) {
+		mr.Matcher = ri.matches[i]
+	}
+	if err != nil {
+		mr.Error = err.Error()
+	}
+	st.Matches = append(st.Matches, mr)
+}
+
+// blockMatch records a matcher result of a branch of the running block.
+func (e *explainer) blockMatch(matcher string, ok bool, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if st := e.pending; st != nil {
+		mr := MatchResult{Matcher: matcher, Result: ok}
+		if err != nil {
+			mr.Error = err.Error()
+		}
+		st.Matches = append(st.Matches, mr)
+	}
+}
+
+// branch records the branch taken by the running block.
+func (e *explainer) branch(name string) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if st := e.pending; st != nil {
+		st.Branch = name
+	}
+}
+
+// beforeExec records the state before the exec of st.
+func (e *explainer) beforeExec(st *ExplainStep, n *ChainNode, qCtx *query_context.Context) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.settle()
+	st.Matched = true
+	if ri := n.rule; ri != nil {
+		st.Exec = ri.exec
+	} else {
+		st.Exec = n.Name
+	}
+	e.pending = st
+	e.pendingQCtx = qCtx
+	e.pendingResp = summarizeResponse(qCtx.R())
+	e.pendingMarks = qCtx.Marks()
+}
+
+// afterExec records the effects of the exec of st.
+// Errors from the rules that a recursive executable ran are recorded by
+// their own steps.
+func (e *explainer) afterExec(st *ExplainStep, err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.pending != st {
+		return
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	e.settle()
+}
+
+// settle records the effects of the pending step, if any.
+// Caller must hold e.m.
+func (e *explainer) settle() {
+	st := e.pending
+	if st == nil {
+		return
+	}
+	qCtx := e.pendingQCtx
+	e.pending = nil
+	e.pendingQCtx = nil
+	if r := summarizeResponse(qCtx.R()); !r.equal(e.pendingResp) {
+		if r == nil {
+			r = &ResponseSummary{} // The response was removed.
+		}
+		st.Response = r
+	}
+	marks := qCtx.Marks()
+	for _, m := range marks {
+		if _, ok := slices.BinarySearch(e.pendingMarks, m); !ok {
+			st.MarksAdded = append(st.MarksAdded, m)
+		}
+	}
+	for _, m := range e.pendingMarks {
+		if _, ok := slices.BinarySearch(marks, m); !ok {
+			st.MarksRemoved = append(st.MarksRemoved, m)
+		}
+	}
+}
+
+// result returns a copy of all recorded steps.
+func (e *explainer) result() []ExplainStep {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.settle()
+	steps := make([]ExplainStep, 0, len(e.steps))
+	for _, st := range e.steps {
+		steps = append(steps, *st)
+	}
+	return steps
+}
+
+// ExplainArgs is the request of the explain api.
+type ExplainArgs struct {
+	Qname      string `json:"qname"`
+	Qtype      string `json:"qtype"`  // Type name or number. Default is A.
+	Client     string `json:"client"` // Client ip. Optional.
+	ServerName string `json:"server_name"`
+	URLPath    string `json:"url_path"`
+
+	// Stub, if set, is used as the response of upstreams instead of
+	// sending the query to them.
+	Stub *ExplainStub `json:"stub"`
+}
+
+type ExplainStub struct {
+	Rcode   string   `json:"rcode"`   // Rcode name or number. Default is NOERROR.
+	Answers []string `json:"answers"` // RRs in zone file format.
+}
+
+// ExplainResult is the response of the explain api.
+type ExplainResult struct {
+	Steps    []ExplainStep    `json:"steps"`
+	Response *ResponseSummary `json:"response,omitempty"` // Nil if the sequence has no response.
+	Marks    []uint32         `json:"marks,omitempty"`
+	Upstream string           `json:"upstream,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Elapsed  string           `json:"elapsed"`
+}
+
+func parseRcode(s string) (int, error) {
+	if len(s) == 0 {
+		return dns.RcodeSuccess, nil
+	}
+	if n, ok := dns.StringToRcode[strings.ToUpper(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 0xFFF {
+		return 0, fmt.Errorf("invalid rcode %s", s)
+	}
+	return n, nil
+}
+
+func parseQtype(s string) (uint16, error) {
+	if len(s) == 0 {
+		return dns.TypeA, nil
+	}
+	if t, ok := dns.StringToType[strings.ToUpper(s)]; ok {
+		return t, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid qtype %s", s)
+	}
+	return uint16(n), nil
+}
+
+// newQuery builds a query context from args.
+func (args *ExplainArgs) newQuery() (*query_context.Context, *dns.Msg, error) {
+	if len(args.Qname) == 0 {
+		return nil, nil, errors.New("missing qname")
+	}
+	qtype, err := parseQtype(args.Qtype)
+	if err != nil {
+		return nil, nil, err
+	}
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(args.Qname), qtype)
+	qCtx := query_context.NewContext(q)
+	qCtx.ServerMeta.ServerName = args.ServerName
+	qCtx.ServerMeta.UrlPath = args.URLPath
+	if len(args.Client) > 0 {
+		addr, err := netip.ParseAddr(args.Client)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid client ip, %w", err)
+		}
+		qCtx.ServerMeta.ClientAddr = addr
+	}
+
+	var stub *dns.Msg
+	if args.Stub != nil {
+		rcode, err := parseRcode(args.Stub.Rcode)
+		if err != nil {
+			return nil, nil, err
+		}
+		stub = new(dns.Msg)
+		stub.Rcode = rcode
+		for _, s := range args.Stub.Answers {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid stub answer %q, %w", s, err)
+			}
+			if rr != nil {
+				stub.Answer = append(stub.Answer, rr)
+			}
+		}
+	}
+	return qCtx, stub, nil
+}
+
+// Explain runs a query in explain mode and records how rules were
+// evaluated. Upstreams that support stub responses use the stub instead
+// (see StubResponse), and plugins that keep state skip their updates
+// (see IsExplaining). Rule stats don't count explain queries.
+func (s *Sequence) Explain(ctx context.Context, args *ExplainArgs) (*ExplainResult, error) {
+	qCtx, stub, err := args.newQuery()
+	if err != nil {
+		return nil, err
+	}
+	e := &explainer{stub: stub}
+	start := time.Now()
+	execErr := s.Exec(withExplainer(ctx, e), qCtx)
+
+	res := &ExplainResult{
+		Steps:    e.result(),
+		Response: summarizeResponse(qCtx.R()),
+		Marks:    qCtx.Marks(),
+		Upstream: qCtx.Upstream(),
+		Elapsed:  time.Since(start).String(),
+	}
+	if execErr != nil {
+		res.Error = execErr.Error()
+	}
+	return res, nil
+}
+
+func (s *Sequence) api(bp *coremain.BP) *chi.Mux {
+	r := chi.NewRouter()
+	r.Mount("/rules", s.rulesApi())
+	r.Post("/explain", func(w http.ResponseWriter, req *http.Request) {
+		args := new(ExplainArgs)
+		if err := json.NewDecoder(req.Body).Decode(args); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body, %s", err), http.StatusBadRequest)
+			return
+		}
+		if !bp.EnterQuery() {
+			http.Error(w, "plugin is closing", http.StatusServiceUnavailable)
+			return
+		}
+		defer bp.ExitQuery()
+
+		ctx, cancel := context.WithTimeout(req.Context(), explainTimeout)
+		defer cancel()
+		res, err := s.Explain(ctx, args)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+	return r
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func Test_Sequence_Explain(t *testing.T) {
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+	ps["mark"] = ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+		qCtx.SetMark(1)
+		return nil
+	})
+	var gotStub *dns.Msg
+	ps["upstream"] = ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+		gotStub = StubResponse(ctx, qCtx.Q())
+		qCtx.SetResponse(gotStub)
+		return nil
+	})
+
+	s2, err := newSequence(coremain.NewBP("seq2", m), "seq2", &Args{Rules: []RuleArgs{
+		{Exec: "$mark"},
+		{Exec: "return"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps["seq2"] = s2
+	s, err := newSequence(coremain.NewBP("main", m), "main", &Args{Rules: []RuleArgs{
+		{Matches: []any{"$true", "!$true"}, Exec: "$err"},
+		{Exec: "jump seq2"},
+		{Exec: "$upstream"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.Explain(context.Background(), &ExplainArgs{
+		Qname:  "example.com",
+		Qtype:  "AAAA",
+		Client: "127.0.0.1",
+		Stub:   &ExplainStub{Rcode: "NXDOMAIN"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != "" {
+		t.Fatalf("unexpected error %s", res.Error)
+	}
+	if gotStub == nil || gotStub.Question[0].Qtype != dns.TypeAAAA {
+		t.Fatalf("unexpected stub response %v", gotStub)
+	}
+
+	want := []ExplainStep{
+		{Sequence: "main", Rule: 0, Matches: []MatchResult{{Matcher: "$true", Result: true}, {Matcher: "!$true", Result: false}}},
+		{Sequence: "main", Rule: 1, Matched: true, Exec: "jump seq2"},
+		{Sequence: "seq2", Rule: 0, Matched: true, Exec: "$mark", MarksAdded: []uint32{1}},
+		{Sequence: "seq2", Rule: 1, Matched: true, Exec: "return"},
+		{Sequence: "main", Rule: 2, Matched: true, Exec: "$upstream", Response: &ResponseSummary{Rcode: "NXDOMAIN"}},
+	}
+	if !reflect.DeepEqual(res.Steps, want) {
+		t.Fatalf("Explain() steps = %+v, want %+v", res.Steps, want)
+	}
+	if res.Response == nil || res.Response.Rcode != "NXDOMAIN" || !reflect.DeepEqual(res.Marks, []uint32{1}) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	if _, err := s.Explain(context.Background(), &ExplainArgs{Qname: "example.com", Qtype: "no_such_type"}); err == nil {
+		t.Fatal("invalid qtype should be rejected")
+	}
+}
+
+func Test_Sequence_Explain_block(t *testing.T) {
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+	s, err := newSequence(coremain.NewBP("main", m), "main", &Args{Rules: []RuleArgs{
+		{If: []any{"$false"}, Then: []RuleArgs{{Exec: "$err"}}, Else: []RuleArgs{{Exec: "reject 2"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Explain(context.Background(), &ExplainArgs{Qname: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExplainStep{
+		{Sequence: "main", Rule: 0, Matched: true, Exec: "if", Branch: "else", Matches: []MatchResult{{Matcher: "$false"}}},
+		{Sequence: "main", Block: "r0.else", Rule: 0, Matched: true, Exec: "reject 2", Response: &ResponseSummary{Rcode: "SERVFAIL"}},
+	}
+	if !reflect.DeepEqual(res.Steps, want) {
+		t.Fatalf("Explain() steps = %+v, want %+v", res.Steps, want)
+	}
+}
@@ -65,6 +65,11 @@ type Args struct {
 	AlwaysStandby bool `yaml:"always_standby"`
 }
 
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	return []string{a.Primary, a.Secondary}
+}
+
 func Init(bp *coremain.BP, args any) (any, error) {
 	return newFallbackPlugin(bp, args.(*Args))
 }
@@ -0,0 +1,352 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package parallel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const PluginType = "parallel"
+
+const defaultGrace = time.Millisecond * 100
+
+// Policies that pick the result.
+const (
+	// The first branch that has a response.
+	policyFirst = "first"
+	// The first branch that has an answer of the query type. If no branch
+	// has one, the first branch that has a response.
+	policyWanted = "wanted"
+	// Branches are in priority order. A response of branch #i is used if
+	// all previous branches failed, or i*grace has elapsed since the start.
+	policyPriority = "priority"
+	// Wait for all branches. A/AAAA answers of all NOERROR responses are
+	// merged into the response of the first succeeded branch in order.
+	// Responses that are not NOERROR or have a CNAME answer are not merged,
+	// neither as the picked response nor as the others.
+	// For other query types, it is the same as "priority" without grace.
+	policyMerge = "merge"
+)
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Tags of executables (e.g. sequences) that run in parallel. Required.
+	Tags []string `yaml:"tags"`
+
+	// Policy is one of "first", "wanted", "priority" and "merge".
+	// Default is "first".
+	Policy string `yaml:"policy"`
+
+	// Grace in milliseconds for policy "priority". Default is 100.
+	Grace int `yaml:"grace"`
+}
+
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	return a.Tags
+}
+
+var _ sequence.Executable = (*parallel)(nil)
+
+type parallel struct {
+	logger   *zap.Logger
+	branches []sequence.Executable
+	tags     []string
+	policy   string
+	grace    time.Duration
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return newParallel(bp, args.(*Args))
+}
+
+func newParallel(bp *coremain.BP, args *Args) (*parallel, error) {
+	if len(args.Tags) == 0 {
+		return nil, errors.New("args missing tags")
+	}
+	p := &parallel{
+		logger: bp.L(),
+		tags:   args.Tags,
+		policy: args.Policy,
+		grace:  time.Duration(args.Grace) * time.Millisecond,
+	}
+	for _, tag := range args.Tags {
+		e := sequence.ToExecutable(bp.M().GetPlugin(tag))
+		if e == nil {
+			return nil, fmt.Errorf("can not find executable %s", tag)
+		}
+		p.branches = append(p.branches, e)
+	}
+	switch p.policy {
+	case "":
+		p.policy = policyFirst
+	case policyFirst, policyWanted, policyPriority, policyMerge:
+	default:
+		return nil, fmt.Errorf("invalid policy %q", args.Policy)
+	}
+	if p.grace <= 0 {
+		p.grace = defaultGrace
+	}
+	return p, nil
+}
+
+type result struct {
+	i    int
+	qCtx *query_context.Context
+	err  error
+}
+
+func (r *result) ok() bool {
+	return r != nil && r.err == nil && r.qCtx.R() != nil
+}
+
+var ErrFailed = errors.New("no branch has a response")
+
+// Exec runs all branches on copies of qCtx. The state of the picked
+// branch (response, marks, etc.) is copied back to qCtx. Branches that
+// are still running are cancelled once the result is picked.
+func (p *parallel) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resChan := make(chan *result, len(p.branches))
+	for i, e := range p.branches {
+		bCtx := qCtx.Copy()
+		go func(i int, e sequence.Executable) {
+			err := e.Exec(ctx, bCtx)
+			resChan <- &result{i: i, qCtx: bCtx, err: err}
+		}(i, e)
+	}
+
+	start := time.Now()
+	results := make([]*result, len(p.branches))
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for n := 0; ; {
+		var picked *result
+		if n == len(p.branches) {
+			picked = p.pickFinal(results)
+		} else {
+			var wait time.Duration
+			picked, wait = p.pick(results, time.Since(start))
+			if wait > 0 {
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.NewTimer(wait)
+				timerC = timer.C
+			}
+		}
+		if picked != nil {
+			p.apply(qCtx, picked, results)
+			return nil
+		}
+		if n == len(p.branches) {
+			errs := []error{ErrFailed}
+			for _, r := range results {
+				if r.err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", p.tags[r.i], r.err))
+				}
+			}
+			return errors.Join(errs...)
+		}
+
+		select {
+		case r := <-resChan:
+			n++
+			results[r.i] = r
+			if r.err != nil {
+				p.logger.Debug("branch error", zap.String("branch", p.tags[r.i]), qCtx.InfoField(), zap.Error(r.err))
+			}
+		case <-timerC:
+			timerC = nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// pick picks a result while some branches are still running.
+// If it returns nil, it may return a duration to wait before the
+// next pick.
+func (p *parallel) pick(results []*result, elapsed time.Duration) (*result, time.Duration) {
+	switch p.policy {
+	case policyFirst:
+		for _, r := range results {
+			if r.ok() {
+				return r, 0
+			}
+		}
+	case policyWanted:
+		for _, r := range results {
+			if r.ok() && hasWantedAnswer(r.qCtx) {
+				return r, 0
+			}
+		}
+	case policyPriority:
+		for i, r := range results {
+			if r.ok() { // All previous branches failed.
+				return r, 0
+			}
+			if r == nil { // Still running.
+				// Wait for it unless a later branch's grace is over.
+				for j := i + 1; j < len(results); j++ {
+					if results[j].ok() {
+						if wait := time.Duration(j)*p.grace - elapsed; wait > 0 {
+							return nil, wait
+						}
+						return results[j], 0
+					}
+				}
+				return nil, 0
+			}
+		}
+	case policyMerge:
+		if !isAddrQuery(results) {
+			// Same as priority without grace.
+			for _, r := range results {
+				if r == nil {
+					return nil, 0
+				}
+				if r.ok() {
+					return r, 0
+				}
+			}
+		}
+	}
+	return nil, 0
+}
+
+// pickFinal picks a result after all branches are done.
+func (p *parallel) pickFinal(results []*result) *result {
+	if p.policy == policyWanted {
+		for _, r := range results {
+			if r.ok() && hasWantedAnswer(r.qCtx) {
+				return r
+			}
+		}
+	}
+	for _, r := range results {
+		if r.ok() {
+			return r
+		}
+	}
+	return nil
+}
+
+// apply copies the state of picked to qCtx. For policy "merge", answers
+// of other NOERROR results are merged into the response. Responses with
+// a CNAME are not merged, because their addresses belong to the CNAME
+// target.
+func (p *parallel) apply(qCtx *query_context.Context, picked *result, results []*result) {
+	picked.qCtx.CopyTo(qCtx)
+	if p.policy != policyMerge {
+		return
+	}
+	qtype := qCtx.Q().Question[0].Qtype
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return
+	}
+	r := qCtx.R()
+	if r.Rcode != dns.RcodeSuccess || hasCNAME(r) {
+		return
+	}
+	seen := make(map[netip.Addr]struct{})
+	addAddr := func(rr dns.RR) bool {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A.To4())
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+		default:
+			return false
+		}
+		if _, dup := seen[addr]; dup {
+			return false
+		}
+		seen[addr] = struct{}{}
+		return true
+	}
+	for _, rr := range r.Answer {
+		addAddr(rr)
+	}
+	for _, o := range results {
+		if o == picked || !o.ok() || o.qCtx.R().Rcode != dns.RcodeSuccess || hasCNAME(o.qCtx.R()) {
+			continue
+		}
+		for _, rr := range o.qCtx.R().Answer {
+			if rr.Header().Rrtype == qtype && addAddr(rr) {
+				rr := dns.Copy(rr)
+				rr.Header().Name = r.Question[0].Name
+				r.Answer = append(r.Answer, rr)
+			}
+		}
+	}
+}
+
+func hasCNAME(r *dns.Msg) bool {
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			return true
+		}
+	}
+	return false
+}
+
+func hasWantedAnswer(qCtx *query_context.Context) bool {
+	question := qCtx.Q().Question[0]
+	for _, rr := range qCtx.R().Answer {
+		h := rr.Header()
+		if h.Rrtype == question.Qtype && h.Class == question.Qclass {
+			return true
+		}
+	}
+	return false
+}
+
+// isAddrQuery reports whether the query of results is an A or AAAA query.
+func isAddrQuery(results []*result) bool {
+	for _, r := range results {
+		if r != nil {
+			qtype := r.qCtx.Q().Question[0].Qtype
+			return qtype == dns.TypeA || qtype == dns.TypeAAAA
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package parallel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+// branch returns an executable that sets a response with answers ips
+// after delay. An item of ips that ends with "." is a CNAME target.
+// If ips is nil, it returns an error. cancelled is closed if ctx is
+// cancelled before delay.
+func branch(delay time.Duration, cancelled chan struct{}, ips ...string) sequence.Executable {
+	return sequence.ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			if cancelled != nil {
+				close(cancelled)
+			}
+			return ctx.Err()
+		}
+		if ips == nil {
+			return errors.New("branch err")
+		}
+		r := new(dns.Msg)
+		r.SetReply(qCtx.Q())
+		for _, ip := range ips {
+			if strings.HasSuffix(ip, ".") {
+				r.Answer = append(r.Answer, &dns.CNAME{
+					Hdr:    dns.RR_Header{Name: "example.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+					Target: ip,
+				})
+				continue
+			}
+			r.Answer = append(r.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   net.ParseIP(ip),
+			})
+		}
+		qCtx.SetResponse(r)
+		return nil
+	})
+}
+
+// withRcode returns an executable that runs e and sets rcode to its
+// response.
+func withRcode(rcode int, e sequence.Executable) sequence.Executable {
+	return sequence.ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+		if err := e.Exec(ctx, qCtx); err != nil {
+			return err
+		}
+		qCtx.R().Rcode = rcode
+		return nil
+	})
+}
+
+func Test_parallel(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		policy   string
+		grace    int
+		branches []sequence.Executable
+		wantErr  bool
+		wantIPs  []string
+	}{
+		{"first", "", 0, []sequence.Executable{branch(50*ms, nil, "1.1.1.1"), branch(0, nil, "2.2.2.2")}, false, []string{"2.2.2.2"}},
+		{"first skip err", policyFirst, 0, []sequence.Executable{branch(0, nil), branch(10*ms, nil, "2.2.2.2")}, false, []string{"2.2.2.2"}},
+		{"all err", policyFirst, 0, []sequence.Executable{branch(0, nil), branch(0, nil)}, true, nil},
+		{"wanted", policyWanted, 0, []sequence.Executable{branch(0, nil, []string{}...), branch(10*ms, nil, "2.2.2.2")}, false, []string{"2.2.2.2"}},
+		{"wanted fallback", policyWanted, 0, []sequence.Executable{branch(0, nil, []string{}...), branch(10*ms, nil)}, false, []string{}},
+		{"priority wait", policyPriority, 200, []sequence.Executable{branch(30*ms, nil, "1.1.1.1"), branch(0, nil, "2.2.2.2")}, false, []string{"1.1.1.1"}},
+		{"priority grace", policyPriority, 20, []sequence.Executable{branch(time.Second, nil, "1.1.1.1"), branch(0, nil, "2.2.2.2")}, false, []string{"2.2.2.2"}},
+		{"priority err", policyPriority, 1000, []sequence.Executable{branch(0, nil), branch(10*ms, nil, "2.2.2.2")}, false, []string{"2.2.2.2"}},
+		{"merge", policyMerge, 0, []sequence.Executable{branch(20*ms, nil, "1.1.1.1", "2.2.2.2"), branch(0, nil, "2.2.2.2", "3.3.3.3"), branch(0, nil)}, false, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}},
+		{"merge cname", policyMerge, 0, []sequence.Executable{branch(0, nil, "cdn.example.", "1.1.1.1"), branch(0, nil, "2.2.2.2")}, false, []string{"cdn.example.", "1.1.1.1"}},
+		{"merge skip cname", policyMerge, 0, []sequence.Executable{branch(0, nil, "1.1.1.1"), branch(0, nil, "cdn.example.", "2.2.2.2")}, false, []string{"1.1.1.1"}},
+		{"merge noerror only", policyMerge, 0, []sequence.Executable{branch(0, nil, "1.1.1.1"), withRcode(dns.RcodeServerFailure, branch(0, nil, "2.2.2.2"))}, false, []string{"1.1.1.1"}},
+		{"merge into nxdomain", policyMerge, 0, []sequence.Executable{withRcode(dns.RcodeNameError, branch(0, nil, []string{}...)), branch(0, nil, "2.2.2.2")}, false, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := make(map[string]any)
+			var tags []string
+			for i, e := range tt.branches {
+				tag := string(rune('a' + i))
+				ps[tag] = e
+				tags = append(tags, tag)
+			}
+			bp := coremain.NewBP("p", coremain.NewTestMosdnsWithPlugins(ps))
+			p, err := newParallel(bp, &Args{Tags: tags, Policy: tt.policy, Grace: tt.grace})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := new(dns.Msg)
+			q.SetQuestion("example.", dns.TypeA)
+			qCtx := query_context.NewContext(q)
+			err = p.Exec(context.Background(), qCtx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var ips []string
+			for _, rr := range qCtx.R().Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					ips = append(ips, rr.A.String())
+				case *dns.CNAME:
+					ips = append(ips, rr.Target)
+				}
+			}
+			if len(ips) != len(tt.wantIPs) {
+				t.Fatalf("got answers %v, want %v", ips, tt.wantIPs)
+			}
+			for i := range ips {
+				if ips[i] != tt.wantIPs[i] {
+					t.Fatalf("got answers %v, want %v", ips, tt.wantIPs)
+				}
+			}
+		})
+	}
+}
+
+func Test_parallel_cancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	ps := map[string]any{
+		"slow": branch(time.Second, cancelled, "1.1.1.1"),
+		"fast": branch(0, nil, "2.2.2.2"),
+	}
+	bp := coremain.NewBP("p", coremain.NewTestMosdnsWithPlugins(ps))
+	p, err := newParallel(bp, &Args{Tags: []string{"slow", "fast"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.", dns.TypeA)
+	if err := p.Exec(context.Background(), query_context.NewContext(q)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Millisecond * 500):
+		t.Fatal("losing branch was not cancelled")
+	}
+}
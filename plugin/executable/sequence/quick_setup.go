@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"go.uber.org/zap"
+	"sort"
 	"sync"
 )
 
@@ -57,6 +58,14 @@ type ExecQuickSetupFunc func(bq BQ, args string) (any, error)
 // MatchQuickSetupFunc configures a Matcher with a simple string args.
 type MatchQuickSetupFunc func(bq BQ, args string) (Matcher, error)
 
+// QuickSetupInfo describes a registered quick setup type.
+type QuickSetupInfo struct {
+	Type string `json:"type"`
+	// Format is a one-line usage of this type in a rule,
+	// e.g. "reject [rcode]". Can be empty. See RegQuickSetupFormat.
+	Format string `json:"format"`
+}
+
 var execQuickSetupReg struct {
 	sync.RWMutex
 	m map[string]ExecQuickSetupFunc
@@ -67,6 +76,11 @@ var matchQuickSetupReg struct {
 	m map[string]MatchQuickSetupFunc
 }
 
+var quickSetupFormatReg struct {
+	sync.RWMutex
+	m map[string]string
+}
+
 func RegExecQuickSetup(typ string, f ExecQuickSetupFunc) error {
 	execQuickSetupReg.Lock()
 	defer execQuickSetupReg.Unlock()
@@ -94,6 +108,18 @@ func GetExecQuickSetup(typ string) ExecQuickSetupFunc {
 	return execQuickSetupReg.m[typ]
 }
 
+// ListExecQuickSetups returns all registered exec quick setup types, sorted by type.
+func ListExecQuickSetups() []QuickSetupInfo {
+	execQuickSetupReg.RLock()
+	defer execQuickSetupReg.RUnlock()
+	l := make([]QuickSetupInfo, 0, len(execQuickSetupReg.m))
+	for typ := range execQuickSetupReg.m {
+		l = append(l, QuickSetupInfo{Type: typ, Format: quickSetupFormat(typ)})
+	}
+	sortQuickSetupInfo(l)
+	return l
+}
+
 func RegMatchQuickSetup(typ string, f MatchQuickSetupFunc) error {
 	matchQuickSetupReg.Lock()
 	defer matchQuickSetupReg.Unlock()
@@ -120,3 +146,37 @@ func GetMatchQuickSetup(typ string) MatchQuickSetupFunc {
 	defer matchQuickSetupReg.RUnlock()
 	return matchQuickSetupReg.m[typ]
 }
+
+// ListMatchQuickSetups returns all registered match quick setup types, sorted by type.
+func ListMatchQuickSetups() []QuickSetupInfo {
+	matchQuickSetupReg.RLock()
+	defer matchQuickSetupReg.RUnlock()
+	l := make([]QuickSetupInfo, 0, len(matchQuickSetupReg.m))
+	for typ := range matchQuickSetupReg.m {
+		l = append(l, QuickSetupInfo{Type: typ, Format: quickSetupFormat(typ)})
+	}
+	sortQuickSetupInfo(l)
+	return l
+}
+
+// RegQuickSetupFormat sets the one-line usage of quick setup type typ
+// (exec, match or both). It is optional and only used in
+// ListExecQuickSetups and ListMatchQuickSetups.
+func RegQuickSetupFormat(typ, format string) {
+	quickSetupFormatReg.Lock()
+	defer quickSetupFormatReg.Unlock()
+	if quickSetupFormatReg.m == nil {
+		quickSetupFormatReg.m = make(map[string]string)
+	}
+	quickSetupFormatReg.m[typ] = format
+}
+
+func quickSetupFormat(typ string) string {
+	quickSetupFormatReg.RLock()
+	defer quickSetupFormatReg.RUnlock()
+	return quickSetupFormatReg.m[typ]
+}
+
+func sortQuickSetupInfo(l []QuickSetupInfo) {
+	sort.Slice(l, func(i, j int) bool { return l[i].Type < l[j].Type })
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/pool"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"go.uber.org/zap"
+)
+
+const PluginType = "retry"
+
+const (
+	defaultAttempts   = 3
+	defaultBackoff    = time.Millisecond * 100
+	defaultMaxBackoff = time.Second
+)
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Exec is the tag of the executable (e.g. a sequence) to run. Required.
+	Exec string `yaml:"exec"`
+
+	// Attempts is the maximum number of attempts, including the first one.
+	// Default is 3.
+	Attempts int `yaml:"attempts"`
+
+	// Rcodes of responses that should be retried. By default, only
+	// errors are retried.
+	Rcodes []int `yaml:"rcodes"`
+
+	// Backoff in milliseconds before the first retry. It doubles after each
+	// retry, up to MaxBackoff. Default is 100.
+	Backoff int `yaml:"backoff"`
+
+	// MaxBackoff in milliseconds. Default is 1000.
+	MaxBackoff int `yaml:"max_backoff"`
+}
+
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	return []string{a.Exec}
+}
+
+var _ sequence.Executable = (*retry)(nil)
+
+type retry struct {
+	logger     *zap.Logger
+	exec       sequence.Executable
+	attempts   int
+	rcodes     map[int]struct{}
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return newRetry(bp, args.(*Args))
+}
+
+func newRetry(bp *coremain.BP, args *Args) (*retry, error) {
+	if len(args.Exec) == 0 {
+		return nil, errors.New("args missing exec")
+	}
+	e := sequence.ToExecutable(bp.M().GetPlugin(args.Exec))
+	if e == nil {
+		return nil, fmt.Errorf("can not find executable %s", args.Exec)
+	}
+	r := &retry{
+		logger:     bp.L(),
+		exec:       e,
+		attempts:   args.Attempts,
+		rcodes:     make(map[int]struct{}),
+		backoff:    time.Duration(args.Backoff) * time.Millisecond,
+		maxBackoff: time.Duration(args.MaxBackoff) * time.Millisecond,
+	}
+	for _, rcode := range args.Rcodes {
+		r.rcodes[rcode] = struct{}{}
+	}
+	if r.attempts <= 0 {
+		r.attempts = defaultAttempts
+	}
+	if r.backoff <= 0 {
+		r.backoff = defaultBackoff
+	}
+	if r.maxBackoff <= 0 {
+		r.maxBackoff = defaultMaxBackoff
+	}
+	if r.maxBackoff < r.backoff {
+		r.maxBackoff = r.backoff
+	}
+	return r, nil
+}
+
+// Exec runs the executable on a copy of qCtx until it succeeds, or attempts
+// are used up, or there is no time left before the deadline of ctx.
+// The state of the last attempt is copied back to qCtx.
+func (r *retry) Exec(ctx context.Context, qCtx *query_context.Context) error {
+	backoff := r.backoff
+	for i := 1; ; i++ {
+		aCtx := qCtx.Copy()
+		err := r.exec.Exec(ctx, aCtx)
+		if !r.shouldRetry(aCtx, err) || i >= r.attempts || !r.wait(ctx, backoff) {
+			aCtx.CopyTo(qCtx)
+			if err != nil && i > 1 {
+				return fmt.Errorf("failed after %d attempts, %w", i, err)
+			}
+			return err
+		}
+		r.logger.Debug("retrying", qCtx.InfoField(), zap.Int("attempt", i), zap.Error(err))
+		backoff = min(backoff*2, r.maxBackoff)
+	}
+}
+
+func (r *retry) shouldRetry(qCtx *query_context.Context, err error) bool {
+	if err != nil {
+		return true
+	}
+	if resp := qCtx.R(); resp != nil {
+		_, ok := r.rcodes[resp.Rcode]
+		return ok
+	}
+	return false
+}
+
+// wait waits for d. It returns false if ctx is done, or the deadline of ctx
+// is in d, so there is no time for another attempt.
+func (r *retry) wait(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if ddl, ok := ctx.Deadline(); ok && time.Until(ddl) <= d {
+		return false
+	}
+	timer := pool.GetTimer(d)
+	defer pool.ReleaseTimer(timer)
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
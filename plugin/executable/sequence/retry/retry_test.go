@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+// flaky returns an error or a response with rcodes[i] at the i-th call.
+// A negative rcode means an error.
+func flaky(calls *int, rcodes ...int) sequence.Executable {
+	return sequence.ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+		rcode := rcodes[min(*calls, len(rcodes)-1)]
+		*calls++
+		if rcode < 0 {
+			return errors.New("flaky err")
+		}
+		r := new(dns.Msg)
+		r.SetRcode(qCtx.Q(), rcode)
+		qCtx.SetResponse(r)
+		return nil
+	})
+}
+
+func Test_retry(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      Args
+		rcodes    []int
+		timeout   time.Duration
+		wantCalls int
+		wantErr   bool
+		wantRcode int
+	}{
+		{"success", Args{}, []int{0}, 0, 1, false, 0},
+		{"retry err", Args{}, []int{-1, -1, 0}, 0, 3, false, 0},
+		{"attempts", Args{Attempts: 2}, []int{-1}, 0, 2, true, 0},
+		{"rcode not retried", Args{}, []int{2, 0}, 0, 1, false, 2},
+		{"retry rcode", Args{Rcodes: []int{2}}, []int{2, 2, 3}, 0, 3, false, 3},
+		{"last rcode", Args{Rcodes: []int{2}, Attempts: 2}, []int{2}, 0, 2, false, 2},
+		{"deadline", Args{Backoff: 100}, []int{-1}, time.Millisecond * 150, 2, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			ps := map[string]any{"seq": flaky(&calls, tt.rcodes...)}
+			args := tt.args
+			args.Exec = "seq"
+			if args.Backoff == 0 {
+				args.Backoff = 1
+			}
+			r, err := newRetry(coremain.NewBP("r", coremain.NewTestMosdnsWithPlugins(ps)), &args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			q := new(dns.Msg)
+			q.SetQuestion("example.", dns.TypeA)
+			qCtx := query_context.NewContext(q)
+			err = r.Exec(ctx, qCtx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("got %d calls, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				return
+			}
+			if resp := qCtx.R(); resp == nil || resp.Rcode != tt.wantRcode {
+				t.Fatalf("got response %v, want rcode %d", resp, tt.wantRcode)
+			}
+		})
+	}
+}
@@ -21,8 +21,13 @@ package sequence
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const PluginType = "sequence"
@@ -37,11 +42,31 @@ func init() {
 	MustRegExecQuickSetup("jump", setupJump)
 	MustRegMatchQuickSetup("_true", setupTrue) // add _ prefix to avoid being mis-parsed as bool
 	MustRegMatchQuickSetup("_false", setupFalse)
+	RegQuickSetupFormat("accept", "accept")
+	RegQuickSetupFormat("reject", "reject [rcode]")
+	RegQuickSetupFormat("return", "return")
+	RegQuickSetupFormat("goto", "goto sequence_tag")
+	RegQuickSetupFormat("jump", "jump sequence_tag")
+	RegQuickSetupFormat("_true", "_true")
+	RegQuickSetupFormat("_false", "_false")
+
+	coremain.RegSchemaExtension("quick_setup", func() any {
+		return map[string][]QuickSetupInfo{
+			"exec":  ListExecQuickSetups(),
+			"match": ListMatchQuickSetups(),
+		}
+	})
 }
 
 type Sequence struct {
+	tag              string // Empty if the sequence is not a plugin.
 	chain            []*ChainNode
 	anonymousPlugins []any
+
+	// Stats of all rules, including rules in blocks. nil if stats
+	// are not enabled.
+	stats     []*ruleStats
+	statNames map[string]struct{}
 }
 
 func (s *Sequence) Close() error {
@@ -51,18 +76,104 @@ func (s *Sequence) Close() error {
 	return nil
 }
 
-type Args = []RuleArgs
+// Args is a list of rules, or a map with rules and options.
+type Args struct {
+	Rules []RuleArgs `yaml:"rules"`
+
+	// Stats enables per-rule stats. See RuleStats.
+	Stats bool `yaml:"stats"`
+}
+
+// DecodeArgs implements coremain.ArgsDecoder.
+func (a *Args) DecodeArgs(in any) error {
+	if k := reflect.ValueOf(in).Kind(); k == reflect.Slice || k == reflect.Array {
+		return utils.WeakDecode(in, &a.Rules)
+	}
+	type args Args // Without methods.
+	return utils.WeakDecode(in, (*args)(a))
+}
+
+// ArgsForms implements coremain.ArgsDecoder.
+func (a *Args) ArgsForms() []any {
+	return []any{[]RuleArgs(nil), Args{}}
+}
+
+// ReferredTags implements coremain.TagReferrer. It returns jump and goto
+// targets, tags of structured exec and match entries, which may not
+// have a "$" prefix, and refs of inline plugins. Jump cycles are reported
+// as reference cycles when plugins are loaded.
+func (a *Args) ReferredTags() []string {
+	var tags []string
+	rcs, err := parseRules(a.Rules)
+	if err != nil { // Invalid args will be reported by Init.
+		return nil
+	}
+	var addMatches func(mcs []MatchConfig)
+	addMatches = func(mcs []MatchConfig) {
+		for _, mc := range mcs {
+			if len(mc.Tag) > 0 {
+				tags = append(tags, mc.Tag)
+			}
+			if mc.PluginArgs != nil {
+				tags = append(tags, coremain.PluginRefs(mc.Type, mc.PluginArgs)...)
+			}
+			addMatches(mc.Any)
+			addMatches(mc.All)
+		}
+	}
+	walkRules(rcs, func(rc RuleConfig) {
+		addMatches(rc.Matches)
+		if b := rc.Block; b != nil {
+			for _, br := range b.Branches {
+				addMatches(br.If)
+			}
+			return
+		}
+		switch {
+		case len(rc.Tag) > 0:
+			tags = append(tags, rc.Tag)
+		case rc.PluginArgs != nil:
+			tags = append(tags, coremain.PluginRefs(rc.Type, rc.PluginArgs)...)
+		case rc.Type == "jump" || rc.Type == "goto":
+			tags = append(tags, rc.Args)
+		}
+	})
+	return tags
+}
 
 func Init(bp *coremain.BP, args any) (any, error) {
-	return NewSequence(bp, *args.(*Args))
+	a := args.(*Args)
+	s, err := newSequence(bp, bp.Tag(), a)
+	if err != nil {
+		return nil, err
+	}
+	if a.Stats {
+		r := prometheus.WrapRegistererWith(prometheus.Labels{"tag": bp.Tag()}, prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg()))
+		if err := r.Register(&statsCollector{s: s}); err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("failed to register metrics, %w", err)
+		}
+	}
+	bp.RegAPI(s.api(bp))
+	return s, nil
 }
 
 func NewSequence(bq BQ, ra []RuleArgs) (*Sequence, error) {
-	s := &Sequence{}
+	return newSequence(bq, "", &Args{Rules: ra})
+}
 
-	var rc []RuleConfig
-	for _, ra := range ra {
-		rc = append(rc, parseArgs(ra))
+// newSequence creates a sequence. tag is used to name inline plugins and
+// in explain results. It can be empty.
+func newSequence(bq BQ, tag string, args *Args) (*Sequence, error) {
+	s := &Sequence{tag: tag}
+	if args.Stats {
+		s.stats = make([]*ruleStats, 0)
+		s.statNames = make(map[string]struct{})
+	}
+
+	rc, err := parseRules(args.Rules)
+	if err != nil {
+		return nil, err
 	}
 	if err := s.buildChain(bq, rc); err != nil {
 		_ = s.Close()
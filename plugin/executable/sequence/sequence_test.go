@@ -92,21 +92,62 @@ func Test_sequence_Exec(t *testing.T) {
 			name: "match",
 			ra: []RuleArgs{
 				{
-					Matches: []string{"$true", "$false", "$err"}, // skip following matches when false
-					Exec:    "$err",                              // skip exec when false
+					Matches: []any{"$true", "$false", "$err"}, // skip following matches when false
+					Exec:    "$err",                           // skip exec when false
 				},
 				{
-					Matches: []string{"$false", "$err"},
+					Matches: []any{"$false", "$err"},
 					Exec:    "$err",
 				},
 				{
-					Matches: []string{"$true", "$true"},
+					Matches: []any{"$true", "$true"},
 					Exec:    "$target",
 				},
 			},
 			wantErr:    false,
 			wantTarget: true,
 		},
+		{
+			name: "match group",
+			ra: []RuleArgs{
+				{
+					Matches: []any{map[string]any{"all": []any{"$true", "$false", "$err"}}}, // short-circuit
+					Exec:    "$err",
+				},
+				{
+					Matches: []any{map[string]any{"!any": []string{"$false", "$true", "$err"}}},
+					Exec:    "$err",
+				},
+				{
+					Matches: []any{
+						map[string]any{"any": []any{"$false", map[string]any{"all": []any{"$true", "!$false"}}}},
+						"$true",
+					},
+					Exec: "$target",
+				},
+			},
+			wantErr:    false,
+			wantTarget: true,
+		},
+		{
+			name: "structured",
+			ra: []RuleArgs{
+				{
+					Matches: []any{map[string]any{"tag": "$false", "reverse": true}, map[string]any{"type": "_true"}},
+					Exec:    map[string]any{"tag": "nop"},
+				},
+				{
+					Matches: []any{map[string]any{"tag": "true", "reverse": "true"}},
+					Exec:    map[string]any{"tag": "err"},
+				},
+				{Exec: map[string]any{"type": "sequence", "args": []any{ // inline plugin
+					map[string]any{"exec": "$nop"},
+					map[string]any{"exec": map[string]any{"type": "reject", "args": 2}},
+				}}},
+			},
+			wantErr:    false,
+			wantTarget: true,
+		},
 		{
 			name: "goto return",
 			ra: []RuleArgs{
@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ruleStats counts the evaluations of a rule. Its methods are nil-safe,
+// so nodes of a sequence without stats have a nil *ruleStats.
+type ruleStats struct {
+	name string
+	rule *ruleInfo
+
+	evals   atomic.Uint64 // Matcher evaluations.
+	matched atomic.Uint64 // All matchers were matched.
+	execs   atomic.Uint64
+	errs    atomic.Uint64
+	nanos   atomic.Int64 // Time spent in matchers and the exec.
+}
+
+func (st *ruleStats) now() time.Time {
+	if st == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (st *ruleStats) evaluated() {
+	if st != nil {
+		st.evals.Add(1)
+	}
+}
+
+func (st *ruleStats) matchedAll() {
+	if st != nil {
+		st.matched.Add(1)
+	}
+}
+
+// done records the time since start. exec is true if the exec was run.
+func (st *ruleStats) done(start time.Time, exec bool, err error) {
+	if st == nil {
+		return
+	}
+	if exec {
+		st.execs.Add(1)
+	}
+	if err != nil {
+		st.errs.Add(1)
+	}
+	st.nanos.Add(int64(time.Since(start)))
+}
+
+func (st *ruleStats) reset() {
+	st.evals.Store(0)
+	st.matched.Store(0)
+	st.execs.Store(0)
+	st.errs.Store(0)
+	st.nanos.Store(0)
+}
+
+// RuleStats are counters of a rule since the sequence was loaded or the
+// stats were reset.
+type RuleStats struct {
+	Rule        string   `json:"rule"`
+	Matches     []string `json:"matches,omitempty"`
+	Exec        string   `json:"exec"`
+	Evaluations uint64   `json:"evaluations"` // Matcher evaluations.
+	Matched     uint64   `json:"matched"`     // All matchers were matched.
+	Executions  uint64   `json:"executions"`
+	Errors      uint64   `json:"errors"`
+
+	// Time spent in matchers and the exec. The time of a recursive
+	// executable (e.g. cache) includes the rules after it.
+	Time string `json:"time"`
+}
+
+// RuleStats returns stats of all rules, including rules in blocks, in
+// config order. It returns nil if stats are not enabled.
+func (s *Sequence) RuleStats() []RuleStats {
+	if s.stats == nil {
+		return nil
+	}
+	res := make([]RuleStats, 0, len(s.stats))
+	for _, st := range s.stats {
+		res = append(res, RuleStats{
+			Rule:        st.name,
+			Matches:     st.rule.matches,
+			Exec:        st.rule.exec,
+			Evaluations: st.evals.Load(),
+			Matched:     st.matched.Load(),
+			Executions:  st.execs.Load(),
+			Errors:      st.errs.Load(),
+			Time:        time.Duration(st.nanos.Load()).String(),
+		})
+	}
+	return res
+}
+
+// ResetRuleStats sets all rule stats to zero.
+func (s *Sequence) ResetRuleStats() {
+	for _, st := range s.stats {
+		st.reset()
+	}
+}
+
+// rulesApi serves "GET /", which returns RuleStats as json, and
+// "POST /reset", which resets the stats.
+func (s *Sequence) rulesApi() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if s.stats == nil {
+				http.Error(w, "rule stats are not enabled", http.StatusNotFound)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+	r.Get("/", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.RuleStats())
+	})
+	r.Post("/reset", func(w http.ResponseWriter, req *http.Request) {
+		s.ResetRuleStats()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return r
+}
+
+// statsCollector exports rule stats as counters.
+type statsCollector struct {
+	s *Sequence
+}
+
+var (
+	ruleEvalsDesc   = prometheus.NewDesc("rule_evaluations_total", "The total number of matcher evaluations of the rule", []string{"rule"}, nil)
+	ruleMatchedDesc = prometheus.NewDesc("rule_matched_total", "The total number of times all matchers of the rule were matched", []string{"rule"}, nil)
+	ruleExecsDesc   = prometheus.NewDesc("rule_executions_total", "The total number of executions of the rule", []string{"rule"}, nil)
+	ruleErrsDesc    = prometheus.NewDesc("rule_errors_total", "The total number of errors of the rule", []string{"rule"}, nil)
+	ruleTimeDesc    = prometheus.NewDesc("rule_seconds_total", "The total time spent in the rule", []string{"rule"}, nil)
+)
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ruleEvalsDesc
+	ch <- ruleMatchedDesc
+	ch <- ruleExecsDesc
+	ch <- ruleErrsDesc
+	ch <- ruleTimeDesc
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, st := range c.s.stats {
+		ch <- prometheus.MustNewConstMetric(ruleEvalsDesc, prometheus.CounterValue, float64(st.evals.Load()), st.name)
+		ch <- prometheus.MustNewConstMetric(ruleMatchedDesc, prometheus.CounterValue, float64(st.matched.Load()), st.name)
+		ch <- prometheus.MustNewConstMetric(ruleExecsDesc, prometheus.CounterValue, float64(st.execs.Load()), st.name)
+		ch <- prometheus.MustNewConstMetric(ruleErrsDesc, prometheus.CounterValue, float64(st.errs.Load()), st.name)
+		ch <- prometheus.MustNewConstMetric(ruleTimeDesc, prometheus.CounterValue, time.Duration(st.nanos.Load()).Seconds(), st.name)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sequence
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/miekg/dns"
+)
+
+func Test_Args_DecodeArgs(t *testing.T) {
+	list := []any{map[string]any{"exec": "accept"}}
+	a := new(Args)
+	if err := a.DecodeArgs(list); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Rules) != 1 || a.Stats {
+		t.Fatalf("unexpected args %+v", a)
+	}
+
+	a = new(Args)
+	if err := a.DecodeArgs(map[string]any{"rules": list, "stats": true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Rules) != 1 || !a.Stats {
+		t.Fatalf("unexpected args %+v", a)
+	}
+
+	if err := new(Args).DecodeArgs(map[string]any{"no_such_key": 1}); err == nil {
+		t.Fatal("want error for unknown key")
+	}
+}
+
+func Test_sequence_RuleStats(t *testing.T) {
+	ps := make(map[string]any)
+	m := coremain.NewTestMosdnsWithPlugins(ps)
+	preparePlugins(ps)
+	s, err := newSequence(coremain.NewBP("main", m), "main", &Args{Stats: true, Rules: []RuleArgs{
+		{Matches: []any{"$true", "$false"}, Exec: "$err"},
+		{Name: "block", If: []any{"$true"}, Then: []RuleArgs{{Exec: "$nop"}}},
+		{Exec: "$target"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.", dns.TypeA)
+	for i := 0; i < 2; i++ {
+		if err := s.Exec(context.Background(), query_context.NewContext(q)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Explain queries are not counted.
+	if _, err := s.Explain(context.Background(), &ExplainArgs{Qname: "example."}); err != nil {
+		t.Fatal(err)
+	}
+
+	type counts struct {
+		rule                          string
+		evals, matched, execs, errors uint64
+	}
+	var got []counts
+	for _, st := range s.RuleStats() {
+		got = append(got, counts{st.Rule, st.Evaluations, st.Matched, st.Executions, st.Errors})
+	}
+	want := []counts{
+		{"r0", 4, 0, 0, 0},
+		{"block", 0, 2, 2, 0},
+		{"r1.b0.r0", 0, 2, 2, 0},
+		{"r2", 0, 2, 2, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("RuleStats() = %+v, want %+v", got, want)
+	}
+
+	// api
+	h := s.rulesApi()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var res []RuleStats
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 4 || res[0].Exec != "$err" || res[0].Matches[1] != "$false" {
+		t.Fatalf("unexpected api result %s", w.Body)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("reset api returned %d", w.Code)
+	}
+	for _, st := range s.RuleStats() {
+		if st.Evaluations != 0 || st.Matched != 0 || st.Executions != 0 {
+			t.Fatalf("stats are not reset, %+v", st)
+		}
+	}
+
+	// duplicated names
+	_, err = newSequence(coremain.NewBP("main", m), "main", &Args{Stats: true, Rules: []RuleArgs{
+		{Name: "a", Exec: "$nop"},
+		{Name: "a", Exec: "$nop"},
+	}})
+	if err == nil {
+		t.Fatal("want error for duplicated rule names")
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package timeout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"go.uber.org/zap"
+)
+
+const PluginType = "timeout"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+	sequence.RegQuickSetupFormat(PluginType, "timeout milliseconds, applies to the rest of the sequence")
+	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
+}
+
+// ErrTimeout is the cause of the context if the deadline of a timeout
+// is exceeded.
+var ErrTimeout = errors.New("timeout exceeded")
+
+type Args struct {
+	// Timeout in milliseconds. Required.
+	Timeout int `yaml:"timeout"`
+
+	// Exec is the tag of the executable (e.g. a sequence) that the timeout
+	// applies to. The rest of the sequence will be executed after it without
+	// the timeout. If it is empty, the timeout applies to the rest of the
+	// sequence.
+	Exec string `yaml:"exec"`
+
+	// OnTimeout is the tag of an executable that will be executed if the
+	// timeout is exceeded. It runs with the parent deadline. If it is empty,
+	// the timeout error will be returned.
+	OnTimeout string `yaml:"on_timeout"`
+}
+
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	return []string{a.Exec, a.OnTimeout}
+}
+
+var _ sequence.RecursiveExecutable = (*timeout)(nil)
+
+type timeout struct {
+	logger    *zap.Logger
+	d         time.Duration
+	exec      sequence.Executable // Maybe nil.
+	onTimeout sequence.Executable // Maybe nil.
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	return newTimeout(bp, args.(*Args))
+}
+
+func newTimeout(bp *coremain.BP, args *Args) (*timeout, error) {
+	if args.Timeout <= 0 {
+		return nil, errors.New("invalid timeout")
+	}
+	t := &timeout{
+		logger: bp.L(),
+		d:      time.Duration(args.Timeout) * time.Millisecond,
+	}
+	if len(args.Exec) > 0 {
+		if t.exec = sequence.ToExecutable(bp.M().GetPlugin(args.Exec)); t.exec == nil {
+			return nil, fmt.Errorf("can not find executable %s", args.Exec)
+		}
+	}
+	if len(args.OnTimeout) > 0 {
+		if t.onTimeout = sequence.ToExecutable(bp.M().GetPlugin(args.OnTimeout)); t.onTimeout == nil {
+			return nil, fmt.Errorf("can not find on_timeout executable %s", args.OnTimeout)
+		}
+	}
+	return t, nil
+}
+
+// QuickSetup format: milliseconds
+func QuickSetup(bq sequence.BQ, s string) (any, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid timeout %q", s)
+	}
+	return &timeout{logger: bq.L(), d: time.Duration(n) * time.Millisecond}, nil
+}
+
+func (t *timeout) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	tCtx, cancel := context.WithTimeoutCause(ctx, t.d, ErrTimeout)
+	defer cancel()
+
+	var err error
+	if t.exec != nil {
+		err = t.exec.Exec(tCtx, qCtx)
+	} else {
+		err = next.ExecNext(tCtx, qCtx)
+	}
+	if err != nil && errors.Is(context.Cause(tCtx), ErrTimeout) && ctx.Err() == nil {
+		if t.onTimeout == nil {
+			return fmt.Errorf("%w after %s, %w", ErrTimeout, t.d, err)
+		}
+		t.logger.Debug("timeout exceeded", qCtx.InfoField(), zap.Error(err))
+		if err := t.onTimeout.Exec(ctx, qCtx); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if t.exec != nil {
+		return next.ExecNext(ctx, qCtx)
+	}
+	return nil
+}
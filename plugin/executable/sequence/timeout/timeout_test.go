@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package timeout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+)
+
+func Test_timeout(t *testing.T) {
+	slow := sequence.ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+		select {
+		case <-time.After(time.Second):
+			return nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	})
+	respond := func(rcode int) sequence.Executable {
+		return sequence.ExecutableFunc(func(ctx context.Context, qCtx *query_context.Context) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			r := new(dns.Msg)
+			r.SetRcode(qCtx.Q(), rcode)
+			qCtx.SetResponse(r)
+			return nil
+		})
+	}
+
+	tests := []struct {
+		name      string
+		args      *Args
+		ra        []sequence.RuleArgs
+		wantErr   bool
+		wantRcode int
+	}{
+		{
+			name:    "quick setup",
+			ra:      []sequence.RuleArgs{{Exec: "timeout 10"}, {Exec: "$slow"}},
+			wantErr: true,
+		},
+		{
+			name:      "on timeout",
+			args:      &Args{Timeout: 10, OnTimeout: "servfail"},
+			ra:        []sequence.RuleArgs{{Exec: "$t"}, {Exec: "$slow"}, {Exec: "$refused"}},
+			wantRcode: dns.RcodeServerFailure,
+		},
+		{
+			name:      "exec continues",
+			args:      &Args{Timeout: 10, Exec: "slow", OnTimeout: "servfail"},
+			ra:        []sequence.RuleArgs{{Exec: "$t"}, {Exec: "$refused"}},
+			wantRcode: dns.RcodeRefused,
+		},
+		{
+			name:      "no timeout",
+			args:      &Args{Timeout: 1000, OnTimeout: "servfail"},
+			ra:        []sequence.RuleArgs{{Exec: "$t"}, {Exec: "$refused"}},
+			wantRcode: dns.RcodeRefused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := map[string]any{
+				"slow":     slow,
+				"servfail": respond(dns.RcodeServerFailure),
+				"refused":  respond(dns.RcodeRefused),
+			}
+			m := coremain.NewTestMosdnsWithPlugins(ps)
+			if tt.args != nil {
+				p, err := newTimeout(coremain.NewBP("t", m), tt.args)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ps["t"] = p
+			}
+			s, err := sequence.NewSequence(sequence.NewBQ(m, mlog.Nop()), tt.ra)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := new(dns.Msg)
+			q.SetQuestion("example.", dns.TypeA)
+			qCtx := query_context.NewContext(q)
+			err = s.Exec(context.Background(), qCtx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrTimeout) {
+					t.Fatalf("Exec() error = %v, want ErrTimeout", err)
+				}
+				return
+			}
+			if r := qCtx.R(); r == nil || r.Rcode != tt.wantRcode {
+				t.Fatalf("got response %v, want rcode %d", r, tt.wantRcode)
+			}
+		})
+	}
+}
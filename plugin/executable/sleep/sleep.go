@@ -45,6 +45,7 @@ func init() {
 
 	// You can register a quick setup func for sequence. So that users can
 	// init your plugin in the sequence directly in one string.
+	sequence.RegQuickSetupFormat(PluginType, "sleep milliseconds")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const PluginType = "stats"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() any { return new(Args) })
+}
+
+type Args struct {
+	// Size is the number of counters per dimension per bucket. Larger
+	// size gives more accurate counts. Default is 1000.
+	Size int `yaml:"size"`
+	// TopK is the default number of top entries to report. Default is 10.
+	TopK int `yaml:"top_k"`
+	// Windows are lengths in seconds of sliding windows.
+	// Default is [300, 3600].
+	Windows []int `yaml:"windows"`
+	// BlockedMarks flag a query as blocked if it has any of these marks.
+	BlockedMarks []uint32 `yaml:"blocked_marks"`
+	// Prometheus exports counts of top entries as gauges.
+	Prometheus bool `yaml:"prometheus"`
+}
+
+func (a *Args) init() error {
+	utils.SetDefaultUnsignNum(&a.Size, 1000)
+	utils.SetDefaultUnsignNum(&a.TopK, 10)
+	if len(a.Windows) == 0 {
+		a.Windows = []int{300, 3600}
+	}
+	for _, w := range a.Windows {
+		if w < bucketsPerWindow {
+			return fmt.Errorf("window %d is too short, must be at least %d seconds", w, bucketsPerWindow)
+		}
+	}
+	return nil
+}
+
+var _ sequence.RecursiveExecutable = (*Stats)(nil)
+
+type Stats struct {
+	topK         int
+	blockedMarks []uint32
+
+	m       sync.Mutex
+	windows []*window
+}
+
+func Init(bp *coremain.BP, args any) (any, error) {
+	a := args.(*Args)
+	if err := a.init(); err != nil {
+		return nil, err
+	}
+	s := NewStats(a)
+	if a.Prometheus {
+		r := prometheus.WrapRegistererWith(prometheus.Labels{"tag": bp.Tag()}, prometheus.WrapRegistererWithPrefix(PluginType+"_", bp.M().GetMetricsReg()))
+		if err := r.Register(&collector{s: s}); err != nil {
+			return nil, fmt.Errorf("failed to register metrics, %w", err)
+		}
+	}
+	bp.RegAPI(s.Api())
+	return s, nil
+}
+
+// NewStats returns a Stats. args must be initialized.
+func NewStats(args *Args) *Stats {
+	s := &Stats{
+		topK:         args.TopK,
+		blockedMarks: args.BlockedMarks,
+	}
+	now := time.Now()
+	for _, w := range args.Windows {
+		s.windows = append(s.windows, newWindow(time.Duration(w)*time.Second, args.Size, now))
+	}
+	return s
+}
+
+func (s *Stats) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	err := next.ExecNext(ctx, qCtx)
+	if sequence.IsExplaining(ctx) {
+		return err
+	}
+
+	var keys [numDims]string
+	q := qCtx.QQuestion()
+	keys[0] = q.Name
+	if a := qCtx.ServerMeta.ClientAddr; a.IsValid() {
+		keys[1] = a.String()
+	}
+	switch r := qCtx.R(); {
+	case err != nil:
+		keys[2] = "ERROR"
+	case r == nil:
+		keys[2] = "NO_RESPONSE"
+	default:
+		keys[2] = dns.RcodeToString[r.Rcode]
+	}
+	keys[3] = dns.Type(q.Qtype).String()
+	blocked := false
+	for _, m := range s.blockedMarks {
+		if qCtx.HasMark(m) {
+			blocked = true
+			keys[4] = q.Name
+			break
+		}
+	}
+
+	now := time.Now()
+	s.m.Lock()
+	for _, w := range s.windows {
+		w.add(now, &keys, blocked)
+	}
+	s.m.Unlock()
+	return err
+}
+
+// Stats returns statistics of all windows with k top entries.
+func (s *Stats) Stats(k int) []WindowStats {
+	now := time.Now()
+	s.m.Lock()
+	defer s.m.Unlock()
+	res := make([]WindowStats, 0, len(s.windows))
+	for _, w := range s.windows {
+		res = append(res, w.stats(now, k))
+	}
+	return res
+}
+
+// Api serves "GET /", which returns statistics of all windows as json.
+// Use "?k=n" to set the number of top entries, and "?window=seconds" to
+// select a window.
+func (s *Stats) Api() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/", func(w http.ResponseWriter, req *http.Request) {
+		k := s.topK
+		if ks := req.URL.Query().Get("k"); len(ks) > 0 {
+			n, err := strconv.Atoi(ks)
+			if err != nil || n <= 0 {
+				http.Error(w, fmt.Sprintf("invalid k %s", ks), http.StatusBadRequest)
+				return
+			}
+			k = n
+		}
+		res := s.Stats(k)
+		if ws := req.URL.Query().Get("window"); len(ws) > 0 {
+			n, err := strconv.Atoi(ws)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid window %s", ws), http.StatusBadRequest)
+				return
+			}
+			var selected []WindowStats
+			for _, st := range res {
+				if st.Window == (time.Duration(n) * time.Second).String() {
+					selected = append(selected, st)
+				}
+			}
+			if len(selected) == 0 {
+				http.Error(w, fmt.Sprintf("no such window %s", ws), http.StatusNotFound)
+				return
+			}
+			res = selected
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+	return r
+}
+
+// collector exports counts of top entries as gauges.
+type collector struct {
+	s *Stats
+}
+
+var (
+	topCountDesc = prometheus.NewDesc("top_count", "Estimated count of a top entry in the window",
+		[]string{"window", "dimension", "key"}, nil)
+	totalDesc = prometheus.NewDesc("queries", "The number of queries in the window",
+		[]string{"window"}, nil)
+	blockedDesc = prometheus.NewDesc("blocked_queries", "The number of blocked queries in the window",
+		[]string{"window"}, nil)
+)
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- topCountDesc
+	ch <- totalDesc
+	ch <- blockedDesc
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	for _, ws := range c.s.Stats(c.s.topK) {
+		ch <- prometheus.MustNewConstMetric(totalDesc, prometheus.GaugeValue, float64(ws.Total), ws.Window)
+		ch <- prometheus.MustNewConstMetric(blockedDesc, prometheus.GaugeValue, float64(ws.Blocked), ws.Window)
+		for dim, es := range ws.Top {
+			for _, e := range es {
+				ch <- prometheus.MustNewConstMetric(topCountDesc, prometheus.GaugeValue, float64(e.Count), ws.Window, dim, e.Key)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_spaceSaving(t *testing.T) {
+	s := newSpaceSaving(20)
+	// Heavy hitters among a long tail. Keys with a count > n/size are kept.
+	for i := 0; i < 1000; i++ {
+		s.add(fmt.Sprintf("tail%d", i))
+		if i%4 == 0 {
+			s.add("a")
+		}
+		if i%10 == 0 {
+			s.add("b")
+		}
+	}
+	top := topOf([]*spaceSaving{s}, 2)
+	if len(top) != 2 || top[0].Key != "a" || top[1].Key != "b" {
+		t.Fatalf("unexpected top %+v", top)
+	}
+	if top[0].Count < 250 || top[0].Count-top[0].Error > 250 {
+		t.Fatalf("count of a should be an upper bound of 250, got %+v", top[0])
+	}
+	if len(s.h) != 20 || len(s.idx) != 20 {
+		t.Fatalf("size exceeded, %d counters, %d indexes", len(s.h), len(s.idx))
+	}
+}
+
+func Test_window(t *testing.T) {
+	now := time.Now()
+	w := newWindow(time.Minute, 10, now)
+	keys := [numDims]string{"a.", "127.0.0.1", "NOERROR", "A", ""}
+	w.add(now, &keys, false)
+	w.add(now.Add(time.Second*30), &keys, false)
+	if st := w.stats(now.Add(time.Second*30), 10); st.Total != 2 || st.Top[DimQname][0].Count != 2 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	// The first query expired.
+	if st := w.stats(now.Add(time.Second*65), 10); st.Total != 1 {
+		t.Fatalf("want 1 query in window, got %d", st.Total)
+	}
+	if st := w.stats(now.Add(time.Hour), 10); st.Total != 0 || len(st.Top[DimQname]) != 0 {
+		t.Fatalf("window should be empty, got %+v", st)
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	args := &Args{BlockedMarks: []uint32{1}, TopK: 2}
+	if err := args.init(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewStats(args)
+	var block sequence.ExecutableFunc = func(_ context.Context, qCtx *query_context.Context) error {
+		if qCtx.QQuestion().Name == "ads.example." {
+			qCtx.SetMark(1)
+			r := new(dns.Msg)
+			r.SetRcode(qCtx.Q(), dns.RcodeNameError)
+			qCtx.SetResponse(r)
+		}
+		return nil
+	}
+	for i, name := range []string{"ads.example.", "ads.example.", "a.example.", "b.example."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		qCtx := query_context.NewContext(q)
+		qCtx.ServerMeta.ClientAddr = netip.AddrFrom4([4]byte{10, 0, 0, byte(i % 2)})
+		if err := s.Exec(context.Background(), qCtx, sequence.NewChainWalker([]*sequence.ChainNode{{E: block}}, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st := s.Stats(2)
+	if len(st) != 2 {
+		t.Fatalf("want 2 windows, got %d", len(st))
+	}
+	ws := st[0]
+	if ws.Total != 4 || ws.Blocked != 2 {
+		t.Fatalf("total %d, blocked %d", ws.Total, ws.Blocked)
+	}
+	if e := ws.Top[DimBlockedQname]; len(e) != 1 || e[0].Key != "ads.example." || e[0].Count != 2 {
+		t.Fatalf("unexpected blocked top %+v", e)
+	}
+	if e := ws.Top[DimRcode]; len(e) != 2 || e[0].Key != "NO_RESPONSE" || e[1].Key != "NXDOMAIN" {
+		t.Fatalf("unexpected rcode top %+v", e)
+	}
+	if e := ws.Top[DimClient]; len(e) != 2 || e[0].Count != 2 {
+		t.Fatalf("unexpected client top %+v", e)
+	}
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(&collector{s: s}); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 3 {
+		t.Fatalf("want 3 metric families, got %d", len(mfs))
+	}
+}
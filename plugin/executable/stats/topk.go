@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package stats
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// spaceSaving counts the most frequent keys with a fixed number of
+// counters, using the Space-Saving algorithm. Counts of keys can be
+// overestimated by up to their err.
+type spaceSaving struct {
+	size int
+	idx  map[string]int // key -> index in h
+	h    []ssCounter    // min heap by count
+}
+
+type ssCounter struct {
+	key   string
+	count uint64
+	err   uint64
+}
+
+func newSpaceSaving(size int) *spaceSaving {
+	return &spaceSaving{size: size, idx: make(map[string]int)}
+}
+
+func (s *spaceSaving) add(key string) {
+	if i, ok := s.idx[key]; ok {
+		s.h[i].count++
+		heap.Fix(s, i)
+		return
+	}
+	if len(s.h) < s.size {
+		heap.Push(s, ssCounter{key: key, count: 1})
+		return
+	}
+	// Replace the minimum counter.
+	m := &s.h[0]
+	delete(s.idx, m.key)
+	m.key, m.err = key, m.count
+	m.count++
+	s.idx[key] = 0
+	heap.Fix(s, 0)
+}
+
+func (s *spaceSaving) reset() {
+	clear(s.idx)
+	s.h = s.h[:0]
+}
+
+// heap.Interface, which keeps idx in sync.
+func (s *spaceSaving) Len() int           { return len(s.h) }
+func (s *spaceSaving) Less(i, j int) bool { return s.h[i].count < s.h[j].count }
+func (s *spaceSaving) Swap(i, j int) {
+	s.h[i], s.h[j] = s.h[j], s.h[i]
+	s.idx[s.h[i].key] = i
+	s.idx[s.h[j].key] = j
+}
+func (s *spaceSaving) Push(x any) {
+	c := x.(ssCounter)
+	s.idx[c.key] = len(s.h)
+	s.h = append(s.h, c)
+}
+func (s *spaceSaving) Pop() any {
+	c := s.h[len(s.h)-1]
+	s.h = s.h[:len(s.h)-1]
+	delete(s.idx, c.key)
+	return c
+}
+
+// Entry is a key and its estimated count.
+type Entry struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error,omitempty"` // Count may be overestimated by up to Error.
+}
+
+// topOf merges counters and returns the k keys with the largest counts.
+func topOf(ss []*spaceSaving, k int) []Entry {
+	m := make(map[string]*Entry)
+	for _, s := range ss {
+		for _, c := range s.h {
+			e := m[c.key]
+			if e == nil {
+				e = &Entry{Key: c.key}
+				m[c.key] = e
+			}
+			e.Count += c.count
+			e.Error += c.err
+		}
+	}
+	es := make([]Entry, 0, len(m))
+	for _, e := range m {
+		es = append(es, *e)
+	}
+	sort.Slice(es, func(i, j int) bool {
+		if es[i].Count != es[j].Count {
+			return es[i].Count > es[j].Count
+		}
+		return es[i].Key < es[j].Key
+	})
+	if len(es) > k {
+		es = es[:k]
+	}
+	return es
+}
+
+// Dimensions of a query.
+const (
+	DimQname        = "qname"
+	DimClient       = "client"
+	DimRcode        = "rcode"
+	DimQtype        = "qtype"
+	DimBlockedQname = "blocked_qname"
+	numDims         = 5
+)
+
+var dims = [numDims]string{DimQname, DimClient, DimRcode, DimQtype, DimBlockedQname}
+
+type bucket struct {
+	total   uint64
+	blocked uint64
+	dims    [numDims]*spaceSaving
+}
+
+// window is a sliding window of a fixed number of buckets.
+type window struct {
+	length   time.Duration
+	interval time.Duration // of a bucket
+	buckets  []*bucket
+	cur      int
+	curStart time.Time
+}
+
+const bucketsPerWindow = 12
+
+func newWindow(length time.Duration, size int, now time.Time) *window {
+	w := &window{
+		length:   length,
+		interval: length / bucketsPerWindow,
+		buckets:  make([]*bucket, bucketsPerWindow),
+		curStart: now,
+	}
+	for i := range w.buckets {
+		b := new(bucket)
+		for d := range b.dims {
+			b.dims[d] = newSpaceSaving(size)
+		}
+		w.buckets[i] = b
+	}
+	return w
+}
+
+// advance moves the current bucket to now, and resets expired buckets.
+func (w *window) advance(now time.Time) {
+	steps := int(now.Sub(w.curStart) / w.interval)
+	if steps <= 0 {
+		return
+	}
+	if steps > len(w.buckets) {
+		steps = len(w.buckets)
+	}
+	for i := 0; i < steps; i++ {
+		w.cur = (w.cur + 1) % len(w.buckets)
+		b := w.buckets[w.cur]
+		b.total, b.blocked = 0, 0
+		for _, s := range b.dims {
+			s.reset()
+		}
+	}
+	w.curStart = w.curStart.Add(now.Sub(w.curStart).Truncate(w.interval))
+}
+
+// add records a query. keys are indexed by dimension. Empty keys are ignored.
+func (w *window) add(now time.Time, keys *[numDims]string, blocked bool) {
+	w.advance(now)
+	b := w.buckets[w.cur]
+	b.total++
+	if blocked {
+		b.blocked++
+	}
+	for d, k := range keys {
+		if len(k) > 0 {
+			b.dims[d].add(k)
+		}
+	}
+}
+
+// WindowStats is the statistics of a window.
+type WindowStats struct {
+	Window  string             `json:"window"`
+	Total   uint64             `json:"total"`
+	Blocked uint64             `json:"blocked"`
+	Top     map[string][]Entry `json:"top"`
+}
+
+func (w *window) stats(now time.Time, k int) WindowStats {
+	w.advance(now)
+	ws := WindowStats{Window: w.length.String(), Top: make(map[string][]Entry, numDims)}
+	var ss []*spaceSaving
+	for d, name := range dims {
+		ss = ss[:0]
+		for _, b := range w.buckets {
+			ss = append(ss, b.dims[d])
+		}
+		ws.Top[name] = topOf(ss, k)
+	}
+	for _, b := range w.buckets {
+		ws.Total += b.total
+		ws.Blocked += b.blocked
+	}
+	return ws
+}
@@ -34,6 +34,7 @@ const (
 )
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "ttl {min-max|fixed_ttl}")
 	sequence.MustRegExecQuickSetup(PluginType, QuickSetup)
 }
 
@@ -30,6 +30,7 @@ import (
 const PluginType = "mark"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "mark uint32_mark...")
 	sequence.MustRegExecQuickSetup(PluginType, func(_ sequence.BQ, args string) (any, error) {
 		return newMarker(args)
 	})
@@ -29,6 +29,7 @@ import (
 const PluginType = "client_ip"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "client_ip {ip|$ip_set_tag|&ip_list_file}...")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
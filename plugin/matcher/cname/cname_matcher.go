@@ -30,6 +30,7 @@ import (
 const PluginType = "cname"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "cname {domain_exp|$domain_set_tag|&domain_list_file}...")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
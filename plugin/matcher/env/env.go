@@ -29,6 +29,7 @@ import (
 const PluginType = "env"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "env key [value]")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
@@ -28,6 +28,7 @@ import (
 const PluginType = "has_resp"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "has_resp")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
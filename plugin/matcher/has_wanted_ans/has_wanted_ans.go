@@ -28,6 +28,7 @@ import (
 const PluginType = "has_wanted_ans"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "has_wanted_ans")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
@@ -31,6 +31,7 @@ import (
 const PluginType = "ptr_ip"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "ptr_ip {ip|$ip_set_tag|&ip_list_file}...")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
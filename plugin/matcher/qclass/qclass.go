@@ -28,6 +28,7 @@ import (
 const PluginType = "qclass"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "qclass qclass_number...")
 	sequence.MustRegMatchQuickSetup(PluginType, base_int.QuickSetup(matchQClass))
 }
 
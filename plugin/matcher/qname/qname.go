@@ -29,6 +29,7 @@ import (
 const PluginType = "qname"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "qname {domain_exp|$domain_set_tag|&domain_list_file}...")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
@@ -28,6 +28,7 @@ import (
 const PluginType = "qtype"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "qtype qtype_number...")
 	sequence.MustRegMatchQuickSetup(PluginType, base_int.QuickSetup(matchQType))
 }
 
@@ -34,6 +34,7 @@ import (
 const PluginType = "random"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "random probability")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
@@ -28,6 +28,7 @@ import (
 const PluginType = "rcode"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "rcode rcode_number...")
 	sequence.MustRegMatchQuickSetup(PluginType, base_int.QuickSetup(matchRcode))
 }
 
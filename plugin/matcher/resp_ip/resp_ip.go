@@ -32,6 +32,7 @@ import (
 const PluginType = "resp_ip"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "resp_ip {ip|$ip_set_tag|&ip_list_file}...")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
@@ -34,6 +34,7 @@ import (
 const PluginType = "string_exp"
 
 func init() {
+	sequence.RegQuickSetupFormat(PluginType, "string_exp {url_path|server_name|$env_key} {zl|eq|prefix|suffix|contains|regexp} [string]...")
 	sequence.MustRegMatchQuickSetup(PluginType, QuickSetup)
 }
 
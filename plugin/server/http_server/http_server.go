@@ -24,7 +24,9 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
@@ -53,6 +55,15 @@ type Args struct {
 	IdleTimeout int    `yaml:"idle_timeout"`
 }
 
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	var tags []string
+	for _, e := range a.Entries {
+		tags = append(tags, e.Exec)
+	}
+	return tags
+}
+
 func (a *Args) init() {
 	utils.SetDefaultNum(&a.IdleTimeout, 30)
 }
@@ -60,13 +71,29 @@ func (a *Args) init() {
 type HttpServer struct {
 	args *Args
 
+	mux    atomic.Pointer[http.ServeMux]
 	server *http.Server
+	closed atomic.Bool
+	status server_utils.ListenerStatus
+}
+
+// Status implements coremain.StatusReporter.
+func (s *HttpServer) Status() coremain.PluginStatus {
+	return s.status.Status()
 }
 
 func (s *HttpServer) Close() error {
+	s.closed.Store(true)
+	if s.server == nil { // dry-run
+		return nil
+	}
 	return s.server.Close()
 }
 
+func (s *HttpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.Load().ServeHTTP(w, req)
+}
+
 func Init(bp *coremain.BP, args any) (any, error) {
 	return StartServer(bp, args.(*Args))
 }
@@ -86,6 +113,23 @@ func StartServer(bp *coremain.BP, args *Args) (*HttpServer, error) {
 		mux.Handle(entry.Path, hh)
 	}
 
+	// Keep the listener of the previous server if only the entries are changed.
+	if prev, _ := bp.PrevPlugin().(*HttpServer); prev != nil {
+		a, b := *prev.args, *args
+		a.Entries, b.Entries = nil, nil
+		if reflect.DeepEqual(a, b) {
+			bp.OnCommit(func() {
+				prev.mux.Store(mux)
+				prev.args = args
+			})
+			return prev, nil
+		}
+	}
+
+	if bp.DryRun() {
+		return &HttpServer{args: args}, nil
+	}
+
 	socketOpt := server_utils.ListenerSocketOpts{
 		SO_REUSEPORT: true,
 		SO_RCVBUF:    64 * 1024,
@@ -102,8 +146,10 @@ func StartServer(bp *coremain.BP, args *Args) (*HttpServer, error) {
 	}
 	bp.L().Info("http server started", zap.Stringer("addr", l.Addr()))
 
+	s := &HttpServer{args: args}
+	s.mux.Store(mux)
 	hs := &http.Server{
-		Handler:        mux,
+		Handler:        s,
 		ReadTimeout:    time.Second,
 		IdleTimeout:    time.Duration(args.IdleTimeout) * time.Second,
 		MaxHeaderBytes: 512,
@@ -116,7 +162,9 @@ func StartServer(bp *coremain.BP, args *Args) (*HttpServer, error) {
 	}); err != nil {
 		return nil, fmt.Errorf("failed to setup http2 server, %w", err)
 	}
+	s.server = hs
 
+	s.status.Started(l.Addr().String())
 	go func() {
 		var err error
 		if len(args.Key)+len(args.Cert) > 0 {
@@ -124,10 +172,10 @@ func StartServer(bp *coremain.BP, args *Args) (*HttpServer, error) {
 		} else {
 			err = hs.Serve(l)
 		}
-		bp.M().GetSafeClose().SendCloseSignal(err)
+		if !s.closed.Load() {
+			s.status.Stopped(err)
+			bp.M().GetSafeClose().SendCloseSignal(err)
+		}
 	}()
-	return &HttpServer{
-		args:   args,
-		server: hs,
-	}, nil
+	return s, nil
 }
@@ -24,6 +24,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
@@ -48,6 +49,11 @@ type Args struct {
 	IdleTimeout int    `yaml:"idle_timeout"`
 }
 
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	return []string{a.Entry}
+}
+
 func (a *Args) init() {
 	utils.SetDefaultNum(&a.IdleTimeout, 30)
 }
@@ -55,10 +61,22 @@ func (a *Args) init() {
 type QuicServer struct {
 	args *Args
 
-	l *quic.Listener
+	dh     *server_utils.Handler
+	l      *quic.Listener
+	closed atomic.Bool
+	status server_utils.ListenerStatus
+}
+
+// Status implements coremain.StatusReporter.
+func (s *QuicServer) Status() coremain.PluginStatus {
+	return s.status.Status()
 }
 
 func (s *QuicServer) Close() error {
+	s.closed.Store(true)
+	if s.l == nil { // dry-run
+		return nil
+	}
 	return s.l.Close()
 }
 
@@ -74,6 +92,19 @@ func StartServer(bp *coremain.BP, args *Args) (*QuicServer, error) {
 		return nil, fmt.Errorf("failed to init dns handler, %w", err)
 	}
 
+	// Keep the listener of the previous server if only the entry is changed.
+	if prev, _ := bp.PrevPlugin().(*QuicServer); prev != nil {
+		a, b := *prev.args, *args
+		a.Entry, b.Entry = "", ""
+		if a == b {
+			bp.OnCommit(func() {
+				prev.dh.Swap(dh)
+				prev.args = args
+			})
+			return prev, nil
+		}
+	}
+
 	// Init tls
 	if len(args.Key) == 0 || len(args.Cert) == 0 {
 		return nil, errors.New("quic server requires a tls certificate")
@@ -84,6 +115,10 @@ func StartServer(bp *coremain.BP, args *Args) (*QuicServer, error) {
 	}
 	tlsConfig.NextProtos = []string{"doq"}
 
+	if bp.DryRun() {
+		return &QuicServer{args: args, dh: dh}, nil
+	}
+
 	uc, err := net.ListenPacket("udp", args.Listen)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen socket, %w", err)
@@ -119,14 +154,20 @@ func StartServer(bp *coremain.BP, args *Args) (*QuicServer, error) {
 	}
 	bp.L().Info("quic server started", zap.Stringer("addr", quicListener.Addr()))
 
+	s := &QuicServer{
+		args: args,
+		dh:   dh,
+		l:    quicListener,
+	}
+	s.status.Started(quicListener.Addr().String())
 	go func() {
 		defer quicListener.Close()
 		serverOpts := server.DoQServerOpts{Logger: bp.L(), IdleTimeout: idleTimeout}
 		err := server.ServeDoQ(quicListener, dh, serverOpts)
-		bp.M().GetSafeClose().SendCloseSignal(err)
+		if !s.closed.Load() {
+			s.status.Stopped(err)
+			bp.M().GetSafeClose().SendCloseSignal(err)
+		}
 	}()
-	return &QuicServer{
-		args: args,
-		l:    quicListener,
-	}, nil
+	return s, nil
 }
@@ -20,15 +20,34 @@
 package server_utils
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server_handler"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/miekg/dns"
 )
 
-func NewHandler(bp *coremain.BP, entry string) (server.Handler, error) {
+// Handler is a server.Handler that runs queries on an entry executable.
+// The entry can be switched to another one by Swap (e.g. after a reload).
+type Handler struct {
+	e atomic.Pointer[entryHandler]
+}
+
+type entryHandler struct {
+	bp *coremain.BP
+	h  *server_handler.EntryHandler
+}
+
+var _ server.Handler = (*Handler)(nil)
+
+// NewHandler creates a Handler that runs queries on the entry.
+// It also marks bp as a server.
+func NewHandler(bp *coremain.BP, entry string) (*Handler, error) {
+	bp.MarkServer()
 	p := bp.M().GetPlugin(entry)
 	exec := sequence.ToExecutable(p)
 	if exec == nil {
@@ -38,6 +57,44 @@ func NewHandler(bp *coremain.BP, entry string) (server.Handler, error) {
 	handlerOpts := server_handler.EntryHandlerOpts{
 		Logger: bp.L(),
 		Entry:  exec,
+		Tracer: bp.M().Tracer(),
 	}
-	return server_handler.NewEntryHandler(handlerOpts), nil
+	h := new(Handler)
+	h.e.Store(&entryHandler{bp: bp, h: server_handler.NewEntryHandler(handlerOpts)})
+	return h, nil
+}
+
+// Swap switches h to the entry of n.
+func (h *Handler) Swap(n *Handler) {
+	h.e.Store(n.e.Load())
+}
+
+func (h *Handler) Handle(ctx context.Context, q *dns.Msg, meta server.QueryMeta, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
+	for {
+		e := h.e.Load()
+		if e.bp.EnterQuery() {
+			return e.handle(ctx, q, meta, packMsgPayload)
+		}
+		// Plugins of this entry are closing. Retry if the entry has been swapped.
+		if h.e.Load() == e {
+			return refuse(q, packMsgPayload)
+		}
+	}
+}
+
+// refuse returns a REFUSED response of q. It is used when mosdns is
+// shutting down, so clients can retry other servers without waiting.
+func refuse(q *dns.Msg, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
+	r := new(dns.Msg)
+	r.SetRcode(q, dns.RcodeRefused)
+	b, err := packMsgPayload(r)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (e *entryHandler) handle(ctx context.Context, q *dns.Msg, meta server.QueryMeta, packMsgPayload func(m *dns.Msg) (*[]byte, error)) *[]byte {
+	defer e.bp.ExitQuery()
+	return e.h.Handle(ctx, q, meta, packMsgPayload)
 }
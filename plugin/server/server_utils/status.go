@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server_utils
+
+import (
+	"sync"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+)
+
+// ListenerStatus records whether a server is still serving on its listener.
+// It implements coremain.StatusReporter. Zero value is ready to use.
+type ListenerStatus struct {
+	m       sync.Mutex
+	addr    string
+	started bool
+	err     error // Not nil if the server stopped serving.
+}
+
+// Started records that the server is serving on addr.
+func (s *ListenerStatus) Started(addr string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.addr = addr
+	s.started = true
+}
+
+// Stopped records that the server stopped serving unexpectedly.
+func (s *ListenerStatus) Stopped(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.err = err
+}
+
+func (s *ListenerStatus) Status() coremain.PluginStatus {
+	s.m.Lock()
+	defer s.m.Unlock()
+	details := map[string]any{"addr": s.addr, "listening": s.started && s.err == nil}
+	if s.err != nil {
+		details["error"] = s.err.Error()
+		return coremain.PluginStatus{Status: coremain.StatusFailed, Details: details}
+	}
+	return coremain.PluginStatus{Status: coremain.StatusHealthy, Details: details}
+}
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
@@ -48,6 +49,11 @@ type Args struct {
 	IdleTimeout int    `yaml:"idle_timeout"`
 }
 
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	return []string{a.Entry}
+}
+
 func (a *Args) init() {
 	utils.SetDefaultString(&a.Listen, "127.0.0.1:53")
 	utils.SetDefaultNum(&a.IdleTimeout, 10)
@@ -56,10 +62,22 @@ func (a *Args) init() {
 type TcpServer struct {
 	args *Args
 
-	l net.Listener
+	dh     *server_utils.Handler
+	l      net.Listener
+	closed atomic.Bool
+	status server_utils.ListenerStatus
+}
+
+// Status implements coremain.StatusReporter.
+func (s *TcpServer) Status() coremain.PluginStatus {
+	return s.status.Status()
 }
 
 func (s *TcpServer) Close() error {
+	s.closed.Store(true)
+	if s.l == nil { // dry-run
+		return nil
+	}
 	return s.l.Close()
 }
 
@@ -73,6 +91,19 @@ func StartServer(bp *coremain.BP, args *Args) (*TcpServer, error) {
 		return nil, fmt.Errorf("failed to init dns handler, %w", err)
 	}
 
+	// Keep the listener of the previous server if only the entry is changed.
+	if prev, _ := bp.PrevPlugin().(*TcpServer); prev != nil {
+		a, b := *prev.args, *args
+		a.Entry, b.Entry = "", ""
+		if a == b {
+			bp.OnCommit(func() {
+				prev.dh.Swap(dh)
+				prev.args = args
+			})
+			return prev, nil
+		}
+	}
+
 	// Init tls
 	var tc *tls.Config
 	if len(args.Key)+len(args.Cert) > 0 {
@@ -82,6 +113,10 @@ func StartServer(bp *coremain.BP, args *Args) (*TcpServer, error) {
 		}
 	}
 
+	if bp.DryRun() {
+		return &TcpServer{args: args, dh: dh}, nil
+	}
+
 	socketOpt := server_utils.ListenerSocketOpts{
 		SO_REUSEPORT: true,
 		SO_RCVBUF:    64 * 1024,
@@ -100,14 +135,20 @@ func StartServer(bp *coremain.BP, args *Args) (*TcpServer, error) {
 	}
 	bp.L().Info("tcp server started", zap.Stringer("addr", l.Addr()), zap.Bool("tls", tc != nil))
 
+	s := &TcpServer{
+		args: args,
+		dh:   dh,
+		l:    l,
+	}
+	s.status.Started(l.Addr().String())
 	go func() {
 		defer l.Close()
 		serverOpts := server.TCPServerOpts{Logger: bp.L(), IdleTimeout: time.Duration(args.IdleTimeout) * time.Second}
 		err := server.ServeTCP(l, dh, serverOpts)
-		bp.M().GetSafeClose().SendCloseSignal(err)
+		if !s.closed.Load() {
+			s.status.Stopped(err)
+			bp.M().GetSafeClose().SendCloseSignal(err)
+		}
 	}()
-	return &TcpServer{
-		args: args,
-		l:    l,
-	}, nil
+	return s, nil
 }
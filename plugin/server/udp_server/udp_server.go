@@ -23,6 +23,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync/atomic"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/server"
@@ -42,6 +43,11 @@ type Args struct {
 	Listen string `yaml:"listen"`
 }
 
+// ReferredTags implements coremain.TagReferrer.
+func (a *Args) ReferredTags() []string {
+	return []string{a.Entry}
+}
+
 func (a *Args) init() {
 	utils.SetDefaultString(&a.Listen, "127.0.0.1:53")
 }
@@ -49,10 +55,22 @@ func (a *Args) init() {
 type UdpServer struct {
 	args *Args
 
-	c net.PacketConn
+	dh     *server_utils.Handler
+	c      net.PacketConn
+	closed atomic.Bool
+	status server_utils.ListenerStatus
+}
+
+// Status implements coremain.StatusReporter.
+func (s *UdpServer) Status() coremain.PluginStatus {
+	return s.status.Status()
 }
 
 func (s *UdpServer) Close() error {
+	s.closed.Store(true)
+	if s.c == nil { // dry-run
+		return nil
+	}
 	return s.c.Close()
 }
 
@@ -66,6 +84,19 @@ func StartServer(bp *coremain.BP, args *Args) (*UdpServer, error) {
 		return nil, fmt.Errorf("failed to init dns handler, %w", err)
 	}
 
+	// Keep the socket of the previous server if the listen address is unchanged.
+	if prev, _ := bp.PrevPlugin().(*UdpServer); prev != nil && prev.args.Listen == args.Listen {
+		bp.OnCommit(func() {
+			prev.dh.Swap(dh)
+			prev.args = args
+		})
+		return prev, nil
+	}
+
+	if bp.DryRun() {
+		return &UdpServer{args: args, dh: dh}, nil
+	}
+
 	socketOpt := server_utils.ListenerSocketOpts{
 		SO_REUSEPORT: true,
 		SO_RCVBUF:    64 * 1024,
@@ -77,13 +108,19 @@ func StartServer(bp *coremain.BP, args *Args) (*UdpServer, error) {
 	}
 	bp.L().Info("udp server started", zap.Stringer("addr", c.LocalAddr()))
 
+	s := &UdpServer{
+		args: args,
+		dh:   dh,
+		c:    c,
+	}
+	s.status.Started(c.LocalAddr().String())
 	go func() {
 		defer c.Close()
 		err := server.ServeUDP(c.(*net.UDPConn), dh, server.UDPServerOpts{Logger: bp.L()})
-		bp.M().GetSafeClose().SendCloseSignal(err)
+		if !s.closed.Load() {
+			s.status.Stopped(err)
+			bp.M().GetSafeClose().SendCloseSignal(err)
+		}
 	}()
-	return &UdpServer{
-		args: args,
-		c:    c,
-	}, nil
+	return s, nil
 }
@@ -0,0 +1,72 @@
+package integration_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin" // Import all plugins to ensure they're registered
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfigCheck tests that config check reports all errors and does not bind sockets.
+func TestConfigCheck(t *testing.T) {
+	listen := "127.0.0.1:15360"
+	cfg := &coremain.Config{
+		Log: mlog.LogConfig{
+			Level: "error",
+		},
+		Plugins: []coremain.PluginConfig{
+			{
+				Tag:  "main_sequence",
+				Type: "sequence",
+				Args: []map[string]interface{}{
+					{"matches": []string{"qname $no_such_set"}, "exec": "reject 3"},
+					{"exec": "no_such_exec_type"},
+				},
+			},
+			{
+				Tag:  "cache",
+				Type: "cache",
+				Args: map[string]interface{}{"no_such_arg": 1},
+			},
+			{
+				Tag:  "udp_server",
+				Type: "udp_server",
+				Args: map[string]interface{}{
+					"entry":  "no_such_entry",
+					"listen": listen,
+				},
+			},
+		},
+	}
+
+	err := coremain.CheckConfig(cfg)
+	require.Error(t, err)
+	require.ErrorContains(t, err, `matcher #0 "qname $no_such_set"`)
+	require.ErrorContains(t, err, "no_such_exec_type")
+	require.ErrorContains(t, err, "no_such_arg")
+	require.ErrorContains(t, err, "no_such_entry")
+
+	// A valid config passes, and the server does not bind its socket.
+	cfg.Plugins = []coremain.PluginConfig{
+		{
+			Tag:  "main_sequence",
+			Type: "sequence",
+			Args: []map[string]interface{}{{"exec": "reject 3"}},
+		},
+		{
+			Tag:  "udp_server",
+			Type: "udp_server",
+			Args: map[string]interface{}{
+				"entry":  "main_sequence",
+				"listen": listen,
+			},
+		},
+	}
+	require.NoError(t, coremain.CheckConfig(cfg))
+	c, err := net.ListenPacket("udp", listen)
+	require.NoError(t, err)
+	_ = c.Close()
+}
@@ -0,0 +1,70 @@
+package integration_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin" // Import all plugins to ensure they're registered
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfigVars tests variable substitution and glob includes.
+func TestConfigVars(t *testing.T) {
+	testPort := 15362
+	t.Setenv("MOSDNS_TEST_LISTEN", fmt.Sprintf("127.0.0.1:%d", testPort))
+
+	dir := t.TempDir()
+	confD := filepath.Join(dir, "conf.d")
+	require.NoError(t, os.Mkdir(confD, 0755))
+	// Files are loaded in lexical order, so 20-host.yaml overrides 10-shared.yaml.
+	require.NoError(t, os.WriteFile(filepath.Join(confD, "10-shared.yaml"), []byte(`
+vars:
+  rcode: "2"
+plugins:
+  - tag: main_sequence
+    type: sequence
+    args:
+      - exec: reject ${rcode}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(confD, "20-host.yaml"), []byte(`
+vars:
+  rcode: "5"
+`), 0644))
+
+	cfg := &coremain.Config{
+		Log:     mlog.LogConfig{Level: "error"},
+		Include: []string{filepath.Join(confD, "*.yaml")},
+		Vars:    map[string]string{"listen": "${env:MOSDNS_TEST_LISTEN}"},
+		Plugins: []coremain.PluginConfig{
+			{
+				Tag:  "udp_server",
+				Type: "udp_server",
+				Args: map[string]interface{}{
+					"entry":  "main_sequence",
+					"listen": "${listen}",
+				},
+			},
+		},
+	}
+
+	server, err := coremain.NewMosdns(cfg)
+	require.NoError(t, err)
+	defer server.CloseWithErr(nil)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	client := dns.Client{Net: "udp"}
+	resp, _, err := client.Exchange(m, fmt.Sprintf("127.0.0.1:%d", testPort))
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeRefused, resp.Rcode)
+
+	// Undefined variables are errors.
+	cfg.Include = nil
+	cfg.Plugins[0].Args = map[string]interface{}{"entry": "main_sequence", "listen": "${no_such_var}"}
+	require.ErrorContains(t, coremain.CheckConfig(cfg), "undefined variable no_such_var")
+}
@@ -0,0 +1,102 @@
+package integration_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin" // Import all plugins to ensure they're registered
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadOrder tests that plugins can refer to plugins declared after them,
+// including plugins from include files.
+func TestLoadOrder(t *testing.T) {
+	testPort := 15361
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub.yaml")
+	require.NoError(t, os.WriteFile(sub, []byte(`
+plugins:
+  - tag: sub_sequence
+    type: sequence
+    args:
+      - matches: qname $blocked
+        exec: reject 3
+`), 0644))
+
+	cfg := &coremain.Config{
+		Log:     mlog.LogConfig{Level: "error"},
+		Include: []string{sub},
+		Plugins: []coremain.PluginConfig{
+			{
+				Tag:  "udp_server",
+				Type: "udp_server",
+				Args: map[string]interface{}{
+					"entry":  "main_sequence",
+					"listen": fmt.Sprintf("127.0.0.1:%d", testPort),
+				},
+			},
+			{
+				Tag:  "main_sequence",
+				Type: "sequence",
+				Args: []map[string]interface{}{
+					{"exec": "jump sub_sequence"},
+					{"exec": "reject 5"},
+				},
+			},
+			{
+				Tag:  "blocked",
+				Type: "domain_set",
+				Args: map[string]interface{}{"exps": []string{"blocked.example"}},
+			},
+		},
+	}
+
+	server, err := coremain.NewMosdns(cfg)
+	require.NoError(t, err)
+	defer server.CloseWithErr(nil)
+
+	query := func(name string) int {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		client := dns.Client{Net: "udp"}
+		resp, _, err := client.Exchange(m, fmt.Sprintf("127.0.0.1:%d", testPort))
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp.Rcode
+	}
+	require.Equal(t, dns.RcodeNameError, query("blocked.example."))
+	require.Equal(t, dns.RcodeRefused, query("example.com."))
+
+	// Reference cycles are reported with their path.
+	cfg.Include = nil
+	cfg.Plugins = []coremain.PluginConfig{
+		{Tag: "a", Type: "sequence", Args: []map[string]interface{}{{"exec": "jump b"}}},
+		{Tag: "b", Type: "sequence", Args: []map[string]interface{}{{"exec": "$a"}}},
+	}
+	err = coremain.CheckConfig(cfg)
+	require.ErrorContains(t, err, "a -> b -> a")
+
+	// Including jumps of inline sequences and jumps to the sequence itself.
+	inline := func(exec string) []map[string]interface{} {
+		return []map[string]interface{}{{"exec": map[string]interface{}{
+			"type": "sequence",
+			"args": []map[string]interface{}{{"exec": exec}},
+		}}}
+	}
+	cfg.Plugins = []coremain.PluginConfig{
+		{Tag: "a", Type: "sequence", Args: inline("goto b")},
+		{Tag: "b", Type: "sequence", Args: []map[string]interface{}{{"exec": "jump a"}}},
+	}
+	err = coremain.CheckConfig(cfg)
+	require.ErrorContains(t, err, "a -> b -> a")
+	cfg.Plugins = []coremain.PluginConfig{
+		{Tag: "a", Type: "sequence", Args: inline("jump a")},
+	}
+	err = coremain.CheckConfig(cfg)
+	require.ErrorContains(t, err, "a -> a")
+}
@@ -0,0 +1,107 @@
+package integration_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/IrineSistiana/mosdns/v5/pkg/trace"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin" // Import all plugins to ensure they're registered
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReload tests that a reload swaps plugins while the server keeps its socket.
+func TestReload(t *testing.T) {
+	testPort := 15359
+	newCfg := func(rcode int) *coremain.Config {
+		return &coremain.Config{
+			Log: mlog.LogConfig{
+				Level: "error",
+			},
+			Plugins: []coremain.PluginConfig{
+				{
+					Tag:  "main_sequence",
+					Type: "sequence",
+					Args: []map[string]interface{}{
+						{"exec": fmt.Sprintf("reject %d", rcode)},
+					},
+				},
+				{
+					Tag:  "udp_server",
+					Type: "udp_server",
+					Args: map[string]interface{}{
+						"entry":  "main_sequence",
+						"listen": fmt.Sprintf("127.0.0.1:%d", testPort),
+					},
+				},
+			},
+		}
+	}
+
+	server, err := coremain.NewMosdns(newCfg(dns.RcodeRefused))
+	require.NoError(t, err)
+	sc := server.GetSafeClose()
+	defer server.CloseWithErr(nil)
+
+	query := func() int {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		client := dns.Client{Net: "udp"}
+		resp, _, err := client.Exchange(m, fmt.Sprintf("127.0.0.1:%d", testPort))
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp.Rcode
+	}
+	require.Equal(t, dns.RcodeRefused, query())
+
+	udpServer := server.GetPlugin("udp_server")
+
+	// A successful reload switches the server to the new entry.
+	require.NoError(t, server.Reload(newCfg(dns.RcodeNameError)))
+	require.Equal(t, dns.RcodeNameError, query())
+	require.Same(t, udpServer, server.GetPlugin("udp_server"), "udp server should be reused")
+
+	// A failed reload keeps the old plugins.
+	badCfg := newCfg(dns.RcodeServerFailure)
+	badCfg.Plugins = append(badCfg.Plugins, coremain.PluginConfig{Tag: "bad", Type: "no_such_type"})
+	require.Error(t, server.Reload(badCfg))
+	require.Equal(t, dns.RcodeNameError, query())
+
+	sc.SendCloseSignal(nil)
+	err = sc.WaitClosed()
+	require.NoError(t, err)
+}
+
+// TestReloadTracing tests that a reload rebuilds the tracer only if
+// tracing settings changed.
+func TestReloadTracing(t *testing.T) {
+	dir := t.TempDir()
+	newCfg := func(file string) *coremain.Config {
+		return &coremain.Config{
+			Log:     mlog.LogConfig{Level: "error"},
+			Tracing: trace.Config{SampleRate: 1, File: filepath.Join(dir, file)},
+			Plugins: []coremain.PluginConfig{
+				{Tag: "main_sequence", Type: "sequence", Args: []map[string]interface{}{{"exec": "accept"}}},
+			},
+		}
+	}
+	server, err := coremain.NewMosdns(newCfg("a.jsonl"))
+	require.NoError(t, err)
+	defer server.CloseWithErr(nil)
+	tracer := server.Tracer()
+	require.NotNil(t, tracer)
+
+	require.NoError(t, server.Reload(newCfg("a.jsonl")))
+	require.Same(t, tracer, server.Tracer(), "tracer should be reused")
+
+	require.NoError(t, server.Reload(newCfg("b.jsonl")))
+	require.NotSame(t, tracer, server.Tracer(), "tracer should be rebuilt")
+
+	cfg := newCfg("b.jsonl")
+	cfg.Tracing = trace.Config{}
+	require.NoError(t, server.Reload(cfg))
+	require.Nil(t, server.Tracer())
+}
@@ -0,0 +1,73 @@
+package integration_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	_ "github.com/IrineSistiana/mosdns/v5/plugin" // Import all plugins to ensure they're registered
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+// TestShutdownDrain tests that a query running during shutdown still gets
+// its response, and a query that arrives while draining is refused.
+func TestShutdownDrain(t *testing.T) {
+	testPort := 15363
+	cfg := &coremain.Config{
+		Log: mlog.LogConfig{Level: "error"},
+		Plugins: []coremain.PluginConfig{
+			{
+				Tag:  "main_sequence",
+				Type: "sequence",
+				Args: []map[string]interface{}{
+					{"exec": "sleep 300"},
+					{"exec": "reject 3"},
+				},
+			},
+			{
+				Tag:  "udp_server",
+				Type: "udp_server",
+				Args: map[string]interface{}{
+					"entry":  "main_sequence",
+					"listen": fmt.Sprintf("127.0.0.1:%d", testPort),
+				},
+			},
+		},
+	}
+	server, err := coremain.NewMosdns(cfg)
+	require.NoError(t, err)
+	defer server.CloseWithErr(nil)
+
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+	query := func() <-chan result {
+		c := make(chan result, 1)
+		go func() {
+			m := new(dns.Msg)
+			m.SetQuestion("example.com.", dns.TypeA)
+			client := dns.Client{Net: "udp", Timeout: time.Second * 2}
+			resp, _, err := client.Exchange(m, fmt.Sprintf("127.0.0.1:%d", testPort))
+			c <- result{resp, err}
+		}()
+		return c
+	}
+
+	slow := query()
+	time.Sleep(time.Millisecond * 100) // The slow query is running.
+	server.CloseWithErr(nil)
+	time.Sleep(time.Millisecond * 50) // Draining.
+	late := query()
+
+	r := <-slow
+	require.NoError(t, r.err)
+	require.Equal(t, dns.RcodeNameError, r.resp.Rcode)
+	r = <-late
+	require.NoError(t, r.err)
+	require.Equal(t, dns.RcodeRefused, r.resp.Rcode)
+	require.NoError(t, server.GetSafeClose().WaitClosed())
+}
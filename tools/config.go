@@ -20,6 +20,7 @@
 package tools
 
 import (
+	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/mlog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -67,6 +68,25 @@ func newGenCmd() *cobra.Command {
 	return c
 }
 
+func newCheckCmd() *cobra.Command {
+	var cfgFile string
+	c := &cobra.Command{
+		Use:   "check [-c config_file]",
+		Short: "Check config file. Load all plugins in dry-run mode and report all errors.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := coremain.CheckConfigFile(cfgFile); err != nil {
+				mlog.S().Fatalf("config check failed:\n%v", err)
+			}
+			mlog.S().Info("config is valid")
+		},
+		DisableFlagsInUseLine: true,
+	}
+	c.Flags().StringVarP(&cfgFile, "config", "c", "", "config file")
+	c.MarkFlagFilename("config")
+	return c
+}
+
 func convCfg(in, out string) error {
 	v := viper.New()
 	v.SetConfigFile(in)
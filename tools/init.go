@@ -38,8 +38,15 @@ func init() {
 
 	configCmd := &cobra.Command{
 		Use:   "config",
-		Short: "Tools that can generate/convert mosdns config file.",
+		Short: "Tools that can generate/convert/check mosdns config file.",
 	}
-	configCmd.AddCommand(newGenCmd(), newConvCmd())
+	configCmd.AddCommand(newGenCmd(), newConvCmd(), newCheckCmd())
 	coremain.AddSubCmd(configCmd)
+
+	pluginsCmd := &cobra.Command{
+		Use:   "plugins",
+		Short: "Tools that show information about plugin types.",
+	}
+	pluginsCmd.AddCommand(newSchemaCmd())
+	coremain.AddSubCmd(pluginsCmd)
 }
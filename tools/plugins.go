@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package tools
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/mlog"
+	"github.com/spf13/cobra"
+)
+
+func newSchemaCmd() *cobra.Command {
+	var out string
+	c := &cobra.Command{
+		Use:   "schema [-o output_file] [plugin_type]...",
+		Short: "Print JSON Schema of plugin args and all quick setup types.",
+		Run: func(cmd *cobra.Command, args []string) {
+			s, err := coremain.GetSchema(args...)
+			if err != nil {
+				mlog.S().Fatal(err)
+			}
+			b, err := json.MarshalIndent(s, "", "  ")
+			if err != nil {
+				mlog.S().Fatal(err)
+			}
+			b = append(b, '\n')
+			if len(out) == 0 {
+				_, err = os.Stdout.Write(b)
+			} else {
+				err = os.WriteFile(out, b, 0644)
+			}
+			if err != nil {
+				mlog.S().Fatal(err)
+			}
+		},
+		DisableFlagsInUseLine: true,
+	}
+	c.Flags().StringVarP(&out, "output", "o", "", "output file, default is stdout")
+	c.MarkFlagFilename("output")
+	return c
+}